@@ -0,0 +1,6 @@
+// +k8s:deepcopy-gen=package
+// +groupName=field.vmware.com
+// +groupGoName=Field
+
+// Package v1 contains the v1 API of the argo attach service.
+package v1
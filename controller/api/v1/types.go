@@ -0,0 +1,245 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CredentialModeClientCertificate uses the admin client certificate from the cluster kubeconfig.
+	CredentialModeClientCertificate = "ClientCertificate"
+	// CredentialModeServiceAccount creates a service account in the workload cluster and uses its token.
+	CredentialModeServiceAccount = "ServiceAccount"
+	// CredentialModeImpersonation registers an ArgoNamespace without a token, Argo CD impersonates its
+	// service account through the destinationServiceAccounts of the AppProject.
+	CredentialModeImpersonation = "Impersonation"
+)
+
+const (
+	// DeletionPolicyDelete unregisters the cluster and removes the credentials created for it.
+	DeletionPolicyDelete = "Delete"
+	// DeletionPolicyRetain leaves the registration and the credentials in place and releases the owner references.
+	DeletionPolicyRetain = "Retain"
+	// DeletionPolicyOrphanCredentialsOnly unregisters the cluster and leaves the credentials in place.
+	DeletionPolicyOrphanCredentialsOnly = "OrphanCredentialsOnly"
+)
+
+const (
+	// DetachPolicyProceed unregisters the cluster even if Argo CD applications still deploy to it.
+	DetachPolicyProceed = "Proceed"
+	// DetachPolicyBlock keeps the cluster registered until no application deploys to it.
+	DetachPolicyBlock = "Block"
+	// DetachPolicyDelete deletes the applications and their resources before the cluster is unregistered.
+	DetachPolicyDelete = "Delete"
+	// DetachPolicyDeleteNonCascading deletes the applications and leaves their resources on the cluster.
+	DetachPolicyDeleteNonCascading = "DeleteNonCascading"
+)
+
+const (
+	// SourceNamespaceModeConfigMap adds the namespace to application.namespaces in argocd-cmd-params-cm.
+	SourceNamespaceModeConfigMap = "ConfigMap"
+	// SourceNamespaceModeArgoCD adds the namespace to spec.sourceNamespaces of an Argo CD operator ArgoCD.
+	SourceNamespaceModeArgoCD = "ArgoCD"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ArgoNamespace attaches the supervisor namespace it is created in to an ArgoCD instance.
+type ArgoNamespace struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              *ArgoNamespaceSpec  `json:"spec,omitempty"`
+	Status            ArgoNamespaceStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ArgoNamespaceList is a list of ArgoNamespace objects.
+type ArgoNamespaceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ArgoNamespace `json:"items"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ArgoCluster attaches a workload cluster in the same namespace to an ArgoCD instance.
+type ArgoCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              *ArgoClusterSpec  `json:"spec,omitempty"`
+	Status            ArgoClusterStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ArgoClusterList is a list of ArgoCluster objects.
+type ArgoClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ArgoCluster `json:"items"`
+}
+
+type ArgoNamespaceSpec struct {
+	ClusterName   string `json:"clusterName,omitempty"`
+	ArgoNamespace string `json:"argoNamespace"`
+	// ArgoInstance registers the cluster into an Argo CD running in another cluster.
+	ArgoInstance       *ArgoInstanceReference `json:"argoInstance,omitempty"`
+	ClusterLabels      map[string]string      `json:"clusterLabels,omitempty"`
+	ClusterAnnotations map[string]string      `json:"clusterAnnotations,omitempty"`
+	Project            string                 `json:"project"`
+	ServiceAccount     string                 `json:"serviceAccount,omitempty"`
+	ProxyUrl           string                 `json:"proxyUrl,omitempty"`
+	DisableCompression bool                   `json:"disableCompression,omitempty"`
+	ServerName         string                 `json:"serverName,omitempty"`
+	// DeletionPolicy decides what is removed when the CR is deleted, defaults to Delete.
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+	// DetachPolicy decides what happens to Argo CD applications that still deploy to the cluster
+	// when it is unregistered, defaults to Proceed.
+	DetachPolicy string `json:"detachPolicy,omitempty"`
+	// NameTemplate is a text/template for the cluster name shown in Argo CD.
+	NameTemplate string `json:"nameTemplate,omitempty"`
+	// SecretNameTemplate is a text/template for the name of the argo cluster secret.
+	SecretNameTemplate string `json:"secretNameTemplate,omitempty"`
+	// SourceNamespace lets Argo CD pick up Applications created in the namespace.
+	SourceNamespace *SourceNamespace `json:"sourceNamespace,omitempty"`
+	// ProjectTemplate creates a dedicated AppProject for the namespace, it replaces project.
+	ProjectTemplate *ProjectTemplate `json:"projectTemplate,omitempty"`
+	// CredentialMode is how Argo CD authenticates to the namespace, ServiceAccount (default) or Impersonation.
+	CredentialMode string `json:"credentialMode,omitempty"`
+}
+
+// ProjectTemplate describes the AppProject created for an ArgoNamespace. The project only allows
+// deploying namespaced resources into the namespace.
+type ProjectTemplate struct {
+	// NameTemplate is a text/template for the project name, defaults to {{ .Namespace }}.
+	NameTemplate string `json:"nameTemplate,omitempty"`
+	// SourceRepos the applications of the project may use, none when empty.
+	SourceRepos []string `json:"sourceRepos,omitempty"`
+	// Roles are Argo CD project roles granted to groups.
+	Roles []ProjectRole `json:"roles,omitempty"`
+}
+
+// ProjectRole grants the groups the actions on the applications of the project.
+type ProjectRole struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	// Actions on applications, like get, sync or *, defaults to get.
+	Actions []string `json:"actions,omitempty"`
+}
+
+// SourceNamespace makes the namespace an Argo CD application source namespace ("apps in any namespace").
+type SourceNamespace struct {
+	// Mode selects where the namespace is added, defaults to ConfigMap.
+	Mode string `json:"mode,omitempty"`
+	// ArgoCD is the name of the ArgoCD in argoNamespace for the ArgoCD mode, defaults to argocd.
+	ArgoCD string `json:"argoCD,omitempty"`
+}
+
+type ArgoClusterSpec struct {
+	ClusterName   string `json:"clusterName"`
+	ArgoNamespace string `json:"argoNamespace"`
+	// ArgoInstance registers the cluster into an Argo CD running in another cluster.
+	ArgoInstance       *ArgoInstanceReference `json:"argoInstance,omitempty"`
+	ClusterLabels      map[string]string      `json:"clusterLabels,omitempty"`
+	ClusterAnnotations map[string]string      `json:"clusterAnnotations,omitempty"`
+	ClusterResources   *bool                  `json:"clusterResources,omitempty"`
+	Namespaces         []string               `json:"namespaces,omitempty"`
+	Project            string                 `json:"project"`
+	ProxyUrl           string                 `json:"proxyUrl,omitempty"`
+	DisableCompression bool                   `json:"disableCompression,omitempty"`
+	ServerName         string                 `json:"serverName,omitempty"`
+	// CredentialMode is how Argo CD authenticates to the cluster, defaults to ClientCertificate.
+	CredentialMode string `json:"credentialMode,omitempty"`
+	// DeletionPolicy decides what is removed when the CR is deleted, defaults to Delete.
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+	// DetachPolicy decides what happens to Argo CD applications that still deploy to the cluster
+	// when it is unregistered, defaults to Proceed.
+	DetachPolicy string `json:"detachPolicy,omitempty"`
+	// NameTemplate is a text/template for the cluster name shown in Argo CD.
+	NameTemplate string `json:"nameTemplate,omitempty"`
+	// SecretNameTemplate is a text/template for the name of the argo cluster secret.
+	SecretNameTemplate string `json:"secretNameTemplate,omitempty"`
+	// Targets registers the cluster into several Argo CD instances, without it the spec is the only target.
+	Targets []ArgoTarget `json:"targets,omitempty"`
+}
+
+// ArgoStatus is the status the controller reports on both kinds.
+type ArgoStatus struct {
+	State       string       `json:"state,omitempty"`
+	Message     string       `json:"message,omitempty"`
+	Ready       bool         `json:"ready,omitempty"`
+	LastUpdated *metav1.Time `json:"lastUpdated,omitempty"`
+	// Reason is a machine readable cause of the last failure.
+	Reason string `json:"reason,omitempty"`
+	// Retries counts the failed attempts since the last success while the controller keeps retrying.
+	Retries int32 `json:"retries,omitempty"`
+	// Paused is set while the controller skips the object because of the pause annotation or config.
+	Paused bool `json:"paused,omitempty"`
+	// RegisteredName, SecretName and RegisteredProject are the names and the project the cluster was
+	// last registered with.
+	RegisteredName    string `json:"registeredName,omitempty"`
+	SecretName        string `json:"secretName,omitempty"`
+	RegisteredProject string `json:"registeredProject,omitempty"`
+	// CredentialsHash fingerprints the credentials the cluster was last registered with, a change
+	// means they were rotated.
+	CredentialsHash string `json:"credentialsHash,omitempty"`
+}
+
+type ArgoNamespaceStatus struct {
+	ArgoStatus `json:",inline"`
+}
+
+type ArgoClusterStatus struct {
+	ArgoStatus `json:",inline"`
+	Targets    []ArgoTargetStatus `json:"targets,omitempty"`
+}
+
+// ArgoInstanceReference points at the Argo CD a cluster is registered into. Cluster secrets are
+// written through a kubeconfig secret in the namespace of the CR, or the Argo CD API is used instead.
+type ArgoInstanceReference struct {
+	KubeconfigSecret string `json:"kubeconfigSecret,omitempty"`
+	// Key in the secret holding the kubeconfig, defaults to value.
+	Key string `json:"key,omitempty"`
+	// Namespace Argo CD runs in on the remote cluster, defaults to argoNamespace.
+	Namespace string `json:"namespace,omitempty"`
+	// API registers clusters through the Argo CD API instead of writing cluster secrets.
+	API *ArgoAPIReference `json:"api,omitempty"`
+}
+
+// ArgoAPIReference selects the Argo CD API server and a secret in the namespace of the CR with an API token.
+type ArgoAPIReference struct {
+	Server      string `json:"server"`
+	TokenSecret string `json:"tokenSecret"`
+	// TokenKey in the secret holding the token, defaults to token.
+	TokenKey string `json:"tokenKey,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+}
+
+// ArgoTarget registers the cluster into one Argo CD instance. Fields left empty are taken from the spec.
+type ArgoTarget struct {
+	Name               string                 `json:"name"`
+	ArgoNamespace      string                 `json:"argoNamespace,omitempty"`
+	ArgoInstance       *ArgoInstanceReference `json:"argoInstance,omitempty"`
+	Project            string                 `json:"project,omitempty"`
+	ClusterLabels      map[string]string      `json:"clusterLabels,omitempty"`
+	ClusterAnnotations map[string]string      `json:"clusterAnnotations,omitempty"`
+	ClusterResources   *bool                  `json:"clusterResources,omitempty"`
+	Namespaces         []string               `json:"namespaces,omitempty"`
+	CredentialMode     string                 `json:"credentialMode,omitempty"`
+}
+
+// ArgoTargetStatus is the result of the last reconcile of a target. It records where the target was
+// registered so it can be cleaned up after it is removed from the spec.
+type ArgoTargetStatus struct {
+	Name           string                 `json:"name"`
+	ArgoNamespace  string                 `json:"argoNamespace,omitempty"`
+	ArgoInstance   *ArgoInstanceReference `json:"argoInstance,omitempty"`
+	CredentialMode string                 `json:"credentialMode,omitempty"`
+	State          string                 `json:"state,omitempty"`
+	Message        string                 `json:"message,omitempty"`
+	Ready          bool                   `json:"ready,omitempty"`
+	LastUpdated    *metav1.Time           `json:"lastUpdated,omitempty"`
+}
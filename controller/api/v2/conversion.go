@@ -0,0 +1,404 @@
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/warroyo/argocd-attach-service/api/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConversionDataAnnotation holds the fields the served version can't represent so that
+// converting back to the other version restores them and a round trip is lossless.
+const ConversionDataAnnotation = "field.vmware.com/conversion-data"
+
+type conversionData struct {
+	// ClusterName is the v1 ArgoNamespace clusterName which v2 dropped
+	ClusterName string `json:"clusterName,omitempty"`
+	// KubeconfigRef, ObservedGeneration and Conditions only exist in v2
+	KubeconfigRef      *KubeconfigReference `json:"kubeconfigRef,omitempty"`
+	ObservedGeneration int64                `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition   `json:"conditions,omitempty"`
+}
+
+func (d conversionData) empty() bool {
+	return d.ClusterName == "" && d.KubeconfigRef == nil && d.ObservedGeneration == 0 && len(d.Conditions) == 0
+}
+
+// popConversionData removes the conversion annotation from objMeta and returns its content.
+func popConversionData(objMeta *metav1.ObjectMeta) (conversionData, error) {
+	data := conversionData{}
+	raw, ok := objMeta.Annotations[ConversionDataAnnotation]
+	if !ok {
+		return data, nil
+	}
+	delete(objMeta.Annotations, ConversionDataAnnotation)
+	if len(objMeta.Annotations) == 0 {
+		objMeta.Annotations = nil
+	}
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return data, fmt.Errorf("invalid %s annotation: %w", ConversionDataAnnotation, err)
+	}
+	return data, nil
+}
+
+// KubeconfigRefFrom returns the kubeconfigRef an ArgoCluster read through v1 keeps in its conversion annotation.
+func KubeconfigRefFrom(objMeta metav1.ObjectMeta) (*KubeconfigReference, error) {
+	data, err := popConversionData(objMeta.DeepCopy())
+	return data.KubeconfigRef, err
+}
+
+func pushConversionData(objMeta *metav1.ObjectMeta, data conversionData) error {
+	if data.empty() {
+		return nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if objMeta.Annotations == nil {
+		objMeta.Annotations = map[string]string{}
+	}
+	objMeta.Annotations[ConversionDataAnnotation] = string(raw)
+	return nil
+}
+
+// statusToV1 flattens the Ready condition into the v1 status fields.
+func statusToV1(s ArgoStatus) v1.ArgoStatus {
+	out := v1.ArgoStatus{Reason: s.Reason, Retries: s.Retries, Paused: meta.IsStatusConditionTrue(s.Conditions, ConditionPaused),
+		RegisteredName: s.RegisteredName, SecretName: s.SecretName, RegisteredProject: s.RegisteredProject, CredentialsHash: s.CredentialsHash}
+	if ready := meta.FindStatusCondition(s.Conditions, ConditionReady); ready != nil {
+		out.State = ready.Reason
+		out.Message = ready.Message
+		out.Ready = ready.Status == metav1.ConditionTrue
+		if !ready.LastTransitionTime.IsZero() {
+			out.LastUpdated = ready.LastTransitionTime.DeepCopy()
+		}
+	}
+	return out
+}
+
+// statusFromV1 rebuilds the v2 status from a v1 status and whatever was preserved from a
+// previous conversion. If the v1 fields still match the preserved conditions they are used
+// as is, otherwise the status was written through v1 and the Ready condition is replaced.
+func statusFromV1(s v1.ArgoStatus, data conversionData, generation int64) ArgoStatus {
+	preserved := ArgoStatus{ObservedGeneration: data.ObservedGeneration, Conditions: data.Conditions, Reason: s.Reason, Retries: s.Retries,
+		RegisteredName: s.RegisteredName, SecretName: s.SecretName, RegisteredProject: s.RegisteredProject, CredentialsHash: s.CredentialsHash}
+	if equality.Semantic.DeepEqual(statusToV1(preserved), s) {
+		return preserved
+	}
+
+	out := ArgoStatus{ObservedGeneration: data.ObservedGeneration, Reason: s.Reason, Retries: s.Retries,
+		RegisteredName: s.RegisteredName, SecretName: s.SecretName, RegisteredProject: s.RegisteredProject, CredentialsHash: s.CredentialsHash}
+	for _, c := range data.Conditions {
+		if c.Type != ConditionReady && c.Type != ConditionPaused {
+			out.Conditions = append(out.Conditions, c)
+		}
+	}
+	lastTransition := metav1.Time{}
+	if s.LastUpdated != nil {
+		lastTransition = *s.LastUpdated
+	}
+	if s.State != "" {
+		// a v1 writer reports on the object it just read
+		out.ObservedGeneration = generation
+		status := metav1.ConditionFalse
+		if s.Ready {
+			status = metav1.ConditionTrue
+		}
+		out.Conditions = append(out.Conditions, metav1.Condition{
+			Type:               ConditionReady,
+			Status:             status,
+			ObservedGeneration: generation,
+			LastTransitionTime: lastTransition,
+			Reason:             s.State,
+			Message:            s.Message,
+		})
+	}
+	if s.Paused {
+		out.Conditions = append(out.Conditions, metav1.Condition{
+			Type:               ConditionPaused,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: generation,
+			LastTransitionTime: lastTransition,
+			Reason:             "Paused",
+			Message:            s.Message,
+		})
+	}
+	return out
+}
+
+// preserveStatus records the parts of a v2 status that can't be rebuilt from its v1 form.
+func preserveStatus(s ArgoStatus, v1Status v1.ArgoStatus, generation int64, data *conversionData) {
+	if equality.Semantic.DeepEqual(statusFromV1(v1Status, conversionData{}, generation), s) {
+		return
+	}
+	data.ObservedGeneration = s.ObservedGeneration
+	data.Conditions = s.Conditions
+}
+
+func instanceFromV1(in *v1.ArgoInstanceReference) *ArgoInstanceReference {
+	if in == nil {
+		return nil
+	}
+	out := &ArgoInstanceReference{KubeconfigSecret: in.KubeconfigSecret, Key: in.Key, Namespace: in.Namespace}
+	if in.API != nil {
+		api := ArgoAPIReference(*in.API)
+		out.API = &api
+	}
+	return out
+}
+
+func instanceToV1(in *ArgoInstanceReference) *v1.ArgoInstanceReference {
+	if in == nil {
+		return nil
+	}
+	out := &v1.ArgoInstanceReference{KubeconfigSecret: in.KubeconfigSecret, Key: in.Key, Namespace: in.Namespace}
+	if in.API != nil {
+		api := v1.ArgoAPIReference(*in.API)
+		out.API = &api
+	}
+	return out
+}
+
+func projectTemplateFromV1(in *v1.ProjectTemplate) *ProjectTemplate {
+	if in == nil {
+		return nil
+	}
+	out := &ProjectTemplate{NameTemplate: in.NameTemplate, SourceRepos: in.SourceRepos}
+	for _, r := range in.Roles {
+		out.Roles = append(out.Roles, ProjectRole(r))
+	}
+	return out
+}
+
+func projectTemplateToV1(in *ProjectTemplate) *v1.ProjectTemplate {
+	if in == nil {
+		return nil
+	}
+	out := &v1.ProjectTemplate{NameTemplate: in.NameTemplate, SourceRepos: in.SourceRepos}
+	for _, r := range in.Roles {
+		out.Roles = append(out.Roles, v1.ProjectRole(r))
+	}
+	return out
+}
+
+func targetsFromV1(in []v1.ArgoTarget) []ArgoTarget {
+	var out []ArgoTarget
+	for _, t := range in {
+		out = append(out, ArgoTarget{
+			Name:               t.Name,
+			ArgoNamespace:      t.ArgoNamespace,
+			ArgoInstance:       instanceFromV1(t.ArgoInstance),
+			Project:            t.Project,
+			ClusterLabels:      t.ClusterLabels,
+			ClusterAnnotations: t.ClusterAnnotations,
+			ClusterResources:   t.ClusterResources,
+			Namespaces:         t.Namespaces,
+			CredentialMode:     t.CredentialMode,
+		})
+	}
+	return out
+}
+
+func targetsToV1(in []ArgoTarget) []v1.ArgoTarget {
+	var out []v1.ArgoTarget
+	for _, t := range in {
+		out = append(out, v1.ArgoTarget{
+			Name:               t.Name,
+			ArgoNamespace:      t.ArgoNamespace,
+			ArgoInstance:       instanceToV1(t.ArgoInstance),
+			Project:            t.Project,
+			ClusterLabels:      t.ClusterLabels,
+			ClusterAnnotations: t.ClusterAnnotations,
+			ClusterResources:   t.ClusterResources,
+			Namespaces:         t.Namespaces,
+			CredentialMode:     t.CredentialMode,
+		})
+	}
+	return out
+}
+
+func targetStatusFromV1(in []v1.ArgoTargetStatus) []ArgoTargetStatus {
+	var out []ArgoTargetStatus
+	for _, t := range in {
+		out = append(out, ArgoTargetStatus{
+			Name:           t.Name,
+			ArgoNamespace:  t.ArgoNamespace,
+			ArgoInstance:   instanceFromV1(t.ArgoInstance),
+			CredentialMode: t.CredentialMode,
+			State:          t.State,
+			Message:        t.Message,
+			Ready:          t.Ready,
+			LastUpdated:    t.LastUpdated,
+		})
+	}
+	return out
+}
+
+func targetStatusToV1(in []ArgoTargetStatus) []v1.ArgoTargetStatus {
+	var out []v1.ArgoTargetStatus
+	for _, t := range in {
+		out = append(out, v1.ArgoTargetStatus{
+			Name:           t.Name,
+			ArgoNamespace:  t.ArgoNamespace,
+			ArgoInstance:   instanceToV1(t.ArgoInstance),
+			CredentialMode: t.CredentialMode,
+			State:          t.State,
+			Message:        t.Message,
+			Ready:          t.Ready,
+			LastUpdated:    t.LastUpdated,
+		})
+	}
+	return out
+}
+
+// ConvertFrom converts a v1 ArgoCluster into dst.
+func (dst *ArgoCluster) ConvertFrom(src *v1.ArgoCluster) error {
+	dst.TypeMeta = metav1.TypeMeta{APIVersion: SchemeGroupVersion.String(), Kind: "ArgoCluster"}
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	data, err := popConversionData(&dst.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	if src.Spec != nil {
+		dst.Spec = &ArgoClusterSpec{
+			ClusterName:        src.Spec.ClusterName,
+			ArgoNamespace:      src.Spec.ArgoNamespace,
+			ArgoInstance:       instanceFromV1(src.Spec.ArgoInstance),
+			KubeconfigRef:      data.KubeconfigRef,
+			ClusterLabels:      src.Spec.ClusterLabels,
+			ClusterAnnotations: src.Spec.ClusterAnnotations,
+			ClusterResources:   src.Spec.ClusterResources,
+			Namespaces:         src.Spec.Namespaces,
+			Project:            src.Spec.Project,
+			ProxyUrl:           src.Spec.ProxyUrl,
+			DisableCompression: src.Spec.DisableCompression,
+			ServerName:         src.Spec.ServerName,
+			CredentialMode:     src.Spec.CredentialMode,
+			DeletionPolicy:     src.Spec.DeletionPolicy,
+			DetachPolicy:       src.Spec.DetachPolicy,
+			NameTemplate:       src.Spec.NameTemplate,
+			SecretNameTemplate: src.Spec.SecretNameTemplate,
+			Targets:            targetsFromV1(src.Spec.Targets),
+		}
+	}
+	dst.Status = ArgoClusterStatus{
+		ArgoStatus: statusFromV1(src.Status.ArgoStatus, data, src.Generation),
+		Targets:    targetStatusFromV1(src.Status.Targets),
+	}
+	return nil
+}
+
+// ConvertTo converts src into a v1 ArgoCluster.
+func (src *ArgoCluster) ConvertTo(dst *v1.ArgoCluster) error {
+	dst.TypeMeta = metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String(), Kind: "ArgoCluster"}
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	if _, err := popConversionData(&dst.ObjectMeta); err != nil {
+		return err
+	}
+
+	data := conversionData{}
+	if src.Spec != nil {
+		dst.Spec = &v1.ArgoClusterSpec{
+			ClusterName:        src.Spec.ClusterName,
+			ArgoNamespace:      src.Spec.ArgoNamespace,
+			ArgoInstance:       instanceToV1(src.Spec.ArgoInstance),
+			ClusterLabels:      src.Spec.ClusterLabels,
+			ClusterAnnotations: src.Spec.ClusterAnnotations,
+			ClusterResources:   src.Spec.ClusterResources,
+			Namespaces:         src.Spec.Namespaces,
+			Project:            src.Spec.Project,
+			ProxyUrl:           src.Spec.ProxyUrl,
+			DisableCompression: src.Spec.DisableCompression,
+			ServerName:         src.Spec.ServerName,
+			CredentialMode:     src.Spec.CredentialMode,
+			DeletionPolicy:     src.Spec.DeletionPolicy,
+			DetachPolicy:       src.Spec.DetachPolicy,
+			NameTemplate:       src.Spec.NameTemplate,
+			SecretNameTemplate: src.Spec.SecretNameTemplate,
+			Targets:            targetsToV1(src.Spec.Targets),
+		}
+		data.KubeconfigRef = src.Spec.KubeconfigRef
+	}
+	dst.Status = v1.ArgoClusterStatus{
+		ArgoStatus: statusToV1(src.Status.ArgoStatus),
+		Targets:    targetStatusToV1(src.Status.Targets),
+	}
+	preserveStatus(src.Status.ArgoStatus, dst.Status.ArgoStatus, src.Generation, &data)
+	return pushConversionData(&dst.ObjectMeta, data)
+}
+
+// ConvertFrom converts a v1 ArgoNamespace into dst.
+func (dst *ArgoNamespace) ConvertFrom(src *v1.ArgoNamespace) error {
+	dst.TypeMeta = metav1.TypeMeta{APIVersion: SchemeGroupVersion.String(), Kind: "ArgoNamespace"}
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	data, err := popConversionData(&dst.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	preserved := conversionData{}
+	if src.Spec != nil {
+		dst.Spec = &ArgoNamespaceSpec{
+			ArgoNamespace:      src.Spec.ArgoNamespace,
+			ArgoInstance:       instanceFromV1(src.Spec.ArgoInstance),
+			ClusterLabels:      src.Spec.ClusterLabels,
+			ClusterAnnotations: src.Spec.ClusterAnnotations,
+			Project:            src.Spec.Project,
+			ServiceAccount:     src.Spec.ServiceAccount,
+			ProxyUrl:           src.Spec.ProxyUrl,
+			DisableCompression: src.Spec.DisableCompression,
+			ServerName:         src.Spec.ServerName,
+			DeletionPolicy:     src.Spec.DeletionPolicy,
+			DetachPolicy:       src.Spec.DetachPolicy,
+			NameTemplate:       src.Spec.NameTemplate,
+			SecretNameTemplate: src.Spec.SecretNameTemplate,
+			SourceNamespace:    (*SourceNamespace)(src.Spec.SourceNamespace),
+			ProjectTemplate:    projectTemplateFromV1(src.Spec.ProjectTemplate),
+			CredentialMode:     src.Spec.CredentialMode,
+		}
+		preserved.ClusterName = src.Spec.ClusterName
+	}
+	dst.Status = statusFromV1(src.Status.ArgoStatus, data, src.Generation)
+	return pushConversionData(&dst.ObjectMeta, preserved)
+}
+
+// ConvertTo converts src into a v1 ArgoNamespace.
+func (src *ArgoNamespace) ConvertTo(dst *v1.ArgoNamespace) error {
+	dst.TypeMeta = metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String(), Kind: "ArgoNamespace"}
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	preserved, err := popConversionData(&dst.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	data := conversionData{}
+	if src.Spec != nil {
+		dst.Spec = &v1.ArgoNamespaceSpec{
+			ClusterName:        preserved.ClusterName,
+			ArgoNamespace:      src.Spec.ArgoNamespace,
+			ArgoInstance:       instanceToV1(src.Spec.ArgoInstance),
+			ClusterLabels:      src.Spec.ClusterLabels,
+			ClusterAnnotations: src.Spec.ClusterAnnotations,
+			Project:            src.Spec.Project,
+			ServiceAccount:     src.Spec.ServiceAccount,
+			ProxyUrl:           src.Spec.ProxyUrl,
+			DisableCompression: src.Spec.DisableCompression,
+			ServerName:         src.Spec.ServerName,
+			DeletionPolicy:     src.Spec.DeletionPolicy,
+			DetachPolicy:       src.Spec.DetachPolicy,
+			NameTemplate:       src.Spec.NameTemplate,
+			SecretNameTemplate: src.Spec.SecretNameTemplate,
+			SourceNamespace:    (*v1.SourceNamespace)(src.Spec.SourceNamespace),
+			ProjectTemplate:    projectTemplateToV1(src.Spec.ProjectTemplate),
+			CredentialMode:     src.Spec.CredentialMode,
+		}
+	}
+	dst.Status = v1.ArgoNamespaceStatus{ArgoStatus: statusToV1(src.Status)}
+	preserveStatus(src.Status, dst.Status.ArgoStatus, src.Generation, &data)
+	return pushConversionData(&dst.ObjectMeta, data)
+}
@@ -0,0 +1,180 @@
+package v2
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/warroyo/argocd-attach-service/api/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestArgoClusterRoundTrip(t *testing.T) {
+	now := metav1.NewTime(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC))
+	v2Obj := &ArgoCluster{
+		TypeMeta:   metav1.TypeMeta{APIVersion: SchemeGroupVersion.String(), Kind: "ArgoCluster"},
+		ObjectMeta: metav1.ObjectMeta{Name: "wl", Namespace: "default", Generation: 3, Annotations: map[string]string{"keep": "me"}},
+		Spec: &ArgoClusterSpec{
+			ClusterName:   "wl",
+			ArgoNamespace: "argocd",
+			Project:       "default",
+			KubeconfigRef: &KubeconfigReference{Name: "custom", Key: "config"},
+			Namespaces:    []string{"a", "b"},
+			NameTemplate:  "{{ .Namespace }}-{{ .ClusterName }}",
+			Targets: []ArgoTarget{
+				{Name: "platform"},
+				{Name: "tenant", ArgoNamespace: "tenant-argo", Project: "tenant", CredentialMode: CredentialModeServiceAccount,
+					ArgoInstance: &ArgoInstanceReference{API: &ArgoAPIReference{Server: "https://argo", TokenSecret: "token"}}},
+			},
+		},
+		Status: ArgoClusterStatus{
+			ArgoStatus: ArgoStatus{
+				ObservedGeneration: 2,
+				Conditions: []metav1.Condition{
+					{Type: ConditionReady, Status: metav1.ConditionTrue, ObservedGeneration: 2, LastTransitionTime: now, Reason: "Ready", Message: "done"},
+					{Type: "Other", Status: metav1.ConditionFalse, LastTransitionTime: now, Reason: "Nope"},
+				},
+				RegisteredName:    "default-wl",
+				SecretName:        "wl-argo-cluster",
+				RegisteredProject: "default",
+				CredentialsHash:   "0a1b2c3d4e5f6a7b",
+			},
+			Targets: []ArgoTargetStatus{{Name: "tenant", ArgoNamespace: "tenant-argo", State: "Ready", Ready: true, LastUpdated: &now}},
+		},
+	}
+
+	hub := &v1.ArgoCluster{}
+	if err := v2Obj.ConvertTo(hub); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if hub.Status.State != "Ready" || !hub.Status.Ready || hub.Status.Message != "done" {
+		t.Errorf("unexpected v1 status %+v", hub.Status)
+	}
+	if _, ok := hub.Annotations[ConversionDataAnnotation]; !ok {
+		t.Errorf("expected conversion annotation on v1 object")
+	}
+
+	back := &ArgoCluster{}
+	if err := back.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(v2Obj, back) {
+		t.Errorf("round trip mismatch:\nwant %+v\ngot  %+v", v2Obj, back)
+	}
+}
+
+func TestArgoClusterV1StatusWrite(t *testing.T) {
+	v2Obj := &ArgoCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "wl", Generation: 4},
+		Spec:       &ArgoClusterSpec{ClusterName: "wl", ArgoNamespace: "argocd", Project: "default"},
+		Status: ArgoClusterStatus{ArgoStatus: ArgoStatus{
+			ObservedGeneration: 1,
+			Conditions:         []metav1.Condition{{Type: ConditionReady, Status: metav1.ConditionFalse, Reason: "Pending"}},
+		}},
+	}
+	hub := &v1.ArgoCluster{}
+	if err := v2Obj.ConvertTo(hub); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+
+	// a v1 client updates the status
+	hub.Status.State = "Ready"
+	hub.Status.Ready = true
+	hub.Status.Message = "attached"
+
+	back := &ArgoCluster{}
+	if err := back.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if back.Status.ObservedGeneration != 4 {
+		t.Errorf("observedGeneration = %d, want 4", back.Status.ObservedGeneration)
+	}
+	if len(back.Status.Conditions) != 1 {
+		t.Fatalf("conditions = %+v", back.Status.Conditions)
+	}
+	c := back.Status.Conditions[0]
+	if c.Status != metav1.ConditionTrue || c.Reason != "Ready" || c.Message != "attached" {
+		t.Errorf("unexpected ready condition %+v", c)
+	}
+}
+
+func TestPausedCondition(t *testing.T) {
+	hub := &v1.ArgoCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "wl", Generation: 2},
+		Spec:       &v1.ArgoClusterSpec{ClusterName: "wl", ArgoNamespace: "argocd", Project: "default"},
+		Status: v1.ArgoClusterStatus{ArgoStatus: v1.ArgoStatus{
+			State:   "Paused",
+			Ready:   true,
+			Paused:  true,
+			Message: "paused by annotation",
+		}},
+	}
+	v2Obj := &ArgoCluster{}
+	if err := v2Obj.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	paused := meta.FindStatusCondition(v2Obj.Status.Conditions, ConditionPaused)
+	if paused == nil || paused.Status != metav1.ConditionTrue || paused.Message != "paused by annotation" {
+		t.Fatalf("unexpected paused condition in %+v", v2Obj.Status.Conditions)
+	}
+	if !meta.IsStatusConditionTrue(v2Obj.Status.Conditions, ConditionReady) {
+		t.Errorf("ready condition was lost while paused: %+v", v2Obj.Status.Conditions)
+	}
+
+	// unpausing through v1 drops the condition
+	back := &v1.ArgoCluster{}
+	if err := v2Obj.ConvertTo(back); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if !back.Status.Paused {
+		t.Errorf("paused was lost in the round trip")
+	}
+	back.Status = v1.ArgoClusterStatus{ArgoStatus: v1.ArgoStatus{State: "Ready", Ready: true, Message: "attached"}}
+	if err := v2Obj.ConvertFrom(back); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if meta.FindStatusCondition(v2Obj.Status.Conditions, ConditionPaused) != nil {
+		t.Errorf("paused condition remained after unpausing: %+v", v2Obj.Status.Conditions)
+	}
+}
+
+func TestArgoNamespaceRoundTrip(t *testing.T) {
+	v1Obj := &v1.ArgoNamespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String(), Kind: "ArgoNamespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: "ns", Namespace: "team", Generation: 1},
+		Spec: &v1.ArgoNamespaceSpec{
+			ClusterName:    "legacy",
+			ArgoNamespace:  "argocd",
+			Project:        "default",
+			ServiceAccount: "sa",
+			CredentialMode: v1.CredentialModeImpersonation,
+			SourceNamespace: &v1.SourceNamespace{
+				Mode:   v1.SourceNamespaceModeArgoCD,
+				ArgoCD: "gitops",
+			},
+			ProjectTemplate: &v1.ProjectTemplate{
+				NameTemplate: "tenant-{{ .Namespace }}",
+				SourceRepos:  []string{"https://git.example.com/team/*"},
+				Roles:        []v1.ProjectRole{{Name: "dev", Groups: []string{"team-dev"}, Actions: []string{"get", "sync"}}},
+			},
+		},
+		Status: v1.ArgoNamespaceStatus{ArgoStatus: v1.ArgoStatus{State: "Ready", Ready: true, RegisteredName: "team", SecretName: "team-argo-cluster", RegisteredProject: "team", CredentialsHash: "7b6a5f4e3d2c1b0a"}},
+	}
+
+	v2Obj := &ArgoNamespace{}
+	if err := v2Obj.ConvertFrom(v1Obj); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if v2Obj.Annotations[ConversionDataAnnotation] == "" {
+		t.Errorf("expected clusterName to be preserved in an annotation")
+	}
+
+	back := &v1.ArgoNamespace{}
+	if err := v2Obj.ConvertTo(back); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(v1Obj, back) {
+		t.Errorf("round trip mismatch:\nwant %+v\ngot  %+v", v1Obj, back)
+	}
+}
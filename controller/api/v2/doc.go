@@ -0,0 +1,7 @@
+// +k8s:deepcopy-gen=package
+// +groupName=field.vmware.com
+// +groupGoName=Field
+
+// Package v2 contains the v2 API of the argo attach service. v2 is the storage version,
+// objects written through v1 are converted by the conversion webhook in the controller.
+package v2
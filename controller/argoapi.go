@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// argoAPICluster is the Cluster object accepted by the Argo CD /api/v1/clusters endpoints.
+type argoAPICluster struct {
+	Server           string            `json:"server"`
+	Name             string            `json:"name"`
+	Config           ArgoConfig        `json:"config"`
+	Namespaces       []string          `json:"namespaces,omitempty"`
+	ClusterResources bool              `json:"clusterResources,omitempty"`
+	Project          string            `json:"project,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Annotations      map[string]string `json:"annotations,omitempty"`
+}
+
+type argoAPIClient struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+func newArgoAPIClient(client dynamic.Interface, namespace string, ref *argov1.ArgoAPIReference) (*argoAPIClient, error) {
+	secret, err := client.Resource(secretGVR).Namespace(namespace).Get(context.TODO(), ref.TokenSecret, metav1.GetOptions{})
+	if err != nil {
+		err = fmt.Errorf("unable to get argo api token secret %s: %w", ref.TokenSecret, err)
+		if apierrors.IsNotFound(err) {
+			return nil, waitingError(ReasonSecretNotFound, err)
+		}
+		return nil, err
+	}
+	key := ref.TokenKey
+	if key == "" {
+		key = "token"
+	}
+	encoded, found, _ := unstructured.NestedString(secret.Object, "data", key)
+	if !found {
+		return nil, terminalError(ReasonInvalidSpec, fmt.Errorf("%s does not exist in argo api token secret %s/%s", key, namespace, ref.TokenSecret))
+	}
+	token, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, terminalError(ReasonInvalidSpec, fmt.Errorf("failed to decode argo api token: %v", err))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ref.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &argoAPIClient{
+		server: strings.TrimSuffix(ref.Server, "/"),
+		token:  strings.TrimSpace(string(token)),
+		http:   &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (a *argoAPIClient) do(method, path string, body interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, a.server+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("argo api %s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(respBody)))
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			// the token secret can be fixed without touching the spec
+			return resp.StatusCode, waitingError(ReasonArgoAPIUnauthorized, err)
+		case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+			return resp.StatusCode, err
+		case resp.StatusCode >= 400 && resp.StatusCode < 500:
+			return resp.StatusCode, terminalError(ReasonArgoAPIRejected, err)
+		}
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
+
+// upsertCluster creates the cluster or updates it if one with the same server already exists.
+func (a *argoAPIClient) upsertCluster(cluster *argoAPICluster) error {
+	_, err := a.do(http.MethodPost, "/api/v1/clusters?upsert=true", cluster)
+	return err
+}
+
+// deleteCluster removes the cluster by name, a cluster that is already gone is not an error.
+func (a *argoAPIClient) deleteCluster(name string) error {
+	status, err := a.do(http.MethodDelete, "/api/v1/clusters/"+url.PathEscape(name)+"?id.type=name", nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// apiClusterFromSecret maps the fields of an argo cluster secret to the API Cluster object.
+func apiClusterFromSecret(argoCluster *argov1.ArgoCluster, secretData map[string]string) (*argoAPICluster, error) {
+	cluster := &argoAPICluster{
+		Server:      secretData["server"],
+		Name:        secretData["name"],
+		Project:     secretData["project"],
+		Labels:      argoCluster.Spec.ClusterLabels,
+		Annotations: argoCluster.Spec.ClusterAnnotations,
+	}
+	if err := json.Unmarshal([]byte(secretData["config"]), &cluster.Config); err != nil {
+		return nil, terminalError(ReasonInvalidSpec, fmt.Errorf("invalid argo cluster config: %v", err))
+	}
+	if namespaces := secretData["namespaces"]; namespaces != "" {
+		cluster.Namespaces = strings.Split(namespaces, ",")
+	}
+	if clusterResources := secretData["clusterResources"]; clusterResources != "" {
+		cluster.ClusterResources, _ = strconv.ParseBool(clusterResources)
+	}
+	return cluster, nil
+}
+
+// usesArgoAPI reports whether the cluster is registered through the Argo CD API. A client standing
+// in for remote instances gets the equivalent cluster secret instead.
+func usesArgoAPI(client dynamic.Interface, ref *argov1.ArgoInstanceReference) bool {
+	_, standIn := client.(remoteStandIn)
+	return ref != nil && ref.API != nil && !standIn
+}
+
+// registerCluster writes the argo cluster registration, either as a cluster secret on the
+// local or remote argo cluster or through the Argo CD API when the instance selects it.
+func registerCluster(client dynamic.Interface, namespace string, argoCluster *argov1.ArgoCluster, secretName string, secretData map[string]string) error {
+	ref := argoCluster.Spec.ArgoInstance
+	if !usesArgoAPI(client, ref) {
+		target, argoNamespace, err := argoTarget(client, namespace, argoCluster.Spec.ArgoNamespace, ref)
+		if err != nil {
+			return err
+		}
+		argoCluster.Spec.ArgoNamespace = argoNamespace
+		return applySecret(target, argoCluster, secretName, secretData)
+	}
+
+	api, err := newArgoAPIClient(client, namespace, ref.API)
+	if err != nil {
+		return err
+	}
+	cluster, err := apiClusterFromSecret(argoCluster, secretData)
+	if err != nil {
+		return err
+	}
+	if err := api.upsertCluster(cluster); err != nil {
+		return err
+	}
+	log.Printf("registered cluster %s through argo api %s", cluster.Name, api.server)
+	return nil
+}
+
+// unregisterCluster removes what registerCluster created under names.
+func unregisterCluster(client dynamic.Interface, namespace string, argoCluster *argov1.ArgoCluster, names clusterNames) error {
+	clusterName := names.name
+	secretName := names.secret
+	ref := argoCluster.Spec.ArgoInstance
+	if !usesArgoAPI(client, ref) {
+		target, argoNamespace, err := argoTarget(client, namespace, argoCluster.Spec.ArgoNamespace, ref)
+		if apierrors.IsNotFound(err) {
+			log.Printf("argo instance kubeconfig is gone, not cleaning up remote cluster secret %s: %v", secretName, err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := guardDetach(target, argoNamespace, secretName, argoCluster.Spec.DetachPolicy); err != nil {
+			return err
+		}
+		return deleteSecret(target, argoNamespace, secretName)
+	}
+
+	api, err := newArgoAPIClient(client, namespace, ref.API)
+	if apierrors.IsNotFound(err) {
+		log.Printf("argo api token is gone, not unregistering cluster %s: %v", clusterName, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if policy := argoCluster.Spec.DetachPolicy; policy != "" && policy != argov1.DetachPolicyProceed {
+		log.Printf("detachPolicy %s is not supported for argo api instances, unregistering cluster %s anyway", policy, clusterName)
+	}
+	if err := api.deleteCluster(clusterName); err != nil {
+		return err
+	}
+	log.Printf("unregistered cluster %s through argo api %s", clusterName, api.server)
+	return nil
+}
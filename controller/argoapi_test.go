@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type argoAPIStub struct {
+	mu       sync.Mutex
+	clusters map[string]argoAPICluster
+	requests []string
+}
+
+func newArgoAPIStub(t *testing.T) (*argoAPIStub, *httptest.Server) {
+	stub := &argoAPIStub{clusters: map[string]argoAPICluster{}}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.requests = append(stub.requests, r.Method+" "+r.URL.RequestURI())
+		if r.Header.Get("Authorization") != "Bearer argo-token" {
+			http.Error(w, `{"error":"invalid session"}`, http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/clusters":
+			cluster := argoAPICluster{}
+			if err := json.NewDecoder(r.Body).Decode(&cluster); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, exists := stub.clusters[cluster.Name]; exists && r.URL.Query().Get("upsert") != "true" {
+				http.Error(w, `{"error":"existing cluster spec is different"}`, http.StatusBadRequest)
+				return
+			}
+			stub.clusters[cluster.Name] = cluster
+			_ = json.NewEncoder(w).Encode(cluster)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/v1/clusters/"):
+			name := strings.TrimPrefix(r.URL.Path, "/api/v1/clusters/")
+			if r.URL.Query().Get("id.type") != "name" {
+				http.Error(w, "expected id.type=name", http.StatusBadRequest)
+				return
+			}
+			if _, ok := stub.clusters[name]; !ok {
+				http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+				return
+			}
+			delete(stub.clusters, name)
+			_, _ = w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return stub, server
+}
+
+func TestArgoClusterAPIBackend(t *testing.T) {
+	stub, server := newArgoAPIStub(t)
+	spec := map[string]interface{}{
+		"clusterName":   "wl",
+		"argoNamespace": "argocd",
+		"project":       "platform",
+		"clusterLabels": map[string]interface{}{"env": "dev"},
+		"namespaces":    []interface{}{"app1", "app2"},
+		"argoInstance": map[string]interface{}{
+			"api": map[string]interface{}{"server": server.URL, "tokenSecret": "argo-api", "insecure": true},
+		},
+	}
+	client := newFakeClient(
+		newArgoCluster("wl", spec),
+		newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"),
+		newSecret("default", "argo-api", map[string]interface{}{"token": base64.StdEncoding.EncodeToString([]byte("argo-token\n"))}),
+	)
+	c := newTestClusterController(client, nil)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") != nil {
+		t.Error("argo secret should not be written when using the api backend")
+	}
+	cluster, ok := stub.clusters["wl"]
+	if !ok {
+		t.Fatalf("cluster was not registered, requests: %v", stub.requests)
+	}
+	if cluster.Server != "https://10.0.0.1:6443" || cluster.Project != "platform" || !cluster.ClusterResources {
+		t.Errorf("unexpected cluster %+v", cluster)
+	}
+	if strings.Join(cluster.Namespaces, ",") != "app1,app2" || cluster.Labels["env"] != "dev" {
+		t.Errorf("unexpected namespaces or labels %+v", cluster)
+	}
+	if cluster.Config.TLSClientConfig == nil || cluster.Config.TLSClientConfig.KeyData != base64.StdEncoding.EncodeToString([]byte("key")) {
+		t.Errorf("unexpected config %+v", cluster.Config)
+	}
+
+	// the api has no secret, a new secret name keeps the cluster registered
+	renamed := getObject(t, client, argoClusterGVR, "default", "wl")
+	_ = unstructured.SetNestedField(renamed.Object, "{{ .ClusterName }}-renamed", "spec", "secretNameTemplate")
+	if err := client.Tracker().Update(argoClusterGVR, renamed, "default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := stub.clusters["wl"]; !ok {
+		t.Fatalf("cluster was unregistered after a secret name change, requests: %v", stub.requests)
+	}
+
+	if err := client.Tracker().Update(argoClusterGVR, markDeleted(getObject(t, client, argoClusterGVR, "default", "wl")), "default"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := deleteClusterCleanup(client, getObject(t, client, argoClusterGVR, "default", "wl"), nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(stub.clusters) != 0 {
+		t.Errorf("cluster was not unregistered: %v", stub.clusters)
+	}
+}
+
+func TestArgoAPIUnauthorized(t *testing.T) {
+	_, server := newArgoAPIStub(t)
+	spec := map[string]interface{}{
+		"clusterName":   "wl",
+		"argoNamespace": "argocd",
+		"project":       "default",
+		"argoInstance": map[string]interface{}{
+			"api": map[string]interface{}{"server": server.URL, "tokenSecret": "argo-api", "insecure": true},
+		},
+	}
+	client := newFakeClient(
+		newArgoCluster("wl", spec),
+		newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"),
+		newSecret("default", "argo-api", map[string]interface{}{"token": base64.StdEncoding.EncodeToString([]byte("wrong"))}),
+	)
+	err := applyArgoCluster(client, getObject(t, client, argoClusterGVR, "default", "wl"), nil)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+	// a fixed token secret is picked up without a spec change
+	if kind, reason := classifyError(err); kind != errorWaiting || reason != ReasonArgoAPIUnauthorized {
+		t.Errorf("classified as %v %s, want waiting %s", kind, reason, ReasonArgoAPIUnauthorized)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+// the secret types the controller reads, other secrets are never cached
+const (
+	kubeconfigSecretType = "cluster.x-k8s.io/secret"
+	tokenSecretType      = "kubernetes.io/service-account-token"
+)
+
+// newListWatchInformer watches gvr in namespace, metav1.NamespaceAll watches every namespace.
+// fieldSelector limits the objects that are cached.
+func newListWatchInformer(client dynamic.Interface, gvr schema.GroupVersionResource, namespace, fieldSelector string, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = fieldSelector
+				return client.Resource(gvr).Namespace(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = fieldSelector
+				return client.Resource(gvr).Namespace(namespace).Watch(context.TODO(), options)
+			},
+		},
+		&unstructured.Unstructured{},
+		resyncPeriod,
+		cache.Indexers{},
+	)
+}
+
+// resourceCache serves reads from the informers of the client the controllers run with. A resource
+// can have several informers, one per watched namespace or field selector.
+type resourceCache struct {
+	client   dynamic.Interface
+	indexers map[schema.GroupVersionResource][]cache.Indexer
+}
+
+// informerCache is set once the controllers are started, reads fall back to the API server without it.
+var informerCache *resourceCache
+
+func newResourceCache(client dynamic.Interface) *resourceCache {
+	return &resourceCache{client: client, indexers: map[schema.GroupVersionResource][]cache.Indexer{}}
+}
+
+func (c *resourceCache) add(gvr schema.GroupVersionResource, informer cache.SharedIndexInformer) {
+	c.indexers[gvr] = append(c.indexers[gvr], informer.GetIndexer())
+}
+
+// startCacheInformers adds informers for the secrets and service accounts the provision funcs read.
+// tokenHandler, if set, gets the events of the service account token secrets.
+func (c *resourceCache) startCacheInformers(ctx context.Context, namespaces []string, tokenHandler cache.ResourceEventHandler) []cache.InformerSynced {
+	var synced []cache.InformerSynced
+	for _, namespace := range watchedNamespaces(namespaces) {
+		informers := []struct {
+			gvr      schema.GroupVersionResource
+			selector string
+			handler  cache.ResourceEventHandler
+		}{
+			{secretGVR, fields.OneTermEqualSelector("type", kubeconfigSecretType).String(), nil},
+			{secretGVR, fields.OneTermEqualSelector("type", tokenSecretType).String(), tokenHandler},
+			{saGVR, "", nil},
+		}
+		for _, i := range informers {
+			informer := newListWatchInformer(c.client, i.gvr, namespace, i.selector, 0)
+			c.add(i.gvr, informer)
+			if i.handler != nil {
+				informer.AddEventHandler(i.handler)
+			}
+			go informer.Run(ctx.Done())
+			synced = append(synced, informer.HasSynced)
+		}
+	}
+	return synced
+}
+
+// startSourceInformers watches the objects metadata is propagated from. They aren't waited for,
+// without the CAPI CRDs there is nothing to propagate and reads fall back to the API server until
+// they are synced. Namespaces are only watched cluster wide, in namespaced mode changes to them are
+// picked up on resync.
+func (c *resourceCache) startSourceInformers(ctx context.Context, namespaces []string, clusterHandler, namespaceHandler cache.ResourceEventHandler) {
+	run := func(gvr schema.GroupVersionResource, namespace string, handler cache.ResourceEventHandler) {
+		informer := newListWatchInformer(c.client, gvr, namespace, "", 0)
+		c.add(gvr, informer)
+		informer.AddEventHandler(handler)
+		go informer.Run(ctx.Done())
+	}
+	for _, namespace := range watchedNamespaces(namespaces) {
+		run(capiClusterGVR, namespace, clusterHandler)
+	}
+	if len(namespaces) == 0 {
+		run(namespaceGVR, metav1.NamespaceAll, namespaceHandler)
+	}
+}
+
+// list returns the cached objects of gvr in namespace.
+func (c *resourceCache) list(gvr schema.GroupVersionResource, namespace string) []*unstructured.Unstructured {
+	var objs []*unstructured.Unstructured
+	for _, indexer := range c.indexers[gvr] {
+		for _, obj := range indexer.List() {
+			if u, ok := obj.(*unstructured.Unstructured); ok && u.GetNamespace() == namespace {
+				objs = append(objs, u)
+			}
+		}
+	}
+	return objs
+}
+
+// get returns a copy of the cached object, ok is false if no informer has it.
+func (c *resourceCache) get(gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, bool) {
+	key := name
+	if namespace != "" {
+		key = fmt.Sprintf("%s/%s", namespace, name)
+	}
+	for _, indexer := range c.indexers[gvr] {
+		obj, exists, err := indexer.GetByKey(key)
+		if err != nil || !exists {
+			continue
+		}
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			return u.DeepCopy(), true
+		}
+	}
+	return nil, false
+}
+
+// cachedGet reads an object from the informer cache when client is the one the cache was built for.
+// A cache miss still goes to the API server, the object may be newer than the cache or of a type
+// that isn't cached. Reads right after a write should use the client directly.
+func cachedGet(client dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	if c := informerCache; c != nil && c.client == client {
+		if obj, ok := c.get(gvr, namespace, name); ok {
+			return obj, nil
+		}
+	}
+	return client.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
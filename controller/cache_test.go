@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestReconcileFromCache(t *testing.T) {
+	spec := map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default"}
+	client := newFakeClient(
+		newArgoCluster("wl", spec, argoClusterFinalizer),
+		newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"),
+	)
+	c := newTestClusterController(client, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resources := newResourceCache(client)
+	informer := newListWatchInformer(client, argoClusterGVR, "", "", 0)
+	resources.add(argoClusterGVR, informer)
+	go informer.Run(ctx.Done())
+	synced := append(resources.startCacheInformers(ctx, nil, nil), informer.HasSynced)
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		t.Fatal("cache did not sync")
+	}
+	informerCache = resources
+	defer func() { informerCache = nil }()
+
+	client.ClearActions()
+	for i := 0; i < 3; i++ {
+		if _, err := c.reconcileByKey("default/wl"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" {
+			t.Errorf("unexpected get of %s, it should be served from the cache", action.GetResource().Resource)
+		}
+	}
+	if getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") == nil {
+		t.Errorf("argo secret was not created")
+	}
+
+	// other clients, like a remote cluster, never read from the cache
+	other := newFakeClient()
+	if _, err := cachedGet(other, secretGVR, "default", "wl-kubeconfig"); err == nil {
+		t.Errorf("expected a cache miss for a different client")
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// configMapKey is the key in the controller ConfigMap holding the config.
+const configMapKey = "config.yaml"
+
+var configMapGVR = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
+
+// Feature toggles, all of them are enabled unless the config turns them off.
+const (
+	FeatureServiceAccountCredentials = "ServiceAccountCredentials"
+	FeatureArgoAPI                   = "ArgoAPI"
+	FeatureRemoteInstances           = "RemoteInstances"
+)
+
+var knownFeatures = []string{FeatureServiceAccountCredentials, FeatureArgoAPI, FeatureRemoteInstances}
+
+// ControllerConfig is the controller configuration. It starts from the command line flags and is
+// overridden by the fields set in the config file or ConfigMap.
+type ControllerConfig struct {
+	// BlockedNamespaces can't be used as argoNamespace.
+	BlockedNamespaces []string `json:"blockedNamespaces,omitempty"`
+	// AllowedNamespaces are the only namespaces that can be used as argoNamespace when set.
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// Defaults fill in the spec fields a CR leaves empty.
+	Defaults ConfigDefaults `json:"defaults,omitempty"`
+	// WatchNamespaces limits the CRs that are reconciled to these namespaces, changes need a restart.
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+	// ResyncPeriod of the informers, changes need a restart.
+	ResyncPeriod metav1.Duration `json:"resyncPeriod,omitempty"`
+	// Workers per controller, changes need a restart.
+	Workers int `json:"workers,omitempty"`
+	// RateLimiter backs off transient errors, changes need a restart.
+	RateLimiter RateLimiterConfig `json:"rateLimiter,omitempty"`
+	// Features turns optional behaviour on or off.
+	Features map[string]bool `json:"features,omitempty"`
+	// Paused skips provisioning of every CR, deletions are still cleaned up.
+	Paused bool `json:"paused,omitempty"`
+	// Propagation copies metadata of the CAPI Cluster or Namespace to the argo cluster secret.
+	Propagation PropagationConfig `json:"propagation,omitempty"`
+	// Notifications are posted to these endpoints when a CR becomes ready, fails, is detached or its
+	// credentials are rotated.
+	Notifications []NotificationEndpoint `json:"notifications,omitempty"`
+}
+
+type ConfigDefaults struct {
+	Project       string            `json:"project,omitempty"`
+	ArgoNamespace string            `json:"argoNamespace,omitempty"`
+	ClusterLabels map[string]string `json:"clusterLabels,omitempty"`
+	// DeletionPolicy is used by CRs that don't set one, Delete when empty.
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+	// NameTemplate and SecretNameTemplate name the clusters of CRs that don't set their own templates.
+	NameTemplate       string `json:"nameTemplate,omitempty"`
+	SecretNameTemplate string `json:"secretNameTemplate,omitempty"`
+}
+
+type RateLimiterConfig struct {
+	BaseDelay metav1.Duration `json:"baseDelay,omitempty"`
+	MaxDelay  metav1.Duration `json:"maxDelay,omitempty"`
+}
+
+// DeepCopy returns a copy of c that shares no slices or maps with it.
+func (c *ControllerConfig) DeepCopy() *ControllerConfig {
+	out := *c
+	out.BlockedNamespaces = slices.Clone(c.BlockedNamespaces)
+	out.AllowedNamespaces = slices.Clone(c.AllowedNamespaces)
+	out.WatchNamespaces = slices.Clone(c.WatchNamespaces)
+	out.Features = maps.Clone(c.Features)
+	out.Defaults.ClusterLabels = maps.Clone(c.Defaults.ClusterLabels)
+	out.Propagation.Labels = c.Propagation.Labels.deepCopy()
+	out.Propagation.Annotations = c.Propagation.Annotations.deepCopy()
+	if c.Notifications != nil {
+		out.Notifications = make([]NotificationEndpoint, len(c.Notifications))
+		for i, endpoint := range c.Notifications {
+			endpoint.Events = slices.Clone(endpoint.Events)
+			endpoint.Kinds = slices.Clone(endpoint.Kinds)
+			endpoint.Namespaces = slices.Clone(endpoint.Namespaces)
+			out.Notifications[i] = endpoint
+		}
+	}
+	return &out
+}
+
+func defaultConfig() ControllerConfig {
+	return ControllerConfig{
+		ResyncPeriod: metav1.Duration{Duration: 60 * time.Second},
+		Workers:      numWorkers,
+		RateLimiter: RateLimiterConfig{
+			BaseDelay: metav1.Duration{Duration: time.Second},
+			MaxDelay:  metav1.Duration{Duration: 60 * time.Second},
+		},
+	}
+}
+
+func (c *ControllerConfig) validate() error {
+	for _, ns := range slices.Concat(c.BlockedNamespaces, c.AllowedNamespaces, c.WatchNamespaces) {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace %q: %v", ns, errs)
+		}
+	}
+	for _, ns := range c.AllowedNamespaces {
+		if slices.Contains(c.BlockedNamespaces, ns) {
+			return fmt.Errorf("namespace %s is both allowed and blocked", ns)
+		}
+	}
+	if c.Defaults.ArgoNamespace != "" {
+		if errs := validation.IsDNS1123Label(c.Defaults.ArgoNamespace); len(errs) > 0 {
+			return fmt.Errorf("invalid default argoNamespace %q: %v", c.Defaults.ArgoNamespace, errs)
+		}
+	}
+	if _, err := deletionPolicy(c.Defaults.DeletionPolicy); err != nil {
+		return err
+	}
+	if err := c.Propagation.validate(); err != nil {
+		return err
+	}
+	for _, text := range []string{c.Defaults.NameTemplate, c.Defaults.SecretNameTemplate} {
+		if _, err := parseNameTemplate(text); err != nil {
+			return fmt.Errorf("invalid default name template: %w", err)
+		}
+	}
+	names := map[string]bool{}
+	for i := range c.Notifications {
+		if err := c.Notifications[i].validate(); err != nil {
+			return err
+		}
+		if names[c.Notifications[i].Name] {
+			return fmt.Errorf("duplicate notification endpoint %s", c.Notifications[i].Name)
+		}
+		names[c.Notifications[i].Name] = true
+	}
+	if c.ResyncPeriod.Duration < 0 {
+		return fmt.Errorf("resyncPeriod can't be negative")
+	}
+	if c.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1")
+	}
+	if c.RateLimiter.BaseDelay.Duration <= 0 || c.RateLimiter.MaxDelay.Duration < c.RateLimiter.BaseDelay.Duration {
+		return fmt.Errorf("rateLimiter needs a positive baseDelay and a maxDelay of at least baseDelay")
+	}
+	for name := range c.Features {
+		if !slices.Contains(knownFeatures, name) {
+			return fmt.Errorf("unknown feature %s, known features are %v", name, knownFeatures)
+		}
+	}
+	return nil
+}
+
+// featureEnabled is safe to call on a nil config, which has every feature enabled.
+func (c *ControllerConfig) featureEnabled(name string) bool {
+	if c == nil {
+		return true
+	}
+	enabled, ok := c.Features[name]
+	return !ok || enabled
+}
+
+// checkArgoNamespace returns a terminal error if ns can't be used as argoNamespace.
+func (c *ControllerConfig) checkArgoNamespace(ns string) error {
+	if c == nil {
+		return nil
+	}
+	if slices.Contains(c.BlockedNamespaces, ns) {
+		log.Printf("argoNamespace is in the list of blocked namespaces, not creating secret: %v", c.BlockedNamespaces)
+		return terminalError(ReasonBlockedNamespace, fmt.Errorf("argoNamespace is in the list of blocked namespaces, not creating secret: %v", c.BlockedNamespaces))
+	}
+	if len(c.AllowedNamespaces) > 0 && !slices.Contains(c.AllowedNamespaces, ns) {
+		return terminalError(ReasonBlockedNamespace, fmt.Errorf("argoNamespace %s is not in the list of allowed namespaces: %v", ns, c.AllowedNamespaces))
+	}
+	return nil
+}
+
+// restartRequired lists the settings that differ between c and the config the controllers were started with.
+func (c *ControllerConfig) restartRequired(started *ControllerConfig) []string {
+	var changed []string
+	if !slices.Equal(c.WatchNamespaces, started.WatchNamespaces) {
+		changed = append(changed, "watchNamespaces")
+	}
+	if c.ResyncPeriod != started.ResyncPeriod {
+		changed = append(changed, "resyncPeriod")
+	}
+	if c.Workers != started.Workers {
+		changed = append(changed, "workers")
+	}
+	if c.RateLimiter != started.RateLimiter {
+		changed = append(changed, "rateLimiter")
+	}
+	return changed
+}
+
+// configStore holds the active config. Reconciles read it on every run so most settings apply
+// as soon as a new config is loaded.
+type configStore struct {
+	mu      sync.RWMutex
+	base    ControllerConfig
+	current *ControllerConfig
+	started *ControllerConfig
+	source  string
+	// listeners run after a different config became active
+	listeners []func()
+}
+
+func newConfigStore(base ControllerConfig) (*configStore, error) {
+	if err := base.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &configStore{base: *base.DeepCopy(), current: base.DeepCopy(), source: "flags"}, nil
+}
+
+// get returns the active config, callers must not modify it. A nil store has no config.
+func (s *configStore) get() *ControllerConfig {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// markStarted records the config the controllers were started with.
+func (s *configStore) markStarted() *ControllerConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = s.current
+	return s.current
+}
+
+// load parses data over the flag config and makes it active if it is valid. An invalid config
+// is rejected and the previous one stays active.
+func (s *configStore) load(data []byte, source string) error {
+	changed := false
+	// deferred first so the listeners run after the unlock
+	defer func() {
+		if changed {
+			s.reloaded()
+		}
+	}()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// unmarshalling reuses the backing arrays of slices, the base must not share any
+	cfg := *s.base.DeepCopy()
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return fmt.Errorf("unable to parse config from %s: %w", source, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("invalid config from %s: %w", source, err)
+	}
+	if reflect.DeepEqual(&cfg, s.current) {
+		s.source = source
+		return nil
+	}
+	s.current, s.source, changed = &cfg, source, true
+	log.Printf("loaded config from %s", source)
+	if s.started != nil {
+		if changed := cfg.restartRequired(s.started); len(changed) > 0 {
+			log.Printf("config changes to %v only take effect after a restart", changed)
+		}
+	}
+	return nil
+}
+
+// reset goes back to the flag config, used when the ConfigMap is deleted.
+func (s *configStore) reset(source string) {
+	defer s.reloaded()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current, s.source = s.base.DeepCopy(), "flags"
+	log.Printf("%s was removed, using the config from flags", source)
+}
+
+// onReload registers f to run whenever a different config became active.
+func (s *configStore) onReload(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, f)
+}
+
+func (s *configStore) reloaded() {
+	s.mu.RLock()
+	listeners := slices.Clone(s.listeners)
+	s.mu.RUnlock()
+	for _, f := range listeners {
+		f()
+	}
+}
+
+func (s *configStore) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %w", err)
+	}
+	if err := s.load(data, path); err != nil {
+		return err
+	}
+	// the file replaces the flags as the base a ConfigMap is applied on top of
+	s.mu.Lock()
+	s.base = *s.current.DeepCopy()
+	s.mu.Unlock()
+	return nil
+}
+
+// watchConfigMap loads the config from the ConfigMap and reloads it whenever it changes.
+func (s *configStore) watchConfigMap(ctx context.Context, client dynamic.Interface, namespace, name string) cache.SharedIndexInformer {
+	source := fmt.Sprintf("configmap %s/%s", namespace, name)
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	informer := newListWatchInformer(client, configMapGVR, namespace, selector, 0)
+	apply := func(obj interface{}) {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok || u.GetName() != name {
+			return
+		}
+		data, found, _ := unstructured.NestedString(u.Object, "data", configMapKey)
+		if !found {
+			log.Printf("%s has no %s key, keeping the current config", source, configMapKey)
+			return
+		}
+		if err := s.load([]byte(data), source); err != nil {
+			log.Printf("keeping the current config: %v", err)
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    apply,
+		UpdateFunc: func(_, newObj interface{}) { apply(newObj) },
+		DeleteFunc: func(obj interface{}) { s.reset(source) },
+	})
+	go informer.Run(ctx.Done())
+	return informer
+}
+
+// ServeHTTP reports the active config on the debug endpoint.
+func (s *configStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	resp := struct {
+		Source          string            `json:"source"`
+		Config          *ControllerConfig `json:"config"`
+		RestartRequired []string          `json:"restartRequired,omitempty"`
+	}{Source: s.source, Config: s.current.redacted()}
+	if s.started != nil {
+		resp.RestartRequired = s.current.restartRequired(s.started)
+	}
+	s.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// redacted returns a copy of c that is safe to serve, notification urls often embed a token.
+func (c *ControllerConfig) redacted() *ControllerConfig {
+	out := *c
+	out.Notifications = make([]NotificationEndpoint, len(c.Notifications))
+	for i, endpoint := range c.Notifications {
+		endpoint.URL = "<redacted>"
+		out.Notifications[i] = endpoint
+	}
+	return &out
+}
+
+// startDebugServer serves the debug endpoints on addr until ctx is cancelled.
+func startDebugServer(ctx context.Context, addr string, store *configStore) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/config", store)
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	go func() {
+		log.Printf("debug endpoint listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("debug endpoint stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func newConfigMap(data string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "argo-attach-config", "namespace": "argo-attach"},
+		"data":       map[string]interface{}{configMapKey: data},
+	}}
+}
+
+func TestConfigStoreLoad(t *testing.T) {
+	base := defaultConfig()
+	base.BlockedNamespaces = []string{"kube-system"}
+	store, err := newConfigStore(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.markStarted()
+
+	err = store.load([]byte(`
+allowedNamespaces: [argocd]
+defaults:
+  project: platform
+workers: 4
+features:
+  ArgoAPI: false
+`), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := store.get()
+	if strings.Join(cfg.BlockedNamespaces, ",") != "kube-system" {
+		t.Errorf("blocked namespaces from flags were lost: %v", cfg.BlockedNamespaces)
+	}
+	if cfg.Defaults.Project != "platform" || cfg.featureEnabled(FeatureArgoAPI) || !cfg.featureEnabled(FeatureRemoteInstances) {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if changed := cfg.restartRequired(store.started); strings.Join(changed, ",") != "workers" {
+		t.Errorf("restart required for %v, want workers", changed)
+	}
+
+	invalid := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"unknown field", "blockedNamespace: [a]", "unknown field"},
+		{"allowed and blocked", "allowedNamespaces: [kube-system]", "both allowed and blocked"},
+		{"no workers", "workers: 0", "workers"},
+		{"bad rate limiter", "rateLimiter: {baseDelay: 10s, maxDelay: 1s}", "rateLimiter"},
+		{"unknown feature", "features: {Teleport: true}", "unknown feature"},
+		{"unknown deletion policy", "defaults: {deletionPolicy: Keep}", "unknown deletionPolicy"},
+		{"propagation prefix", "propagation: {prefix: example.com}", "has to start with"},
+		{"name template", "defaults: {nameTemplate: '{{ .Namespace '}", "invalid default name template"},
+		{"notification url", "notifications: [{name: chat, url: 'ftp://chat'}]", "http or https url"},
+		{"notification event", "notifications: [{name: chat, url: 'https://chat', events: [Deleted]}]", "unknown event"},
+		{"duplicate endpoint", "notifications: [{name: chat, url: 'https://a'}, {name: chat, url: 'https://b'}]", "duplicate notification endpoint"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.load([]byte(tt.data), "test")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if store.get() != cfg {
+				t.Errorf("an invalid config replaced the active one")
+			}
+		})
+	}
+}
+
+func TestConfigStoreLoadKeepsBase(t *testing.T) {
+	base := defaultConfig()
+	base.Propagation.Labels.Include = []string{"a", "b", "c"}
+	base.Notifications = []NotificationEndpoint{{Name: "chat", URL: "https://chat.example.com", Events: []string{EventReady, EventFailed}}}
+	store, err := newConfigStore(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := "propagation:\n  labels:\n    include: [x]\nnotifications:\n- name: chat\n  url: https://chat.example.com\n  events: [Detached]\n"
+	if err := store.load([]byte(config), "configmap"); err != nil {
+		t.Fatal(err)
+	}
+	// rejected after it was unmarshalled
+	if err := store.load([]byte("propagation:\n  labels:\n    include: [z]\n  prefix: invalid\n"), "configmap"); err == nil {
+		t.Fatal("expected the invalid prefix to be rejected")
+	}
+	if got := store.get().Propagation.Labels.Include; strings.Join(got, ",") != "x" {
+		t.Errorf("active include = %v, want [x]", got)
+	}
+	store.reset("configmap")
+	cfg := store.get()
+	if got := cfg.Propagation.Labels.Include; strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("base include = %v after loading other configs, want [a b c]", got)
+	}
+	if got := cfg.Notifications[0].Events; strings.Join(got, ",") != "Ready,Failed" {
+		t.Errorf("base notification events = %v after loading other configs, want [Ready Failed]", got)
+	}
+	if got := base.Propagation.Labels.Include; strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("config passed to the store changed to %v", got)
+	}
+}
+
+func TestConfigMapReload(t *testing.T) {
+	client := newFakeClient(newConfigMap("blockedNamespaces: [argocd]"))
+	store, err := newConfigStore(defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.watchConfigMap(ctx, client, "argo-attach", "argo-attach-config")
+
+	waitFor := func(desc string, cond func(*ControllerConfig) bool) {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+			return cond(store.get()), nil
+		})
+		if err != nil {
+			t.Fatalf("timed out waiting for %s, config is %+v", desc, store.get())
+		}
+	}
+	waitFor("initial config", func(c *ControllerConfig) bool { return len(c.BlockedNamespaces) == 1 })
+
+	if _, err := client.Resource(configMapGVR).Namespace("argo-attach").Update(ctx, newConfigMap("allowedNamespaces: [argocd]"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("updated config", func(c *ControllerConfig) bool {
+		return len(c.BlockedNamespaces) == 0 && len(c.AllowedNamespaces) == 1
+	})
+
+	// an invalid update keeps the previous config
+	if _, err := client.Resource(configMapGVR).Namespace("argo-attach").Update(ctx, newConfigMap("workers: -1"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if c := store.get(); c.Workers != numWorkers || len(c.AllowedNamespaces) != 1 {
+		t.Errorf("invalid config was applied: %+v", c)
+	}
+}
+
+func TestReconcileWithConfig(t *testing.T) {
+	spec := map[string]interface{}{"clusterName": "wl"}
+	cfg := &ControllerConfig{
+		AllowedNamespaces: []string{"argocd"},
+		Defaults: ConfigDefaults{
+			ArgoNamespace: "argocd",
+			Project:       "platform",
+			ClusterLabels: map[string]string{"env": "dev"},
+		},
+	}
+	client := newFakeClient(newArgoCluster("wl", spec), newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"))
+	if err := applyArgoCluster(client, getObject(t, client, argoClusterGVR, "default", "wl"), cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret := getObject(t, client, secretGVR, "argocd", "wl-argo-cluster")
+	if secret == nil {
+		t.Fatal("argo secret was not created in the default argoNamespace")
+	}
+	if project, _, _ := unstructured.NestedString(secret.Object, "stringData", "project"); project != "platform" || secret.GetLabels()["env"] != "dev" {
+		t.Errorf("defaults were not applied: project %q labels %v", project, secret.GetLabels())
+	}
+
+	spec["argoNamespace"] = "other"
+	client = newFakeClient(newArgoCluster("wl", spec), newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"))
+	err := applyArgoCluster(client, getObject(t, client, argoClusterGVR, "default", "wl"), cfg)
+	if err == nil || !strings.Contains(err.Error(), "not in the list of allowed namespaces") {
+		t.Fatalf("expected allowed namespaces error, got %v", err)
+	}
+	if kind, _ := classifyError(err); kind != errorTerminal {
+		t.Errorf("classified as %v, want terminal", kind)
+	}
+}
+
+func TestDebugConfigEndpoint(t *testing.T) {
+	store, err := newConfigStore(defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.markStarted()
+	config := "resyncPeriod: 5m\nnotifications:\n- name: chat\n  url: https://hooks.example.com/services/s3cret\n"
+	if err := store.load([]byte(config), "configmap argo-attach/argo-attach-config"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/config", nil))
+	resp := struct {
+		Source          string
+		Config          ControllerConfig
+		RestartRequired []string
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+	}
+	if resp.Source != "configmap argo-attach/argo-attach-config" || resp.Config.ResyncPeriod.Duration != 5*time.Minute {
+		t.Errorf("unexpected response %s", rec.Body.String())
+	}
+	if strings.Join(resp.RestartRequired, ",") != "resyncPeriod" {
+		t.Errorf("restartRequired = %v, want resyncPeriod", resp.RestartRequired)
+	}
+	if strings.Contains(rec.Body.String(), "s3cret") || len(resp.Config.Notifications) != 1 || resp.Config.Notifications[0].Name != "chat" {
+		t.Errorf("notification url wasn't redacted: %s", rec.Body.String())
+	}
+	if url := store.get().Notifications[0].URL; url != "https://hooks.example.com/services/s3cret" {
+		t.Errorf("redacting changed the active config: %s", url)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var applicationGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}
+var applicationSetGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applicationsets"}
+
+// resourcesFinalizer makes Argo CD delete the resources of an application before the application itself.
+const resourcesFinalizer = "resources-finalizer.argocd.argoproj.io"
+
+// clusterDestination is how applications refer to a registered cluster, by server url or by name.
+// A cluster registered for some namespaces or a project only serves applications using those.
+type clusterDestination struct {
+	server     string
+	name       string
+	namespaces []string
+	project    string
+}
+
+func newClusterDestination(secret *unstructured.Unstructured) clusterDestination {
+	return clusterDestination{
+		server:     secretValue(secret, "server"),
+		name:       secretValue(secret, "name"),
+		namespaces: splitList(secretValue(secret, "namespaces")),
+		project:    secretValue(secret, "project"),
+	}
+}
+
+// matches reports whether the destination at path in obj points at the cluster. The project is
+// read next to the destination.
+func (d clusterDestination) matches(obj *unstructured.Unstructured, path ...string) bool {
+	server, _, _ := unstructured.NestedString(obj.Object, append(path, "server")...)
+	name, _, _ := unstructured.NestedString(obj.Object, append(path, "name")...)
+	if !(server != "" && server == d.server) && !(name != "" && name == d.name) {
+		return false
+	}
+	namespace, _, _ := unstructured.NestedString(obj.Object, append(path, "namespace")...)
+	if len(d.namespaces) > 0 && !slices.Contains(d.namespaces, namespace) {
+		return false
+	}
+	project, _, _ := unstructured.NestedString(obj.Object, append(slices.Clone(path[:len(path)-1]), "project")...)
+	return d.project == "" || project == d.project
+}
+
+// secretValue reads key from the data of a secret, or from stringData if it hasn't been stored yet.
+func secretValue(secret *unstructured.Unstructured, key string) string {
+	if encoded, found, _ := unstructured.NestedString(secret.Object, "data", key); found {
+		if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			return string(decoded)
+		}
+	}
+	value, _, _ := unstructured.NestedString(secret.Object, "stringData", key)
+	return value
+}
+
+// dependentApplications lists the applications and application sets in namespaces that deploy to
+// dest. Without the Argo CD CRDs there are none.
+func dependentApplications(client dynamic.Interface, namespaces []string, dest clusterDestination) ([]unstructured.Unstructured, error) {
+	var dependents []unstructured.Unstructured
+	kinds := []struct {
+		gvr  schema.GroupVersionResource
+		path []string
+	}{
+		{applicationGVR, []string{"spec", "destination"}},
+		{applicationSetGVR, []string{"spec", "template", "spec", "destination"}},
+	}
+	for _, namespace := range namespaces {
+		for _, kind := range kinds {
+			list, err := client.Resource(kind.gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to list %s in %s: %w", kind.gvr.Resource, namespace, err)
+			}
+			for _, item := range list.Items {
+				if dest.matches(&item, kind.path...) {
+					dependents = append(dependents, item)
+				}
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// checkDetachPolicy returns a terminal error for a detach policy that can't be applied to a cluster
+// registered into ref.
+func checkDetachPolicy(policy string, ref *argov1.ArgoInstanceReference) error {
+	switch policy {
+	case "", argov1.DetachPolicyProceed:
+		return nil
+	case argov1.DetachPolicyBlock, argov1.DetachPolicyDelete, argov1.DetachPolicyDeleteNonCascading:
+	default:
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("unknown detachPolicy %q", policy))
+	}
+	if ref != nil && ref.API != nil {
+		// the applications are matched against the argo cluster secret, the api has none
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("detachPolicy %s isn't supported with the argo api, only %s", policy, argov1.DetachPolicyProceed))
+	}
+	return nil
+}
+
+// guardDetach applies the detach policy before the argo cluster secret secretName is deleted. It
+// returns a waiting error as long as applications still deploy to the cluster and the policy
+// doesn't allow to proceed.
+func guardDetach(client dynamic.Interface, argoNamespace, secretName, policy string) error {
+	if policy == "" || policy == argov1.DetachPolicyProceed {
+		return nil
+	}
+	secret, err := client.Resource(secretGVR).Namespace(argoNamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get argo cluster secret %s: %w", secretName, err)
+	}
+	dest := newClusterDestination(secret)
+	// applications in the source namespaces of the project deploy to the cluster as well
+	var project *unstructured.Unstructured
+	if dest.project != "" {
+		project, err = client.Resource(appProjectGVR).Namespace(argoNamespace).Get(context.TODO(), dest.project, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to get appproject %s/%s: %w", argoNamespace, dest.project, err)
+		}
+	}
+	dependents, err := dependentApplications(client, projectNamespaces(project, argoNamespace), dest)
+	if err != nil || len(dependents) == 0 {
+		return err
+	}
+	names := []string{}
+	for _, d := range dependents {
+		name := d.GetName()
+		if d.GetNamespace() != argoNamespace {
+			name = d.GetNamespace() + "/" + name
+		}
+		names = append(names, fmt.Sprintf("%s %s", strings.ToLower(d.GetKind()), name))
+	}
+
+	switch policy {
+	case argov1.DetachPolicyBlock:
+		return waitingError(ReasonDetachBlocked, fmt.Errorf("detachPolicy is %s and %d applications still deploy to cluster %s: %s",
+			policy, len(dependents), dest.name, strings.Join(names, ", ")))
+	case argov1.DetachPolicyDelete, argov1.DetachPolicyDeleteNonCascading:
+		for _, d := range dependents {
+			if err := deleteApplication(client, &d, policy == argov1.DetachPolicyDelete); err != nil {
+				return err
+			}
+		}
+		// the secret stays until the applications are gone, Argo CD needs it to delete their resources
+		return waitingError(ReasonDetachPending, fmt.Errorf("waiting for %d applications deploying to cluster %s to be deleted: %s",
+			len(dependents), dest.name, strings.Join(names, ", ")))
+	}
+	return terminalError(ReasonInvalidSpec, fmt.Errorf("unknown detachPolicy %q", policy))
+}
+
+// deleteApplication deletes an application or application set. cascade sets the Argo CD resources
+// finalizer on applications so their resources are deleted too, otherwise it is removed.
+func deleteApplication(client dynamic.Interface, app *unstructured.Unstructured, cascade bool) error {
+	if !app.GetDeletionTimestamp().IsZero() {
+		return nil
+	}
+	gvr := applicationSetGVR
+	if app.GetKind() == "Application" {
+		gvr = applicationGVR
+		finalizers := app.GetFinalizers()
+		if cascade && !slices.Contains(finalizers, resourcesFinalizer) {
+			finalizers = append(finalizers, resourcesFinalizer)
+		} else if !cascade {
+			finalizers = removeString(finalizers, resourcesFinalizer)
+		}
+		if !slices.Equal(finalizers, app.GetFinalizers()) {
+			if err := patchFinalizer(context.TODO(), client, gvr, app, resourcesFinalizer, finalizers); err != nil {
+				return err
+			}
+		}
+	}
+	err := client.Resource(gvr).Namespace(app.GetNamespace()).Delete(context.TODO(), app.GetName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete %s %s/%s: %w", gvr.Resource, app.GetNamespace(), app.GetName(), err)
+	}
+	log.Printf("deleted %s %s/%s before detaching its cluster, cascade %v", gvr.Resource, app.GetNamespace(), app.GetName(), cascade)
+	return nil
+}
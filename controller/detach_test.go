@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func newApplication(kind, name string, destination map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "argocd",
+		},
+	}}
+	if kind == "ApplicationSet" {
+		_ = unstructured.SetNestedMap(u.Object, destination, "spec", "template", "spec", "destination")
+	} else {
+		_ = unstructured.SetNestedMap(u.Object, destination, "spec", "destination")
+	}
+	return u
+}
+
+func TestDetachPolicy(t *testing.T) {
+	registered := newSecret("argocd", "wl-argo-cluster", nil)
+	registered.Object["stringData"] = map[string]interface{}{"name": "wl", "server": "https://10.0.0.1:6443"}
+	dependents := []runtime.Object{
+		newApplication("Application", "by-server", map[string]interface{}{"server": "https://10.0.0.1:6443"}),
+		newApplication("ApplicationSet", "by-name", map[string]interface{}{"name": "wl"}),
+	}
+	unrelated := newApplication("Application", "other", map[string]interface{}{"server": "https://10.0.0.2:6443"})
+
+	tests := []struct {
+		name          string
+		policy        string
+		wantWaiting   string
+		wantApps      bool
+		wantFinalizer bool
+	}{
+		{name: "no policy proceeds", wantApps: true},
+		{name: "proceed", policy: "Proceed", wantApps: true},
+		{name: "block keeps the cluster registered", policy: "Block", wantWaiting: ReasonDetachBlocked, wantApps: true},
+		{name: "delete cascades", policy: "Delete", wantWaiting: ReasonDetachPending, wantFinalizer: true},
+		{name: "delete without cascading", policy: "DeleteNonCascading", wantWaiting: ReasonDetachPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default"}
+			if tt.policy != "" {
+				spec["detachPolicy"] = tt.policy
+			}
+			objects := append([]runtime.Object{
+				markDeleted(newArgoCluster("wl", spec, argoClusterFinalizer)),
+				registered.DeepCopy(),
+				unrelated.DeepCopy(),
+			}, dependents...)
+			client := newFakeClient(objects...)
+			c := newTestClusterController(client, nil)
+
+			result, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl"))
+			if tt.wantWaiting == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") != nil {
+					t.Errorf("argo secret was not deleted")
+				}
+				if got := getObject(t, client, applicationGVR, "argocd", "by-server") != nil; got != tt.wantApps {
+					t.Errorf("application present = %v, want %v", got, tt.wantApps)
+				}
+				return
+			}
+
+			if err != nil || result.RequeueAfter != waitingRequeueDelay || result.Status["reason"] != tt.wantWaiting {
+				t.Fatalf("result = %+v, %v, want a requeue waiting with reason %s", result, err, tt.wantWaiting)
+			}
+			status, _, _ := unstructured.NestedMap(getObject(t, client, argoClusterGVR, "default", "wl").Object, "status")
+			message, _ := status["message"].(string)
+			if status["reason"] != tt.wantWaiting || !strings.Contains(message, "application by-server") || !strings.Contains(message, "applicationset by-name") {
+				t.Errorf("status %v doesn't list the dependent applications", status)
+			}
+			if getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") == nil {
+				t.Errorf("argo secret was deleted while applications still deploy to the cluster")
+			}
+			if !containsFinalizer(getObject(t, client, argoClusterGVR, "default", "wl"), argoClusterFinalizer) {
+				t.Errorf("finalizer was removed while applications still deploy to the cluster")
+			}
+			if getObject(t, client, applicationGVR, "argocd", "other") == nil {
+				t.Errorf("application of another cluster was deleted")
+			}
+			if got := getObject(t, client, applicationGVR, "argocd", "by-server") != nil; got != tt.wantApps {
+				t.Errorf("application present = %v, want %v", got, tt.wantApps)
+			}
+			if got := getObject(t, client, applicationSetGVR, "argocd", "by-name") != nil; got != tt.wantApps {
+				t.Errorf("application set present = %v, want %v", got, tt.wantApps)
+			}
+			if tt.wantApps {
+				return
+			}
+
+			// the fake deletes right away, the finalizer is only visible in the patch that preceded it
+			cascaded := false
+			for _, action := range client.Actions() {
+				if patch, ok := action.(clienttesting.PatchAction); ok && patch.GetResource() == applicationGVR {
+					cascaded = strings.Contains(string(patch.GetPatch()), resourcesFinalizer)
+				}
+			}
+			if cascaded != tt.wantFinalizer {
+				t.Errorf("resources finalizer set = %v, want %v", cascaded, tt.wantFinalizer)
+			}
+
+			// once the applications are gone the cluster is detached
+			if _, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl")); err != nil {
+				t.Fatalf("unexpected error after the applications were deleted: %v", err)
+			}
+			if getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") != nil {
+				t.Errorf("argo secret was not deleted after the applications were deleted")
+			}
+		})
+	}
+}
+
+func TestDetachPolicySharedServer(t *testing.T) {
+	registered := newSecret("argocd", "team-argo-cluster", nil)
+	registered.Object["stringData"] = map[string]interface{}{"name": "team", "server": "https://10.0.0.1:6443", "namespaces": "team", "project": "team"}
+	app := func(name, namespace, project string) *unstructured.Unstructured {
+		u := newApplication("Application", name, map[string]interface{}{"server": "https://10.0.0.1:6443", "namespace": namespace})
+		_ = unstructured.SetNestedField(u.Object, project, "spec", "project")
+		return u
+	}
+	client := newFakeClient(
+		registered,
+		app("team-app", "team", "team"),
+		// the same server registered for another tenant
+		app("other-app", "other", "other"),
+		app("other-project", "team", "other"),
+	)
+
+	err := guardDetach(client, "argocd", "team-argo-cluster", "Delete")
+	if kind, reason := classifyError(err); kind != errorWaiting || reason != ReasonDetachPending {
+		t.Fatalf("error = %v, want waiting for team-app", err)
+	}
+	if getObject(t, client, applicationGVR, "argocd", "team-app") != nil {
+		t.Errorf("application of the detached cluster was not deleted")
+	}
+	for _, name := range []string{"other-app", "other-project"} {
+		if getObject(t, client, applicationGVR, "argocd", name) == nil {
+			t.Errorf("application %s only sharing the server was deleted", name)
+		}
+	}
+}
+
+func TestDetachPolicyArgoAPI(t *testing.T) {
+	instance := map[string]interface{}{"api": map[string]interface{}{"server": "https://argocd.example.com", "tokenSecret": "argo-api"}}
+	clusterSpec := map[string]interface{}{"clusterName": "wl", "argoInstance": instance, "project": "default", "detachPolicy": "Block"}
+	nsSpec := map[string]interface{}{"argoNamespace": "argocd", "argoInstance": instance, "project": "default", "detachPolicy": "Delete"}
+	client := newFakeClient(
+		newArgoCluster("wl", clusterSpec),
+		newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"),
+		newArgoNamespace("ns", nsSpec),
+	)
+
+	for _, err := range []error{
+		applyArgoCluster(client, getObject(t, client, argoClusterGVR, "default", "wl"), nil),
+		applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil),
+	} {
+		if kind, reason := classifyError(err); kind != errorTerminal || reason != ReasonInvalidSpec || !strings.Contains(err.Error(), "detachPolicy") {
+			t.Errorf("error = %v, want InvalidSpec for the detach policy", err)
+		}
+	}
+}
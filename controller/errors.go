@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// waitingRequeueDelay is how long a reconcile waiting on a dependency sleeps before it is retried.
+var waitingRequeueDelay = 15 * time.Second
+
+// tokenRequeueDelay is how long a reconcile waits for the token controller to populate a token secret,
+// that usually takes a second or two.
+var tokenRequeueDelay = 2 * time.Second
+
+type errorKind int
+
+const (
+	// errorTransient is retried with backoff until it succeeds, this is the default for unclassified errors.
+	errorTransient errorKind = iota
+	// errorWaiting is retried after waitingRequeueDelay, something the CR depends on doesn't exist yet.
+	errorWaiting
+	// errorTerminal is not retried until the spec changes.
+	errorTerminal
+)
+
+// Reasons reported in status.reason.
+const (
+	ReasonReconcileError         = "ReconcileError"
+	ReasonInvalidSpec            = "InvalidSpec"
+	ReasonBlockedNamespace       = "BlockedNamespace"
+	ReasonInvalidKubeconfig      = "InvalidKubeconfig"
+	ReasonKubeconfigNotFound     = "KubeconfigNotFound"
+	ReasonSecretNotFound         = "SecretNotFound"
+	ReasonServiceAccountNotFound = "ServiceAccountNotFound"
+	ReasonTokenPending           = "TokenPending"
+	ReasonArgoAPIRejected        = "ArgoAPIRejected"
+	ReasonArgoAPIUnauthorized    = "ArgoAPIUnauthorized"
+	ReasonOwnershipConflict      = "OwnershipConflict"
+	ReasonDetachBlocked          = "DetachBlocked"
+	ReasonDetachPending          = "DetachPending"
+	ReasonArgoConfigNotFound     = "ArgoConfigNotFound"
+)
+
+// reconcileError classifies why a reconcile failed so the workqueue knows how to retry it.
+type reconcileError struct {
+	kind   errorKind
+	reason string
+	err    error
+	// after overrides waitingRequeueDelay for waiting errors
+	after time.Duration
+}
+
+func (e *reconcileError) Error() string { return e.err.Error() }
+func (e *reconcileError) Unwrap() error { return e.err }
+
+func terminalError(reason string, err error) error {
+	return &reconcileError{kind: errorTerminal, reason: reason, err: err}
+}
+
+func waitingError(reason string, err error) error {
+	return &reconcileError{kind: errorWaiting, reason: reason, err: err}
+}
+
+// waitingErrorAfter is a waitingError for a dependency that is expected shortly, it is checked again after delay.
+func waitingErrorAfter(reason string, delay time.Duration, err error) error {
+	return &reconcileError{kind: errorWaiting, reason: reason, err: err, after: delay}
+}
+
+// requeueDelay returns how long the reconcile that failed with a waiting err sleeps before it is retried.
+func requeueDelay(err error) time.Duration {
+	var re *reconcileError
+	if errors.As(err, &re) && re.after > 0 {
+		return re.after
+	}
+	return waitingRequeueDelay
+}
+
+// classifyError returns the kind and status reason of err. Errors that weren't classified where they
+// happened are transient, apart from API errors that can't succeed without a change to the request.
+func classifyError(err error) (errorKind, string) {
+	var re *reconcileError
+	if errors.As(err, &re) {
+		return re.kind, re.reason
+	}
+	if apierrors.IsInvalid(err) || apierrors.IsBadRequest(err) {
+		return errorTerminal, ReasonInvalidSpec
+	}
+	return errorTransient, ReasonReconcileError
+}
+
+// combineErrors joins the errors of independent steps. The result is retried as soon as any of them
+// would be, so a terminal error in one step doesn't stop the retries of another.
+func combineErrors(message string, errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	kind, reason := classifyError(errs[0])
+	msgs := []string{}
+	for _, err := range errs {
+		k, r := classifyError(err)
+		if k < kind {
+			kind, reason = k, r
+		}
+		msgs = append(msgs, err.Error())
+	}
+	var after time.Duration
+	if kind == errorWaiting {
+		// the first dependency that may show up decides when to check again
+		after = waitingRequeueDelay
+		for _, err := range errs {
+			if k, _ := classifyError(err); k == errorWaiting {
+				after = min(after, requeueDelay(err))
+			}
+		}
+	}
+	return &reconcileError{kind: kind, reason: reason, err: fmt.Errorf("%s: %s", message, strings.Join(msgs, "; ")), after: after}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// newFakeClient returns a fake dynamic client seeded with objs that understands server side apply.
+func newFakeClient(objs ...runtime.Object) *fake.FakeDynamicClient {
+	listKinds := map[schema.GroupVersionResource]string{
+		secretGVR:         "SecretList",
+		saGVR:             "ServiceAccountList",
+		rbGVR:             "RoleBindingList",
+		crbGVR:            "ClusterRoleBindingList",
+		configMapGVR:      "ConfigMapList",
+		argoClusterGVR:    "ArgoClusterList",
+		argoNamespaceGVR:  "ArgoNamespaceList",
+		applicationGVR:    "ApplicationList",
+		applicationSetGVR: "ApplicationSetList",
+		capiClusterGVR:    "ClusterList",
+		namespaceGVR:      "NamespaceList",
+		roleGVR:           "RoleList",
+		argoCDGVR:         "ArgoCDList",
+		appProjectGVR:     "AppProjectList",
+	}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objs...)
+	client.PrependReactor("patch", "*", applyReactor(client.Tracker()))
+	return client
+}
+
+// applyReactor emulates server side apply on top of the object tracker. The tracker only
+// supports applying to objects that already exist, so creates are handled here and
+// updates are merged at the top level which is enough for the objects this controller owns.
+func applyReactor(tracker clienttesting.ObjectTracker) clienttesting.ReactionFunc {
+	return func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patchAction, ok := action.(clienttesting.PatchAction)
+		if !ok || patchAction.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		applied := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patchAction.GetPatch(), &applied.Object); err != nil {
+			return true, nil, err
+		}
+		gvr := action.GetResource()
+		ns := action.GetNamespace()
+		name := patchAction.GetName()
+
+		existing, err := tracker.Get(gvr, ns, name)
+		if apierrors.IsNotFound(err) && action.GetSubresource() == "" {
+			applied.SetName(name)
+			applied.SetNamespace(ns)
+			return true, applied, tracker.Create(gvr, applied, ns)
+		}
+		if err != nil {
+			return true, nil, err
+		}
+
+		merged := existing.(*unstructured.Unstructured).DeepCopy()
+		if action.GetSubresource() == "status" {
+			// fields of other status managers are kept, like the keys owned by a different field manager
+			status, _, _ := unstructured.NestedMap(merged.Object, "status")
+			if status == nil {
+				status = map[string]interface{}{}
+			}
+			appliedStatus, _, _ := unstructured.NestedMap(applied.Object, "status")
+			for k, v := range appliedStatus {
+				status[k] = v
+			}
+			merged.Object["status"] = status
+		} else {
+			for k, v := range applied.Object {
+				if k == "metadata" || k == "status" {
+					continue
+				}
+				merged.Object[k] = v
+			}
+			if labels := applied.GetLabels(); labels != nil {
+				merged.SetLabels(labels)
+			}
+			if annotations := applied.GetAnnotations(); annotations != nil {
+				merged.SetAnnotations(annotations)
+			}
+			if refs := applied.GetOwnerReferences(); refs != nil {
+				merged.SetOwnerReferences(refs)
+			}
+		}
+		return true, merged, tracker.Update(gvr, merged, ns)
+	}
+}
+
+func newArgoCluster(name string, spec map[string]interface{}, finalizers ...string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "field.vmware.com/v1",
+		"kind":       "ArgoCluster",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+		},
+		"spec": spec,
+	}}
+	u.SetFinalizers(finalizers)
+	return u
+}
+
+func newArgoNamespace(name string, spec map[string]interface{}, finalizers ...string) *unstructured.Unstructured {
+	u := newArgoCluster(name, spec, finalizers...)
+	u.SetKind("ArgoNamespace")
+	return u
+}
+
+func markDeleted(u *unstructured.Unstructured) *unstructured.Unstructured {
+	now := metav1.Now()
+	u.SetDeletionTimestamp(&now)
+	return u
+}
+
+func newSecret(namespace, name string, data map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+	}}
+	if data != nil {
+		u.Object["data"] = data
+	}
+	return u
+}
+
+func newServiceAccount(namespace, name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ServiceAccount",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+	}}
+}
+
+// newKubeconfigSecret builds the <cluster>-kubeconfig secret CAPI creates for a workload cluster.
+func newKubeconfigSecret(t *testing.T, namespace, clusterName, server string) *unstructured.Unstructured {
+	t.Helper()
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters[clusterName] = &clientcmdapi.Cluster{Server: server, CertificateAuthorityData: []byte("ca")}
+	cfg.AuthInfos[clusterName+"-admin"] = &clientcmdapi.AuthInfo{ClientCertificateData: []byte("cert"), ClientKeyData: []byte("key")}
+	cfg.Contexts[clusterName+"-admin@"+clusterName] = &clientcmdapi.Context{Cluster: clusterName, AuthInfo: clusterName + "-admin"}
+	cfg.CurrentContext = clusterName + "-admin@" + clusterName
+	raw, err := clientcmd.Write(*cfg)
+	if err != nil {
+		t.Fatalf("unable to write kubeconfig: %v", err)
+	}
+	return newSecret(namespace, clusterName+"-kubeconfig", map[string]interface{}{
+		"value": base64.StdEncoding.EncodeToString(raw),
+	})
+}
+
+func getObject(t *testing.T, client *fake.FakeDynamicClient, gvr schema.GroupVersionResource, namespace, name string) *unstructured.Unstructured {
+	t.Helper()
+	obj, err := client.Tracker().Get(gvr, namespace, name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("unable to get %s %s/%s: %v", gvr.Resource, namespace, name, err)
+	}
+	return obj.(*unstructured.Unstructured)
+}
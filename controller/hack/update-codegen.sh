@@ -0,0 +1,25 @@
+#!/usr/bin/env bash
+
+# regenerates deepcopy funcs and the typed clientset, listers and informers for ./api
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_ROOT=$(dirname "${BASH_SOURCE[0]}")/..
+CODEGEN_PKG=${CODEGEN_PKG:-$(cd "${SCRIPT_ROOT}"; go list -m -f '{{.Dir}}' k8s.io/code-generator)}
+
+source "${CODEGEN_PKG}/kube_codegen.sh"
+
+THIS_PKG="github.com/warroyo/argocd-attach-service"
+
+kube::codegen::gen_helpers \
+    --boilerplate "${SCRIPT_ROOT}/hack/boilerplate.go.txt" \
+    "${SCRIPT_ROOT}/api"
+
+kube::codegen::gen_client \
+    --with-watch \
+    --output-dir "${SCRIPT_ROOT}/pkg/generated" \
+    --output-pkg "${THIS_PKG}/pkg/generated" \
+    --boilerplate "${SCRIPT_ROOT}/hack/boilerplate.go.txt" \
+    "${SCRIPT_ROOT}"
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// inClusterServer is the address Argo CD connects to with its own service account.
+const inClusterServer = "https://kubernetes.default.svc"
+
+const (
+	// impersonationProjectAnnotation on the argo cluster secret records the AppProject the service
+	// account of an impersonating ArgoNamespace was added to.
+	impersonationProjectAnnotation = "field.vmware.com/argo-attach-impersonation-project"
+	// destinationSAsAnnotation lists the namespaces whose destinationServiceAccounts entry the
+	// controller added to an AppProject, entries it didn't add are never changed.
+	destinationSAsAnnotation = "field.vmware.com/argo-attach-destination-service-accounts"
+)
+
+// checkCredentialMode returns a terminal error for credential modes an ArgoNamespace can't use.
+func checkCredentialMode(spec *argov1.ArgoNamespaceSpec) error {
+	switch spec.CredentialMode {
+	case "", argov1.CredentialModeServiceAccount:
+	case argov1.CredentialModeImpersonation:
+		if spec.ArgoInstance != nil {
+			return terminalError(ReasonInvalidSpec, fmt.Errorf("the %s credential mode is only supported for an argocd in the same cluster", spec.CredentialMode))
+		}
+		// the in-cluster server is shared, applications of the namespace can't be told apart from others
+		if spec.DetachPolicy != "" && spec.DetachPolicy != argov1.DetachPolicyProceed {
+			return terminalError(ReasonInvalidSpec, fmt.Errorf("the %s credential mode only supports detachPolicy %s, not %s", spec.CredentialMode, argov1.DetachPolicyProceed, spec.DetachPolicy))
+		}
+	default:
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("unknown credentialMode %q", spec.CredentialMode))
+	}
+	return nil
+}
+
+// impersonationServiceAccount returns the service account Argo CD impersonates, created like the one
+// of the token mode unless spec.serviceAccount names an existing one. A token secret created for it
+// before is deleted, nothing should hold a token anymore.
+func impersonationServiceAccount(client dynamic.Interface, argoNs *argov1.ArgoNamespace) (string, error) {
+	namespace := argoNs.Namespace
+	saName := argoNs.Spec.ServiceAccount
+	if saName == "" {
+		var err error
+		if saName, err = applyArgoSvcAccount(client, argoNs); err != nil {
+			return "", err
+		}
+	} else if _, err := cachedGet(client, saGVR, namespace, saName); apierrors.IsNotFound(err) {
+		return "", waitingError(ReasonServiceAccountNotFound, fmt.Errorf("service account %s/%s not found", namespace, saName))
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get service account %s/%s: %w", namespace, saName, err)
+	}
+
+	secretName := fmt.Sprintf("%s-token", saName)
+	secret, err := client.Resource(secretGVR).Namespace(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return saName, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to get token secret %s: %w", secretName, err)
+	}
+	if ref := metav1.GetControllerOf(secret); ref != nil && ref.UID == argoNs.UID {
+		err := client.Resource(secretGVR).Namespace(namespace).Delete(context.TODO(), secretName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("unable to delete token secret %s: %w", secretName, err)
+		}
+		log.Printf("deleted token secret %s/%s, argo impersonates %s instead", namespace, secretName, saName)
+	}
+	return saName, nil
+}
+
+// checkImpersonationConflict waits while another ArgoNamespace is registered for impersonation into
+// argoNamespace. Argo CD keeps a single cluster per server, a second secret for the in-cluster server
+// would replace the first one, so the namespace registered first keeps it. own are the names of the
+// argo cluster secret of the ArgoNamespace itself.
+func checkImpersonationConflict(client dynamic.Interface, argoNamespace string, own ...string) error {
+	secrets, err := client.Resource(secretGVR).Namespace(argoNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "argocd.argoproj.io/secret-type=cluster"})
+	if err != nil {
+		return fmt.Errorf("unable to list argo cluster secrets in %s: %w", argoNamespace, err)
+	}
+	for _, secret := range secrets.Items {
+		if slices.Contains(own, secret.GetName()) || secretValue(&secret, "server") != inClusterServer {
+			continue
+		}
+		if _, ok := secret.GetAnnotations()[impersonationProjectAnnotation]; ok {
+			return waitingError(ReasonOwnershipConflict, fmt.Errorf("argo cluster secret %s/%s already registers %s for impersonation, only one namespace per argoNamespace can use it", argoNamespace, secret.GetName(), inClusterServer))
+		}
+	}
+	return nil
+}
+
+// impersonationProject returns the AppProject recorded on the argo cluster secret, empty when the
+// cluster wasn't registered for impersonation.
+func impersonationProject(client dynamic.Interface, argoNamespace, secretName string) (string, error) {
+	secret, err := client.Resource(secretGVR).Namespace(argoNamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to get argo cluster secret %s: %w", secretName, err)
+	}
+	return secret.GetAnnotations()[impersonationProjectAnnotation], nil
+}
+
+// destinationIndex returns the index of the destinationServiceAccounts entry for namespace on the
+// in-cluster server, -1 if there is none.
+func destinationIndex(entries []interface{}, namespace string) int {
+	return slices.IndexFunc(entries, func(e interface{}) bool {
+		m, ok := e.(map[string]interface{})
+		return ok && m["server"] == inClusterServer && m["namespace"] == namespace
+	})
+}
+
+// updateDestinationServiceAccount adds saName as the service account Argo CD impersonates for
+// namespace to project, or removes the entry again if the controller added it.
+func updateDestinationServiceAccount(client dynamic.Interface, argoNamespace, project, namespace, saName string, add bool) error {
+	obj, err := client.Resource(appProjectGVR).Namespace(argoNamespace).Get(context.TODO(), project, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if !add {
+			return nil
+		}
+		return waitingError(ReasonArgoConfigNotFound, fmt.Errorf("appproject %s not found in %s, it is needed to impersonate %s", project, argoNamespace, saName))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get appproject %s/%s: %w", argoNamespace, project, err)
+	}
+	entries, _, _ := unstructured.NestedSlice(obj.Object, "spec", "destinationServiceAccounts")
+	tracked := splitList(obj.GetAnnotations()[destinationSAsAnnotation])
+	i := destinationIndex(entries, namespace)
+	want := map[string]interface{}{"server": inClusterServer, "namespace": namespace, "defaultServiceAccount": saName}
+
+	switch {
+	case add && i >= 0 && !slices.Contains(tracked, namespace):
+		log.Printf("appproject %s/%s already has a destination service account for %s, leaving it", argoNamespace, project, namespace)
+		return nil
+	case add && i >= 0:
+		if entries[i].(map[string]interface{})["defaultServiceAccount"] == saName {
+			return nil
+		}
+		entries[i] = want
+	case add:
+		entries = append(entries, want)
+		tracked = append(tracked, namespace)
+	case !slices.Contains(tracked, namespace):
+		return nil
+	default:
+		if i >= 0 {
+			entries = slices.Delete(entries, i, i+1)
+		}
+		tracked = removeString(tracked, namespace)
+	}
+
+	if len(entries) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "spec", "destinationServiceAccounts")
+	} else {
+		_ = unstructured.SetNestedSlice(obj.Object, entries, "spec", "destinationServiceAccounts")
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[destinationSAsAnnotation] = strings.Join(tracked, ",")
+	if len(tracked) == 0 {
+		delete(annotations, destinationSAsAnnotation)
+	}
+	obj.SetAnnotations(annotations)
+	if _, err := client.Resource(appProjectGVR).Namespace(argoNamespace).Update(context.TODO(), obj, metav1.UpdateOptions{FieldManager: "argo-attach-controller"}); err != nil {
+		return fmt.Errorf("unable to update the destination service accounts of appproject %s/%s: %w", argoNamespace, project, err)
+	}
+	if add {
+		log.Printf("appproject %s/%s impersonates %s/%s", argoNamespace, project, namespace, saName)
+	} else {
+		log.Printf("removed the destination service account for %s from appproject %s/%s", namespace, argoNamespace, project)
+	}
+	return nil
+}
+
+// applyImpersonation wires saName into the project of argoNs and removes it from previous, the
+// project it was wired into before, once that changed or the CR stopped impersonating.
+func applyImpersonation(client dynamic.Interface, argoNs *argov1.ArgoNamespace, saName, previous string) error {
+	spec := argoNs.Spec
+	impersonate := spec.CredentialMode == argov1.CredentialModeImpersonation
+	if impersonate {
+		if err := updateDestinationServiceAccount(client, spec.ArgoNamespace, spec.Project, argoNs.Namespace, saName, true); err != nil {
+			return err
+		}
+	}
+	if previous != "" && (previous != spec.Project || !impersonate) {
+		return updateDestinationServiceAccount(client, spec.ArgoNamespace, previous, argoNs.Namespace, "", false)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestImpersonation(t *testing.T) {
+	cr := newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd", "project": "team", "credentialMode": "Impersonation"}, argoNamespaceFinalizer)
+	cr.SetUID(types.UID("ns-uid"))
+	// left from the token mode
+	token := newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": "c2EtdG9rZW4="})
+	token.SetOwnerReferences([]metav1.OwnerReference{controllerRef(metav1.TypeMeta{Kind: "ArgoNamespace"}, metav1.ObjectMeta{Name: "ns", UID: "ns-uid"})})
+	other := map[string]interface{}{"server": inClusterServer, "namespace": "other", "defaultServiceAccount": "deployer"}
+	objects := []runtime.Object{
+		cr,
+		token,
+		newArgoObject("argoproj.io/v1alpha1", "AppProject", "team", map[string]interface{}{
+			"spec": map[string]interface{}{"destinationServiceAccounts": []interface{}{other}},
+		}),
+	}
+	client := newFakeClient(objects...)
+
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret := getObject(t, client, secretGVR, "argocd", "supervisor-ns-default-argo-cluster")
+	if server, _, _ := unstructured.NestedString(secret.Object, "stringData", "server"); server != inClusterServer {
+		t.Errorf("server = %q, want %s", server, inClusterServer)
+	}
+	if config, _, _ := unstructured.NestedString(secret.Object, "stringData", "config"); strings.Contains(config, "bearerToken") {
+		t.Errorf("config still has a token: %s", config)
+	}
+	if _, found, _ := unstructured.NestedString(secret.Object, "stringData", "namespaces"); found {
+		t.Errorf("the shared in-cluster server was restricted to the namespace")
+	}
+	if secret.GetAnnotations()[impersonationProjectAnnotation] != "team" {
+		t.Errorf("impersonated project wasn't recorded: %v", secret.GetAnnotations())
+	}
+	if getObject(t, client, secretGVR, "default", "argo-attach-sa-token") != nil {
+		t.Errorf("token secret wasn't deleted")
+	}
+	if getObject(t, client, saGVR, "default", "argo-attach-sa") == nil {
+		t.Errorf("service account wasn't created")
+	}
+	entries, _, _ := unstructured.NestedSlice(getObject(t, client, appProjectGVR, "argocd", "team").Object, "spec", "destinationServiceAccounts")
+	if len(entries) != 2 || entries[1].(map[string]interface{})["defaultServiceAccount"] != "argo-attach-sa" {
+		t.Errorf("destinationServiceAccounts = %v", entries)
+	}
+
+	if err := deleteNamespaceCleanup(client, markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns")), nil); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	project := getObject(t, client, appProjectGVR, "argocd", "team")
+	entries, _, _ = unstructured.NestedSlice(project.Object, "spec", "destinationServiceAccounts")
+	if len(entries) != 1 || entries[0].(map[string]interface{})["namespace"] != "other" {
+		t.Errorf("destinationServiceAccounts after the delete = %v, want only the entry of namespace other", entries)
+	}
+	if _, ok := project.GetAnnotations()[destinationSAsAnnotation]; ok {
+		t.Errorf("tracking annotation was left behind")
+	}
+}
+
+func TestImpersonationDetachPolicy(t *testing.T) {
+	spec := map[string]interface{}{"argoNamespace": "argocd", "project": "team", "credentialMode": "Impersonation", "detachPolicy": "Delete"}
+	registered := newSecret("argocd", "supervisor-ns-default-argo-cluster", nil)
+	registered.Object["stringData"] = map[string]interface{}{"name": "supervisor-ns-default", "server": inClusterServer, "project": "team"}
+	registered.SetAnnotations(map[string]string{impersonationProjectAnnotation: "team"})
+	app := func(name, namespace, project string) *unstructured.Unstructured {
+		u := newApplication("Application", name, map[string]interface{}{"server": inClusterServer, "namespace": namespace})
+		_ = unstructured.SetNestedField(u.Object, project, "spec", "project")
+		return u
+	}
+	client := newFakeClient(
+		newArgoNamespace("ns", spec, argoNamespaceFinalizer),
+		registered,
+		newArgoObject("argoproj.io/v1alpha1", "AppProject", "team", nil),
+		app("other-tenant-app", "other", "other"),
+		app("team-other-namespace", "other", "team"),
+	)
+
+	err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil)
+	if kind, reason := classifyError(err); kind != errorTerminal || reason != ReasonInvalidSpec || !strings.Contains(err.Error(), "detachPolicy") {
+		t.Fatalf("error = %v, want InvalidSpec for the detach policy", err)
+	}
+
+	// a CR created before the policy was rejected never detaches the shared server
+	if err := deleteNamespaceCleanup(client, markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns")), nil); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	if getObject(t, client, secretGVR, "argocd", "supervisor-ns-default-argo-cluster") != nil {
+		t.Errorf("argo cluster secret wasn't deleted")
+	}
+	for _, name := range []string{"other-tenant-app", "team-other-namespace"} {
+		if getObject(t, client, applicationGVR, "argocd", name) == nil {
+			t.Errorf("in-cluster application %s was deleted", name)
+		}
+	}
+}
+
+func TestImpersonationRemoteInstance(t *testing.T) {
+	spec := map[string]interface{}{
+		"argoNamespace":  "argocd",
+		"project":        "team",
+		"credentialMode": "Impersonation",
+		"argoInstance":   map[string]interface{}{"kubeconfigSecret": "remote"},
+	}
+	client := newFakeClient(newArgoNamespace("ns", spec))
+	err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil)
+	if kind, reason := classifyError(err); kind != errorTerminal || reason != ReasonInvalidSpec || !strings.Contains(err.Error(), "Impersonation") {
+		t.Fatalf("error = %v, want InvalidSpec for the credential mode", err)
+	}
+}
+
+func TestImpersonationSecondNamespace(t *testing.T) {
+	spec := map[string]interface{}{"argoNamespace": "argocd", "project": "team", "credentialMode": "Impersonation"}
+	second := newArgoNamespace("ns", spec, argoNamespaceFinalizer)
+	second.SetNamespace("other")
+	objects := []runtime.Object{
+		newArgoNamespace("ns", spec, argoNamespaceFinalizer),
+		second,
+		newArgoObject("argoproj.io/v1alpha1", "AppProject", "team", map[string]interface{}{}),
+	}
+	client := newFakeClient(objects...)
+
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "other", "ns"), nil)
+	if kind, reason := classifyError(err); kind != errorWaiting || reason != ReasonOwnershipConflict {
+		t.Fatalf("error = %v, want the second impersonating namespace to wait with OwnershipConflict", err)
+	}
+	if getObject(t, client, secretGVR, "argocd", "supervisor-ns-other-argo-cluster") != nil {
+		t.Errorf("a second secret for the in-cluster server was registered")
+	}
+	// the namespace registered first keeps reconciling
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error on the second reconcile: %v", err)
+	}
+
+	if err := deleteNamespaceCleanup(client, markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns")), nil); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "other", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error once the first namespace is gone: %v", err)
+	}
+	if getObject(t, client, secretGVR, "argocd", "supervisor-ns-other-argo-cluster") == nil {
+		t.Errorf("the second namespace wasn't registered")
+	}
+}
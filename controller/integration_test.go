@@ -0,0 +1,272 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+var nsGVR = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+
+// startEnv runs a local etcd and kube-apiserver with the controller CRDs installed and
+// starts both controllers in-process against it. The binaries are located through
+// KUBEBUILDER_ASSETS so the test runs without network access once they are installed.
+func startEnv(t *testing.T) dynamic.Interface {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, run `make integration-test` to install envtest binaries")
+	}
+
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{"manifests/crd.yml"},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("unable to start envtest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Logf("unable to stop envtest: %v", err)
+		}
+	})
+
+	client, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		t.Fatalf("unable to create dynamic client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	serveConversionWebhook(ctx, t, client)
+	waitingRequeueDelay = time.Second
+	controllerConfig := defaultConfig()
+	controllerConfig.BlockedNamespaces = []string{"kube-system"}
+	controllerConfig.ResyncPeriod = metav1.Duration{Duration: 5 * time.Second}
+	store, err := newConfigStore(controllerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := startControllers(ctx, client, store); err != nil {
+		t.Fatalf("unable to start controllers: %v", err)
+	}
+
+	for _, ns := range []string{"argocd", "workloads"} {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": ns},
+		}}
+		if _, err := client.Resource(nsGVR).Create(ctx, obj, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("unable to create namespace %s: %v", ns, err)
+		}
+	}
+	return client
+}
+
+// serveConversionWebhook runs the conversion webhook on a local port and points the CRDs at it,
+// the service reference in the manifests can't be reached from the test apiserver.
+func serveConversionWebhook(ctx context.Context, t *testing.T, client dynamic.Interface) {
+	t.Helper()
+	caPEM, cert, err := generateCerts([]string{"localhost"})
+	if err != nil {
+		t.Fatalf("unable to generate webhook certificate: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(handleConvert))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"conversion": map[string]interface{}{
+				"webhook": map[string]interface{}{
+					"clientConfig": map[string]interface{}{
+						"service":  nil,
+						"url":      "https://localhost:" + strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port) + "/convert",
+						"caBundle": base64.StdEncoding.EncodeToString(caPEM),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range crdNames {
+		if _, err := client.Resource(crdGVR).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			t.Fatalf("unable to point crd %s at the webhook: %v", name, err)
+		}
+	}
+}
+
+func create(t *testing.T, client dynamic.Interface, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) {
+	t.Helper()
+	if _, err := client.Resource(gvr).Namespace(obj.GetNamespace()).Create(context.TODO(), obj, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unable to create %s %s/%s: %v", gvr.Resource, obj.GetNamespace(), obj.GetName(), err)
+	}
+}
+
+// eventually polls get until cond is satisfied or the timeout expires.
+func eventually(t *testing.T, client dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string, desc string, cond func(*unstructured.Unstructured) bool) {
+	t.Helper()
+	err := wait.PollUntilContextTimeout(context.TODO(), 200*time.Millisecond, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		u, err := client.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return cond(nil), nil
+		}
+		if err != nil {
+			return false, nil
+		}
+		return cond(u), nil
+	})
+	if err != nil {
+		t.Fatalf("timed out waiting for %s %s/%s: %s", gvr.Resource, namespace, name, desc)
+	}
+}
+
+func exists(u *unstructured.Unstructured) bool { return u != nil }
+func gone(u *unstructured.Unstructured) bool   { return u == nil }
+
+func hasState(state string) func(*unstructured.Unstructured) bool {
+	return func(u *unstructured.Unstructured) bool {
+		if u == nil {
+			return false
+		}
+		got, _, _ := unstructured.NestedString(u.Object, "status", "state")
+		return got == state
+	}
+}
+
+func TestIntegrationArgoCluster(t *testing.T) {
+	client := startEnv(t)
+
+	cr := newArgoCluster("wl", map[string]interface{}{
+		"clusterName":   "wl",
+		"argoNamespace": "argocd",
+		"project":       "default",
+		"clusterLabels": map[string]interface{}{"env": "dev"},
+	})
+	cr.SetNamespace("workloads")
+	create(t, client, argoClusterGVR, cr)
+
+	// without the kubeconfig the reconcile waits for it and checks again after waitingRequeueDelay
+	eventually(t, client, argoClusterGVR, "workloads", "wl", "finalizer and Waiting status", func(u *unstructured.Unstructured) bool {
+		return u != nil && containsFinalizer(u, argoClusterFinalizer) && hasState("Waiting")(u)
+	})
+
+	create(t, client, secretGVR, newKubeconfigSecret(t, "workloads", "wl", "https://10.0.0.1:6443"))
+	eventually(t, client, argoClusterGVR, "workloads", "wl", "Ready status after retry", hasState("Ready"))
+	eventually(t, client, secretGVR, "argocd", "wl-argo-cluster", "argo cluster secret", func(u *unstructured.Unstructured) bool {
+		if u == nil {
+			return false
+		}
+		server, _, _ := unstructured.NestedString(u.Object, "data", "server")
+		decoded, _ := base64.StdEncoding.DecodeString(server)
+		return string(decoded) == "https://10.0.0.1:6443" && u.GetLabels()["env"] == "dev"
+	})
+
+	// a spec change bumps the generation and passes the update filter
+	latest, err := client.Resource(argoClusterGVR).Namespace("workloads").Get(context.TODO(), "wl", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get argocluster: %v", err)
+	}
+	_ = unstructured.SetNestedStringMap(latest.Object, map[string]string{"env": "prod"}, "spec", "clusterLabels")
+	if _, err := client.Resource(argoClusterGVR).Namespace("workloads").Update(context.TODO(), latest, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unable to update argocluster: %v", err)
+	}
+	eventually(t, client, secretGVR, "argocd", "wl-argo-cluster", "updated labels", func(u *unstructured.Unstructured) bool {
+		return u != nil && u.GetLabels()["env"] == "prod"
+	})
+
+	if err := client.Resource(argoClusterGVR).Namespace("workloads").Delete(context.TODO(), "wl", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unable to delete argocluster: %v", err)
+	}
+	eventually(t, client, argoClusterGVR, "workloads", "wl", "finalizer removal", gone)
+	eventually(t, client, secretGVR, "argocd", "wl-argo-cluster", "argo secret cleanup", gone)
+}
+
+func TestIntegrationArgoClusterBlockedNamespace(t *testing.T) {
+	client := startEnv(t)
+
+	cr := newArgoCluster("blocked", map[string]interface{}{
+		"clusterName":   "blocked",
+		"argoNamespace": "kube-system",
+		"project":       "default",
+	})
+	cr.SetNamespace("workloads")
+	create(t, client, secretGVR, newKubeconfigSecret(t, "workloads", "blocked", "https://10.0.0.2:6443"))
+	create(t, client, argoClusterGVR, cr)
+
+	eventually(t, client, argoClusterGVR, "workloads", "blocked", "Failed status", hasState("Failed"))
+	eventually(t, client, secretGVR, "kube-system", "blocked-argo-cluster", "no argo secret", gone)
+}
+
+func TestIntegrationArgoNamespace(t *testing.T) {
+	client := startEnv(t)
+
+	cr := newArgoNamespace("tenant", map[string]interface{}{
+		"argoNamespace": "argocd",
+		"project":       "default",
+	})
+	cr.SetNamespace("workloads")
+	create(t, client, argoNamespaceGVR, cr)
+
+	// envtest does not run the token controller, so populate the token the way it would
+	eventually(t, client, secretGVR, "workloads", "argo-attach-sa-token", "token secret", exists)
+	token, err := client.Resource(secretGVR).Namespace("workloads").Get(context.TODO(), "argo-attach-sa-token", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get token secret: %v", err)
+	}
+	_ = unstructured.SetNestedField(token.Object, base64.StdEncoding.EncodeToString([]byte("sa-token")), "data", "token")
+	if _, err := client.Resource(secretGVR).Namespace("workloads").Update(context.TODO(), token, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unable to populate token: %v", err)
+	}
+
+	eventually(t, client, argoNamespaceGVR, "workloads", "tenant", "Ready status", hasState("Ready"))
+	eventually(t, client, secretGVR, "argocd", "supervisor-ns-workloads-argo-cluster", "argo cluster secret", exists)
+	eventually(t, client, saGVR, "workloads", "argo-attach-sa", "service account", exists)
+	eventually(t, client, rbGVR, "workloads", "argo-attach-sa", "role binding", exists)
+	owner, err := client.Resource(argoNamespaceGVR).Namespace("workloads").Get(context.TODO(), "tenant", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get argonamespace: %v", err)
+	}
+	for _, child := range []struct {
+		gvr  schema.GroupVersionResource
+		name string
+	}{{saGVR, "argo-attach-sa"}, {rbGVR, "argo-attach-sa"}, {secretGVR, "argo-attach-sa-token"}} {
+		u, err := client.Resource(child.gvr).Namespace("workloads").Get(context.TODO(), child.name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unable to get %s %s: %v", child.gvr.Resource, child.name, err)
+		}
+		if !metav1.IsControlledBy(u, owner) {
+			t.Errorf("%s %s is not controlled by the argonamespace: %v", child.gvr.Resource, child.name, u.GetOwnerReferences())
+		}
+	}
+
+	if err := client.Resource(argoNamespaceGVR).Namespace("workloads").Delete(context.TODO(), "tenant", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unable to delete argonamespace: %v", err)
+	}
+	eventually(t, client, argoNamespaceGVR, "workloads", "tenant", "finalizer removal", gone)
+	eventually(t, client, secretGVR, "argocd", "supervisor-ns-workloads-argo-cluster", "argo secret cleanup", gone)
+	// envtest runs no garbage collector, the owned service account, role binding and token are left behind
+}
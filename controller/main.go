@@ -10,8 +10,10 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"sync"
 	"time"
 
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,7 +21,6 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/yaml"
-	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -30,7 +31,6 @@ import (
 
 const numWorkers = 2
 
-var resync *int64 = new(int64)
 var secretGVR = schema.GroupVersionResource{
 	Group:    "",
 	Version:  "v1",
@@ -49,6 +49,12 @@ var rbGVR = schema.GroupVersionResource{
 	Resource: "rolebindings",
 }
 
+var argoClusterGVR = schema.GroupVersionResource{Group: "field.vmware.com", Version: "v1", Resource: "argoclusters"}
+var argoNamespaceGVR = schema.GroupVersionResource{Group: "field.vmware.com", Version: "v1", Resource: "argonamespaces"}
+
+const argoClusterFinalizer = "field.vmware.com/argo-attach-cluster-cleanup"
+const argoNamespaceFinalizer = "field.vmware.com/argo-attach-ns-cleanup"
+
 type StringSlice []string
 
 func (s *StringSlice) String() string {
@@ -63,45 +69,27 @@ func (s *StringSlice) Set(value string) error {
 type StatusUpdater func(*unstructured.Unstructured, bool, error) map[string]interface{}
 
 type Controller struct {
-	client           *dynamic.DynamicClient
+	client           dynamic.Interface
 	gvr              schema.GroupVersionResource
 	finalizerName    string
-	provisionFunc    func(*dynamic.DynamicClient, interface{}, []string) error // Function to run during normal operation
-	cleanupFunc      func(*dynamic.DynamicClient, interface{}) error           // Function to run during cleanup
+	reconciler       Reconciler
 	updateStatusFunc StatusUpdater
-	namespaces       []string
+	config           *configStore
+	// notifier announces status transitions, nil when notifications aren't wired up.
+	notifier *notifier
+	// terminal records the generation of objects that failed with a terminal error, they aren't
+	// reconciled again until the generation changes.
+	terminal sync.Map
 
 	Queue    workqueue.RateLimitingInterface
 	Informer cache.SharedIndexInformer
 }
 
-type ArgoNamespace struct {
-	metav1.ObjectMeta `json:"metadata,omitempty"`
-	Spec              *ArgoNamespaceSpec `json:"spec,omitempty"`
-}
-
-type ArgoCluster struct {
-	metav1.ObjectMeta `json:"metadata,omitempty"`
-	Spec              *ArgoClusterSpec `json:"spec,omitempty"`
-}
-
-type ArgoNamespaceSpec struct {
-	ClusterName    string            `json:"clusterName"`
-	ArgoNamespace  string            `json:"argoNamespace"`
-	ClusterLabels  map[string]string `json:"clusterLabels"`
-	Project        string            `json:"project"`
-	ServiceAccount string            `json:"serviceAccount"`
-}
-type ArgoClusterSpec struct {
-	ClusterName   string            `json:"clusterName"`
-	ArgoNamespace string            `json:"argoNamespace"`
-	ClusterLabels map[string]string `json:"clusterLabels"`
-	Project       string            `json:"project"`
-}
-
 type ArgoConfig struct {
-	TLSClientConfig *TLSClientConfig `json:"tlsClientConfig,omitempty"`
-	BearerToken     string           `json:"bearerToken,omitempty"`
+	TLSClientConfig    *TLSClientConfig `json:"tlsClientConfig,omitempty"`
+	BearerToken        string           `json:"bearerToken,omitempty"`
+	ProxyUrl           string           `json:"proxyUrl,omitempty"`
+	DisableCompression bool             `json:"disableCompression,omitempty"`
 }
 
 type TLSClientConfig struct {
@@ -112,22 +100,17 @@ type TLSClientConfig struct {
 	ServerName string `json:"serverName,omitempty"` // optional SNI
 }
 
-func getSecret(client *dynamic.DynamicClient, namespace string, name string) (*unstructured.Unstructured, error) {
-
-	kubeconfigSecret, err := client.Resource(secretGVR).Namespace(namespace).Get(context.TODO(), fmt.Sprintf("%s-kubeconfig", name), metav1.GetOptions{})
-	if err != nil {
-		log.Printf("unable to retrive kubeconfig secret %v", err)
-		return nil, err
-	}
-	return kubeconfigSecret, nil
-
-}
-
 func updateGenericStatus(u *unstructured.Unstructured, success bool, reconcileErr error) map[string]interface{} {
 	if reconcileErr != nil {
+		kind, reason := classifyError(reconcileErr)
+		state, message := "Failed", "Reconciliation failed: %s"
+		if kind == errorWaiting {
+			state, message = "Waiting", "Waiting for a dependency: %s"
+		}
 		return map[string]interface{}{
-			"state":       "Failed",
-			"message":     fmt.Sprintf("Reconciliation failed: %s", reconcileErr.Error()),
+			"state":       state,
+			"message":     fmt.Sprintf(message, reconcileErr.Error()),
+			"reason":      reason,
 			"ready":       false,
 			"lastUpdated": metav1.Now(),
 		}
@@ -149,32 +132,78 @@ func updateGenericStatus(u *unstructured.Unstructured, success bool, reconcileEr
 	}
 }
 
-func applyArgoNamespace(client *dynamic.DynamicClient, obj interface{}, namespaces []string) error {
+func applyArgoNamespace(client dynamic.Interface, obj interface{}, cfg *ControllerConfig) error {
 	argoNs, err := convertNs(obj)
 	if err != nil {
 		log.Printf("unable to convert object to structured argocd namespace: %v", err)
-		return fmt.Errorf("conversion error: %w", err)
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("conversion error: %w", err))
 	}
-	clusterName := fmt.Sprintf("supervisor-ns-%s", argoNs.Namespace)
+	clusterName := namespaceClusterName(argoNs.Namespace, argoNs.Spec.ClusterName)
 	argoNs.Spec.ClusterName = clusterName
+	namespaceDefaults(argoNs.Spec, cfg)
+	labels, annotations, err := propagatedMetadata(client, cfg, namespaceGVR, "", argoNs.Namespace)
+	if err != nil {
+		return err
+	}
+	argoNs.Spec.ClusterLabels = mergeMaps(labels, argoNs.Spec.ClusterLabels)
+	argoNs.Spec.ClusterAnnotations = mergeMaps(annotations, argoNs.Spec.ClusterAnnotations)
 	project := argoNs.Spec.Project
-	//do validation[TODO]
+	if argoNs.Spec.ArgoNamespace == "" || (project == "" && argoNs.Spec.ProjectTemplate == nil) {
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("argoNamespace and project are required when the config has no defaults for them"))
+	}
+	if err := cfg.checkArgoNamespace(argoNs.Spec.ArgoNamespace); err != nil {
+		return err
+	}
+	if err := checkInstanceFeatures(argoNs.Spec.ArgoInstance, cfg); err != nil {
+		return err
+	}
+	if err := checkDetachPolicy(argoNs.Spec.DetachPolicy, argoNs.Spec.ArgoInstance); err != nil {
+		return err
+	}
+	if err := checkCredentialMode(argoNs.Spec); err != nil {
+		return err
+	}
+	impersonate := argoNs.Spec.CredentialMode == argov1.CredentialModeImpersonation
+	names, err := resolveNames(argoNs.Spec.NameTemplate, argoNs.Spec.SecretNameTemplate, newNameData(argoNs.ObjectMeta, clusterName, argoNs.Spec.ClusterLabels))
+	if err != nil {
+		return err
+	}
+	registered := registeredNames(argoNs.Status.ArgoStatus, clusterName)
+	if impersonate {
+		if err := checkImpersonationConflict(client, argoNs.Spec.ArgoNamespace, registered.secret, names.secret); err != nil {
+			return err
+		}
+	}
+	previousProject := ""
+	if argoNs.Spec.ArgoInstance == nil {
+		if previousProject, err = impersonationProject(client, argoNs.Spec.ArgoNamespace, registered.secret); err != nil {
+			return err
+		}
+	}
 
 	//create the necessary svc account etc.
 	token := ""
-	if argoNs.Spec.ServiceAccount == "" {
+	saName := ""
+	if impersonate {
+		saName, err = impersonationServiceAccount(client, &argoNs)
+		if err != nil {
+			log.Printf("unable to create svc account for %s: %v", argoNs.Name, err)
+			return fmt.Errorf("unable to create svc account for %s: %w", argoNs.Name, err)
+		}
+	} else if argoNs.Spec.ServiceAccount == "" {
 		token, err = createArgoSvcAccount(client, &argoNs)
 		if err != nil {
 			log.Printf("unable to create svc account for %s: %v", argoNs.Name, err)
-			return fmt.Errorf("unable to create svc account for %s: %v", argoNs.Name, err)
+			return fmt.Errorf("unable to create svc account for %s: %w", argoNs.Name, err)
 		}
 	} else {
 		//get existing service account token
 
-		token, err = getSAToken(client, argoNs.Namespace, argoNs.Spec.ServiceAccount)
+		owner := controllerRef(argoNs.TypeMeta, argoNs.ObjectMeta)
+		token, err = getSAToken(client, argoNs.Namespace, argoNs.Spec.ServiceAccount, &owner)
 		if err != nil {
 			log.Printf("unable to get svc account token for %s: %v", argoNs.Spec.ServiceAccount, err)
-			return fmt.Errorf("unable to get svc account token for %s: %v", argoNs.Spec.ServiceAccount, err)
+			return fmt.Errorf("unable to get svc account token for %s: %w", argoNs.Spec.ServiceAccount, err)
 		}
 	}
 
@@ -182,8 +211,15 @@ func applyArgoNamespace(client *dynamic.DynamicClient, obj interface{}, namespac
 	argoConfig := &ArgoConfig{
 		BearerToken: token,
 		TLSClientConfig: &TLSClientConfig{
-			Insecure: true,
+			Insecure:   true,
+			ServerName: argoNs.Spec.ServerName,
 		},
+		ProxyUrl:           argoNs.Spec.ProxyUrl,
+		DisableCompression: argoNs.Spec.DisableCompression,
+	}
+	if impersonate {
+		// argo connects with its own identity and impersonates the service account
+		argoConfig = &ArgoConfig{TLSClientConfig: &TLSClientConfig{}}
 	}
 
 	jsonConfig, err := json.Marshal(argoConfig)
@@ -192,120 +228,153 @@ func applyArgoNamespace(client *dynamic.DynamicClient, obj interface{}, namespac
 		return fmt.Errorf("unable to encoded argo config: %v", err)
 	}
 
+	server, err := supervisorServer(argoNs.Namespace, argoNs.Spec.ArgoInstance)
+	if err != nil {
+		return err
+	}
+	if impersonate {
+		server = inClusterServer
+	}
+	if argoNs.Spec.ProjectTemplate != nil {
+		project, err = applyTenantProject(client, &argoNs, names, server)
+		if err != nil {
+			return err
+		}
+		argoNs.Spec.Project = project
+	}
+
 	secretData := map[string]string{
-		"name":       clusterName,
-		"server":     "https://kubernetes.default.svc.cluster.local:443/?context=" + argoNs.Namespace,
+		"name":       names.name,
+		"server":     server,
 		"project":    project,
 		"config":     string(jsonConfig),
 		"namespaces": string(argoNs.Namespace),
 	}
+	if impersonate {
+		// the in-cluster server isn't restricted to the namespace, the project restricts the destinations
+		delete(secretData, "namespaces")
+		argoNs.Spec.ClusterAnnotations = mergeMaps(argoNs.Spec.ClusterAnnotations, map[string]string{impersonationProjectAnnotation: project})
+	}
 
-	cluster := &ArgoCluster{
+	cluster := &argov1.ArgoCluster{
 		ObjectMeta: argoNs.ObjectMeta,
-		Spec: &ArgoClusterSpec{
-			ClusterName:   argoNs.Spec.ClusterName,
-			ArgoNamespace: argoNs.Spec.ArgoNamespace,
-			ClusterLabels: argoNs.Spec.ClusterLabels,
-			Project:       argoNs.Spec.Project,
+		Spec: &argov1.ArgoClusterSpec{
+			ClusterName:        argoNs.Spec.ClusterName,
+			ArgoNamespace:      argoNs.Spec.ArgoNamespace,
+			ArgoInstance:       argoNs.Spec.ArgoInstance,
+			ClusterLabels:      argoNs.Spec.ClusterLabels,
+			ClusterAnnotations: argoNs.Spec.ClusterAnnotations,
+			Project:            argoNs.Spec.Project,
 		},
 	}
-	err = applySecret(client, cluster, secretData)
+	err = registerCluster(client, argoNs.Namespace, cluster, names.secret, secretData)
 	if err != nil {
 		log.Printf("unable to create or update argo cluster secret %v", err)
-		return fmt.Errorf("unable to create or update argo cluster secret %v", err)
+		return fmt.Errorf("unable to create or update argo cluster secret: %w", err)
+	}
+	if err := renameCluster(client, argoNs.Namespace, cluster, registered, names); err != nil {
+		return err
+	}
+	if err := patchNamesStatus(client, argoNamespaceGVR, obj, names, argoNs.Spec.Project, credentialsHash(token)); err != nil {
+		log.Printf("unable to record the registered names: %v", err)
+	}
+	log.Printf("succesfully created or update argo cluster secret %s", names.secret)
+
+	if err := applySourceNamespace(client, &argoNs); err != nil {
+		return err
+	}
+	if err := applyImpersonation(client, &argoNs, saName, previousProject); err != nil {
+		return err
+	}
+	// projects of a previous name template, without a template they stay until the CR is deleted
+	if argoNs.Spec.ProjectTemplate != nil {
+		if err := pruneTenantProjects(client, &argoNs, project); err != nil {
+			return err
+		}
 	}
-	secretName := fmt.Sprintf("%s-argo-cluster", clusterName)
-	log.Printf("succesfully created or update argo cluster secret %s", secretName)
 
 	return nil
 }
 
-func applyArgoCluster(client *dynamic.DynamicClient, obj interface{}, namespaces []string) error {
+func applyArgoCluster(client dynamic.Interface, obj interface{}, cfg *ControllerConfig) error {
 	argoCluster, err := convertObj(obj)
 	if err != nil {
 		log.Printf("unable to convert object to structured argocd cluster: %v", err)
-		return fmt.Errorf("unable to convert object to structured argocd cluster: %v", err)
-	}
-
-	namespace := argoCluster.ObjectMeta.Namespace
-	clusterName := argoCluster.Spec.ClusterName
-	project := argoCluster.Spec.Project
-	argoNamespace := argoCluster.Spec.ArgoNamespace
-
-	if slices.Contains(namespaces, argoNamespace) {
-		log.Printf("argoNamespace is in the list of blocked namespaces, not creating secret: %v", namespaces)
-		return fmt.Errorf("argoNamespace is in the list of blocked namespaces, not creating secret: %v", namespaces)
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("unable to convert object to structured argocd cluster: %v", err))
 	}
 
-	kubeconfigUns, err := getSecret(client, namespace, clusterName)
+	clusterDefaults(argoCluster.Spec, cfg)
+	labels, annotations, err := propagatedMetadata(client, cfg, capiClusterGVR, argoCluster.Namespace, argoCluster.Spec.ClusterName)
 	if err != nil {
-		log.Printf("unable to retrieve kubeconfig secret: %v", err)
-		return fmt.Errorf("unable to retrieve kubeconfig secret: %v", err)
-	}
-
-	kubeconfig, found, err := unstructured.NestedStringMap(kubeconfigUns.Object, "data")
-	if err != nil || !found {
-		log.Printf("cannot get secret data: %v", err)
-		return fmt.Errorf("cannot get secret data: %v", err)
+		return err
 	}
-
-	encodedSecret, ok := kubeconfig["value"]
-	if !ok {
-		log.Println("value does not exist in kubeconfig secret")
-		return fmt.Errorf("value does not exist in kubeconfig secret")
+	argoCluster.Spec.ClusterLabels = mergeMaps(labels, argoCluster.Spec.ClusterLabels)
+	argoCluster.Spec.ClusterAnnotations = mergeMaps(annotations, argoCluster.Spec.ClusterAnnotations)
+	targets, err := clusterTargets(argoCluster.Spec)
+	if err != nil {
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("invalid targets: %w", err))
 	}
-
-	decoded, err := base64.StdEncoding.DecodeString(encodedSecret)
+	names, err := resolveNames(argoCluster.Spec.NameTemplate, argoCluster.Spec.SecretNameTemplate, newNameData(argoCluster.ObjectMeta, argoCluster.Spec.ClusterName, argoCluster.Spec.ClusterLabels))
 	if err != nil {
-		log.Printf("failed to decode value: %v", err)
-		return fmt.Errorf("failed to decode value: %v", err)
+		return err
 	}
+	registered := registeredNames(argoCluster.Status.ArgoStatus, argoCluster.Spec.ClusterName)
 
-	config, err := clientcmd.Load(decoded)
+	config, err := loadClusterKubeconfig(client, &argoCluster)
 	if err != nil {
-		log.Printf("failed to read kubconfig data: %v", err)
-		return fmt.Errorf("failed to read kubconfig data: %v", err)
-	}
-	argoConfig := &ArgoConfig{
-		TLSClientConfig: &TLSClientConfig{
-			CAData:   base64.StdEncoding.EncodeToString(config.Clusters[clusterName].CertificateAuthorityData),
-			KeyData:  base64.StdEncoding.EncodeToString(config.AuthInfos[fmt.Sprintf("%s-admin", clusterName)].ClientKeyData),
-			CertData: base64.StdEncoding.EncodeToString(config.AuthInfos[fmt.Sprintf("%s-admin", clusterName)].ClientCertificateData),
-		},
+		return err
 	}
 
-	jsonConfig, err := json.Marshal(argoConfig)
-	if err != nil {
-		log.Printf("unable to encoded argo config: %v", err)
+	// every target is reconciled on its own so one failing argo instance doesn't block the others
+	var statuses []argov1.ArgoTargetStatus
+	var failed []error
+	for _, target := range targets {
+		err := applyClusterTarget(client, &argoCluster, target, names, config, cfg)
+		if err == nil {
+			err = renameCluster(client, argoCluster.Namespace, targetView(&argoCluster, target), registered, names)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", target.Name, err))
+		}
+		statuses = append(statuses, targetStatus(target, err))
 	}
-	secretData := map[string]string{
-		"name":             clusterName,
-		"server":           config.Clusters[clusterName].Server,
-		"clusterresources": "true",
-		"project":          project,
-		"config":           string(jsonConfig),
+
+	// targets removed from the spec are still registered
+	for _, previous := range argoCluster.Status.Targets {
+		if slices.ContainsFunc(targets, func(t argov1.ArgoTarget) bool { return t.Name == previous.Name }) {
+			continue
+		}
+		removed := targetFromStatus(previous)
+		if err := cleanupClusterTarget(client, &argoCluster, removed, registered, false); err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", removed.Name, err))
+			statuses = append(statuses, targetStatus(removed, fmt.Errorf("cleanup of removed target failed: %w", err)))
+			continue
+		}
+		log.Printf("cleaned up removed target %s", removed.Name)
 	}
 
-	err = applySecret(client, &argoCluster, secretData)
-	if err != nil {
-		log.Printf("unable to create or update argo cluster secret %v", err)
-		return fmt.Errorf("unable to create or update argo cluster secret %v", err)
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		if err := patchTargetStatus(client, u, statuses); err != nil {
+			log.Printf("unable to update target status: %v", err)
+		}
+	}
+	// until every target is registered under the new names the previous ones still have to be cleaned up
+	if len(failed) == 0 {
+		if err := patchNamesStatus(client, argoClusterGVR, obj, names, argoCluster.Spec.Project, kubeconfigCredentialsHash(config, argoCluster.Spec.ClusterName)); err != nil {
+			log.Printf("unable to record the registered names: %v", err)
+		}
 	}
-	secretName := fmt.Sprintf("%s-argo-cluster", clusterName)
-	log.Printf("succesfully created or update argo cluster secret %s", secretName)
-	return nil
 
+	return combineErrors(fmt.Sprintf("%d of %d targets failed", len(failed), len(statuses)), failed)
 }
 
-func applySecret(client *dynamic.DynamicClient, argoCluster *ArgoCluster, secretData map[string]string) error {
+func applySecret(client dynamic.Interface, argoCluster *argov1.ArgoCluster, secretName string, secretData map[string]string) error {
 	labels := argoCluster.Spec.ClusterLabels
 	if labels == nil {
 		labels = make(map[string]string)
 	}
-	clusterName := argoCluster.Spec.ClusterName
 	argoNamespace := argoCluster.Spec.ArgoNamespace
 	labels["argocd.argoproj.io/secret-type"] = "cluster"
-	secretName := fmt.Sprintf("%s-argo-cluster", clusterName)
 
 	secret := &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{
@@ -313,9 +382,10 @@ func applySecret(client *dynamic.DynamicClient, argoCluster *ArgoCluster, secret
 			Kind:       "Secret",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      secretName,
-			Namespace: argoNamespace,
-			Labels:    labels,
+			Name:        secretName,
+			Namespace:   argoNamespace,
+			Labels:      labels,
+			Annotations: argoCluster.Spec.ClusterAnnotations,
 		},
 		StringData: secretData,
 		Type:       corev1.SecretTypeOpaque,
@@ -333,73 +403,122 @@ func applySecret(client *dynamic.DynamicClient, argoCluster *ArgoCluster, secret
 	return nil
 }
 
-func deleteClusterCleanup(client *dynamic.DynamicClient, obj interface{}) error {
+func deleteClusterCleanup(client dynamic.Interface, obj interface{}, cfg *ControllerConfig) error {
 	argoCluster, err := convertObj(obj)
 	if err != nil {
 		log.Printf("unable to convert object to structured argocd cluster: %v", err)
 		return err
 	}
+	clusterDefaults(argoCluster.Spec, cfg)
+	policy, err := deletionPolicy(argoCluster.Spec.DeletionPolicy)
+	if err != nil {
+		return terminalError(ReasonInvalidSpec, err)
+	}
+	if policy == argov1.DeletionPolicyRetain {
+		log.Printf("deletionPolicy is %s, leaving cluster %s registered", policy, argoCluster.Spec.ClusterName)
+		return nil
+	}
 
-	clusterName := argoCluster.Spec.ClusterName
-	secretName := fmt.Sprintf("%s-argo-cluster", clusterName)
-	argoNamespace := argoCluster.Spec.ArgoNamespace
-	err = deleteSecret(client, argoNamespace, secretName)
+	targets, err := clusterTargets(argoCluster.Spec)
 	if err != nil {
-		log.Printf("unable to delete cluster secret: %v", err)
-		return err
+		log.Printf("invalid targets, only cleaning up targets from the status: %v", err)
 	}
-	return nil
+	for _, previous := range argoCluster.Status.Targets {
+		if !slices.ContainsFunc(targets, func(t argov1.ArgoTarget) bool { return t.Name == previous.Name }) {
+			targets = append(targets, targetFromStatus(previous))
+		}
+	}
+
+	names := registeredNames(argoCluster.Status.ArgoStatus, argoCluster.Spec.ClusterName)
+	var failed []error
+	for _, target := range targets {
+		if err := cleanupClusterTarget(client, &argoCluster, target, names, policy == argov1.DeletionPolicyOrphanCredentialsOnly); err != nil {
+			log.Printf("unable to clean up target %s: %v", target.Name, err)
+			failed = append(failed, fmt.Errorf("%s: %w", target.Name, err))
+		}
+	}
+	return combineErrors(fmt.Sprintf("cleanup of %d of %d targets failed", len(failed), len(targets)), failed)
 }
 
-func deleteNamespaceCleanup(client *dynamic.DynamicClient, obj interface{}) error {
+// deleteNamespaceCleanup only unregisters the cluster from Argo CD and reverts the source namespace, the
+// service account, role bindings and token secret in the CR namespace are owned by the CR and removed
+// by the garbage collector. Unless the deletion policy is Delete they are released first so they stay
+// in place.
+func deleteNamespaceCleanup(client dynamic.Interface, obj interface{}, cfg *ControllerConfig) error {
 	argoNs, err := convertNs(obj)
 	if err != nil {
 		log.Printf("unable to convert object to structured argocd namespace: %v", err)
 		return err
 	}
+	namespaceDefaults(argoNs.Spec, cfg)
 	namespace := argoNs.Namespace
-	clusterName := fmt.Sprintf("supervisor-ns-%s", argoNs.Namespace)
-	secretName := fmt.Sprintf("%s-argo-cluster", clusterName)
-	argoNamespace := argoNs.Spec.ArgoNamespace
-	saName := "argo-attach-sa"
-	if argoNs.Spec.ServiceAccount != "" {
-		saName = argoNs.Spec.ServiceAccount
-	}
-	saToken := fmt.Sprintf("%s-token", saName)
-
-	err = client.Resource(secretGVR).Namespace(namespace).Delete(context.TODO(), saToken, metav1.DeleteOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		log.Printf("unable to delete argo svc account token secret %v", err)
-		return err
+	clusterName := namespaceClusterName(argoNs.Namespace, argoNs.Spec.ClusterName)
+	policy, err := deletionPolicy(argoNs.Spec.DeletionPolicy)
+	if err != nil {
+		return terminalError(ReasonInvalidSpec, err)
 	}
-	log.Printf("succesfully deleted argo svc account token secret")
-
-	if argoNs.Spec.ServiceAccount == "" {
-		log.Printf("not using existing service account,cleaning up role and sa")
-		err = client.Resource(saGVR).Namespace(namespace).Delete(context.TODO(), saName, metav1.DeleteOptions{})
-		if err != nil && !apierrors.IsNotFound(err) {
-			log.Printf("unable to delete argo svc account %v", err)
-			return err
+	if policy != argov1.DeletionPolicyDelete {
+		saName := "argo-attach-sa"
+		if argoNs.Spec.ServiceAccount != "" {
+			saName = argoNs.Spec.ServiceAccount
 		}
-		log.Printf("succesfully deleted argo svc account")
-
-		//role binding
-		err = client.Resource(rbGVR).Namespace(namespace).Delete(context.TODO(), saName, metav1.DeleteOptions{})
-		if err != nil && !apierrors.IsNotFound(err) {
-			log.Printf("unable to delete argo svc account role binding %v", err)
+		type child struct {
+			gvr  schema.GroupVersionResource
+			name string
+		}
+		children := []child{{saGVR, saName}, {rbGVR, saName}, {secretGVR, fmt.Sprintf("%s-token", saName)}}
+		if policy == argov1.DeletionPolicyRetain {
+			// the namespace stays a source namespace, so does the RBAC of the argo controllers
+			children = append(children, child{roleGVR, sourceNamespaceRole}, child{rbGVR, sourceNamespaceRole})
+		}
+		for _, child := range children {
+			if err := releaseChild(client, child.gvr, namespace, child.name, argoNs.UID); err != nil {
+				return err
+			}
+		}
+	}
+	if policy == argov1.DeletionPolicyRetain {
+		log.Printf("deletionPolicy is %s, leaving cluster %s registered", policy, clusterName)
+		return nil
+	}
+	registered := registeredNames(argoNs.Status.ArgoStatus, clusterName)
+	detachPolicy := argoNs.Spec.DetachPolicy
+	if argoNs.Spec.CredentialMode == argov1.CredentialModeImpersonation {
+		// the shared in-cluster server would match the applications of every other tenant
+		detachPolicy = ""
+	}
+	// read before the secret recording it is deleted, the applications keep the service account until then
+	impersonatedProject := ""
+	if argoNs.Spec.ArgoInstance == nil {
+		if impersonatedProject, err = impersonationProject(client, argoNs.Spec.ArgoNamespace, registered.secret); err != nil {
 			return err
 		}
-		log.Printf("succesfully deleted argo svc account role binding")
 	}
-	err = deleteSecret(client, argoNamespace, secretName)
+	err = unregisterCluster(client, namespace, &argov1.ArgoCluster{
+		Spec: &argov1.ArgoClusterSpec{
+			ClusterName:   clusterName,
+			ArgoNamespace: argoNs.Spec.ArgoNamespace,
+			ArgoInstance:  argoNs.Spec.ArgoInstance,
+			DetachPolicy:  detachPolicy,
+		},
+	}, registered)
 	if err != nil {
 		log.Printf("unable to delete argo cluster secret %v", err)
 		return err
 	}
-	return nil
+	// only once the cluster is detached, until then Argo CD keeps managing the applications in the namespace
+	if err := revertSourceNamespace(client, namespace); err != nil {
+		return err
+	}
+	if impersonatedProject != "" {
+		if err := updateDestinationServiceAccount(client, argoNs.Spec.ArgoNamespace, impersonatedProject, namespace, "", false); err != nil {
+			return err
+		}
+	}
+	return pruneTenantProjects(client, &argoNs, "")
 }
 
-func deleteSecret(client *dynamic.DynamicClient, namespace string, secretName string) error {
+func deleteSecret(client dynamic.Interface, namespace string, secretName string) error {
 
 	err := client.Resource(secretGVR).Namespace(namespace).Delete(context.TODO(), secretName, metav1.DeleteOptions{})
 	if err != nil && !apierrors.IsNotFound(err) {
@@ -410,10 +529,27 @@ func deleteSecret(client *dynamic.DynamicClient, namespace string, secretName st
 	return nil
 }
 
-func createArgoSvcAccount(client *dynamic.DynamicClient, details *ArgoNamespace) (string, error) {
+func createArgoSvcAccount(client dynamic.Interface, details *argov1.ArgoNamespace) (string, error) {
+	saName, err := applyArgoSvcAccount(client, details)
+	if err != nil {
+		return "", err
+	}
+	owner := controllerRef(details.TypeMeta, details.ObjectMeta)
+	return getSAToken(client, details.Namespace, saName, &owner)
+}
+
+// applyArgoSvcAccount creates the service account and its edit role binding in the namespace of
+// details and returns its name.
+func applyArgoSvcAccount(client dynamic.Interface, details *argov1.ArgoNamespace) (string, error) {
 	namespace := details.ObjectMeta.Namespace
+	owner := controllerRef(details.TypeMeta, details.ObjectMeta)
 	sa := &unstructured.Unstructured{}
 	saName := "argo-attach-sa"
+	for _, gvr := range []schema.GroupVersionResource{saGVR, rbGVR} {
+		if err := checkAdoption(client, gvr, namespace, saName, owner); err != nil {
+			return "", err
+		}
+	}
 	saYaml := fmt.Sprintf(`
 apiVersion: v1
 kind: ServiceAccount
@@ -423,6 +559,7 @@ metadata:
 `, saName, namespace)
 
 	_ = yaml.Unmarshal([]byte(saYaml), sa)
+	sa.SetOwnerReferences([]metav1.OwnerReference{owner})
 
 	_, err := client.Resource(saGVR).Namespace(namespace).Apply(context.TODO(), saName, sa, metav1.ApplyOptions{FieldManager: "argo-attach-controller"})
 	if err != nil {
@@ -450,6 +587,7 @@ subjects:
 
 	rb := &unstructured.Unstructured{}
 	_ = yaml.Unmarshal([]byte(rbYaml), rb)
+	rb.SetOwnerReferences([]metav1.OwnerReference{owner})
 
 	_, err = client.Resource(rbGVR).Namespace(namespace).Apply(context.TODO(), saName, rb, metav1.ApplyOptions{FieldManager: "argo-attach-controller"})
 	if err != nil {
@@ -458,22 +596,18 @@ subjects:
 	}
 
 	log.Printf("Created rolebinding %s in namespace %s", saName, namespace)
-
-	returnToken, err := getSAToken(client, namespace, saName)
-	if err != nil {
-		return "", err
-	}
-	return returnToken, nil
-
+	return saName, nil
 }
 
-func getSAToken(client *dynamic.DynamicClient, namespace string, saName string) (string, error) {
+// getSAToken creates a token secret for the service account and returns the token. owner becomes the
+// controller of the secret, it is nil when the secret isn't in the namespace of a CR.
+func getSAToken(client dynamic.Interface, namespace string, saName string, owner *metav1.OwnerReference) (string, error) {
 
-	_, err := client.Resource(saGVR).Namespace(namespace).Get(context.TODO(), saName, metav1.GetOptions{})
+	_, err := cachedGet(client, saGVR, namespace, saName)
 
 	if err != nil {
 		if apierrors.IsNotFound(err) {
-			return "", fmt.Errorf("service account %s/%s not found", namespace, saName)
+			return "", waitingError(ReasonServiceAccountNotFound, fmt.Errorf("service account %s/%s not found", namespace, saName))
 		}
 		return "", fmt.Errorf("failed to get service account %s/%s: %w", namespace, saName, err)
 	}
@@ -492,34 +626,24 @@ type: kubernetes.io/service-account-token
 
 	token := &unstructured.Unstructured{}
 	_ = yaml.Unmarshal([]byte(tokenYaml), token)
-
-	_, err = client.Resource(secretGVR).Namespace(namespace).Apply(context.TODO(), secretName, token, metav1.ApplyOptions{FieldManager: "argo-attach-controller"})
-	if err != nil {
-		log.Printf("unable to create or update argo namespace service account token %v", err)
-		return "", err
+	if owner != nil {
+		if err := checkAdoption(client, secretGVR, namespace, secretName, *owner); err != nil {
+			return "", err
+		}
+		token.SetOwnerReferences([]metav1.OwnerReference{*owner})
 	}
 
-	log.Printf("Created token %s in namespace %s", saName, namespace)
-	log.Printf("retrieving token %s in namespace %s", saName, namespace)
-	tokenSecert, err := client.Resource(secretGVR).Namespace(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	// the apply response already has the token if the token controller populated it before. Until then
+	// the reconcile is requeued instead of holding a worker, the token secret watch requeues it sooner.
+	tokenSecret, err := client.Resource(secretGVR).Namespace(namespace).Apply(context.TODO(), secretName, token, metav1.ApplyOptions{FieldManager: "argo-attach-controller"})
 	if err != nil {
-		log.Printf("unable to get token secret %v", err)
+		log.Printf("unable to create or update argo namespace service account token %v", err)
 		return "", err
 	}
-	var returnToken string
-	for i := 0; i < 10; i++ {
-		tokenValue, found, _ := unstructured.NestedString(tokenSecert.Object, "data", "token")
-		if found && len(tokenValue) > 0 {
-			returnToken = tokenValue
-			break
-		}
-		time.Sleep(1 * time.Second)
-
-		tokenSecert, _ = client.Resource(secretGVR).Namespace(namespace).Get(context.TODO(), "argo-attach-sa-token", metav1.GetOptions{})
-	}
 
+	returnToken, _, _ := unstructured.NestedString(tokenSecret.Object, "data", "token")
 	if returnToken == "" {
-		return "", fmt.Errorf("unable to retrieve token value after waitng 10s")
+		return "", waitingErrorAfter(ReasonTokenPending, tokenRequeueDelay, fmt.Errorf("token secret %s/%s is not populated yet", namespace, secretName))
 	}
 	decodedBytes, err := base64.StdEncoding.DecodeString(returnToken)
 	if err != nil {
@@ -530,10 +654,12 @@ type: kubernetes.io/service-account-token
 	return decodedToken, nil
 }
 
-func (c *Controller) Reconcile(obj interface{}) (reconcileResult error) {
+// Reconcile runs the reconciler for obj behind the finalizer and writes the status. The result says
+// when obj should be reconciled again.
+func (c *Controller) Reconcile(obj interface{}) (result Result, reconcileResult error) {
 	u, err := toUnstructured(obj)
 	if err != nil {
-		return fmt.Errorf("error converting to unstructured: %w", err)
+		return Result{}, fmt.Errorf("error converting to unstructured: %w", err)
 	}
 
 	name := u.GetName()
@@ -559,27 +685,75 @@ func (c *Controller) Reconcile(obj interface{}) (reconcileResult error) {
 			}
 
 			statusMap := c.updateStatusFunc(latestU, false, reconcileErr)
+			for k, v := range result.Status {
+				statusMap[k] = v
+			}
+			kind, _ := classifyError(reconcileErr)
+			key, _ := cache.MetaNamespaceKeyFunc(latestU)
+			switch {
+			case kind == errorTerminal:
+				c.terminal.Store(key, latestU.GetGeneration())
+			case kind == errorTransient && c.Queue != nil:
+				statusMap["retries"] = int64(c.Queue.NumRequeues(key) + 1)
+			}
 
 			if statusPatchErr := patchStatus(context.TODO(), c.client, c.gvr, latestU, statusMap); statusPatchErr != nil {
 				if !apierrors.IsNotFound(statusPatchErr) && !apierrors.IsConflict(statusPatchErr) {
 					log.Printf("%s CRITICAL: Failed to patch status after error: %v\n", logPrefix, statusPatchErr)
 				}
+			} else if state, _ := statusMap["state"].(string); state == "Failed" {
+				if latestState, _, _ := unstructured.NestedString(latestU.Object, "status", "state"); latestState != state {
+					message, _ := statusMap["message"].(string)
+					_, reason := classifyError(reconcileErr)
+					c.notifier.notify(EventFailed, latestU, message, reason)
+				}
 			}
 
 			reconcileResult = reconcileErr
 		}
 	}()
 
+	pauseSource, pauseDeletion := pausedBy(u, c.config.get())
+	if pauseSource != "" && (u.GetDeletionTimestamp().IsZero() || pauseDeletion) {
+		log.Printf("%s Reconciliation is paused by %s, skipping.\n", logPrefix, pauseSource)
+		if !isPausedStatus(u, pauseSource) {
+			if statusPatchErr := patchStatus(context.TODO(), c.client, c.gvr, u, pausedStatus(u, pauseSource)); statusPatchErr != nil {
+				log.Printf("%s Warning: Failed to patch paused status: %v\n", logPrefix, statusPatchErr)
+			}
+		}
+		return Result{}, nil
+	}
+
 	if !u.GetDeletionTimestamp().IsZero() {
 		log.Printf("%s DeletionTimestamp detected. Initiating finalization.\n", logPrefix)
 
 		if containsFinalizer(u, c.finalizerName) {
 			log.Printf("%s Finalizer %s is present. Starting cleanup...\n", logPrefix, c.finalizerName)
 
-			if cleanupErr := c.cleanupFunc(c.client, obj); cleanupErr != nil {
-				log.Printf("%s CLEANUP FAILED: %v. Will retry on next sync.\n", logPrefix, cleanupErr)
-				reconcileErr = fmt.Errorf("cleanup failed: %w", cleanupErr)
-				return reconcileErr
+			cleanupResult, cleanupErr := c.reconciler.Cleanup(c.client, u, c.config.get())
+			if cleanupErr != nil {
+				// a dependency that is missing or broken while the namespace is deleted won't come back,
+				// retrying would only keep the namespace from being deleted
+				if kind, _ := classifyError(cleanupErr); kind != errorTransient && namespaceTerminating(c.client, namespace) {
+					log.Printf("%s CLEANUP FAILED in terminating namespace, removing finalizer anyway, the argo cluster may have to be removed by hand: %v\n", logPrefix, cleanupErr)
+				} else {
+					log.Printf("%s CLEANUP FAILED: %v. Will retry on next sync.\n", logPrefix, cleanupErr)
+					reconcileErr = fmt.Errorf("cleanup failed: %w", cleanupErr)
+					return cleanupResult, reconcileErr
+				}
+			} else if cleanupResult.Requeue || cleanupResult.RequeueAfter > 0 {
+				if state, _ := cleanupResult.Status["state"].(string); state == "Waiting" && namespaceTerminating(c.client, namespace) {
+					log.Printf("%s CLEANUP WAITING in terminating namespace, removing finalizer anyway, the argo cluster may have to be removed by hand: %v\n", logPrefix, cleanupResult.Status["message"])
+				} else {
+					// cleanup isn't finished yet, keep the finalizer until it is
+					log.Printf("%s Cleanup in progress, keeping finalizer %s.\n", logPrefix, c.finalizerName)
+					if len(cleanupResult.Status) > 0 {
+						if statusPatchErr := patchStatus(context.TODO(), c.client, c.gvr, u, cleanupResult.Status); statusPatchErr != nil {
+							log.Printf("%s Warning: Failed to patch status while cleanup is in progress: %v\n", logPrefix, statusPatchErr)
+						}
+					}
+					return cleanupResult, nil
+				}
 			}
 
 			currentFinalizers := u.GetFinalizers()
@@ -588,14 +762,15 @@ func (c *Controller) Reconcile(obj interface{}) (reconcileResult error) {
 			if err := patchFinalizer(context.TODO(), c.client, c.gvr, u, c.finalizerName, updatedFinalizers); err != nil {
 				log.Printf("%s ERROR patching to remove finalizer: %v\n", logPrefix, err)
 				reconcileErr = fmt.Errorf("finalizer removal patch failed: %w", err)
-				return reconcileErr
+				return Result{}, reconcileErr
 			}
 			log.Printf("%s Finalizer %s removed successfully. Deletion will now complete.\n", logPrefix, c.finalizerName)
-			return nil
+			c.notifier.notify(EventDetached, u, "Cluster was detached from argo.", "")
+			return Result{}, nil
 		}
 
 		log.Printf("%s Finalizer not present. Deletion complete/in progress by K8s.\n", logPrefix)
-		return nil
+		return Result{}, nil
 	}
 
 	if !containsFinalizer(u, c.finalizerName) {
@@ -607,7 +782,7 @@ func (c *Controller) Reconcile(obj interface{}) (reconcileResult error) {
 		if err := patchFinalizer(context.TODO(), c.client, c.gvr, u, c.finalizerName, updatedFinalizers); err != nil {
 			log.Printf("%s ERROR patching to add finalizer: %v\n", logPrefix, err)
 			reconcileErr = fmt.Errorf("finalizer addition patch failed: %w", err)
-			return reconcileErr
+			return Result{}, reconcileErr
 		}
 
 		statusMap := c.updateStatusFunc(u, false, nil)
@@ -620,37 +795,62 @@ func (c *Controller) Reconcile(obj interface{}) (reconcileResult error) {
 	}
 
 	log.Printf("%s Finalizer is present. Running normal reconciliation.\n", logPrefix)
-	if provisionErr := c.provisionFunc(c.client, obj, c.namespaces); provisionErr != nil {
+	result, provisionErr := c.reconciler.Provision(c.client, u, c.config.get())
+	if provisionErr != nil {
 		reconcileErr = fmt.Errorf("provisioning failed: %w", provisionErr)
-		return reconcileErr // Defer handles status update and returns error for retry
+		return result, reconcileErr // Defer handles status update and returns error for retry
 	}
 
+	if key, err := cache.MetaNamespaceKeyFunc(u); err == nil {
+		c.terminal.Delete(key)
+	}
 	statusMap := c.updateStatusFunc(u, true, nil)
+	for k, v := range result.Status {
+		statusMap[k] = v
+	}
 	if statusPatchErr := patchStatus(context.TODO(), c.client, c.gvr, u, statusMap); statusPatchErr != nil {
 		fmt.Printf("%s Warning: Failed to patch status after successful provisioning: %v. Requeuing...\n", logPrefix, statusPatchErr)
 
-		return statusPatchErr
+		return result, statusPatchErr
 	}
 
+	if state, _ := statusMap["state"].(string); state != "Ready" {
+		fmt.Printf("%s Reconciliation is %s, status updated.\n", logPrefix, state)
+		return result, nil
+	}
 	fmt.Printf("%s Normal reconciliation complete and status updated.\n", logPrefix)
+	c.notifyReconciled(u)
 
-	return nil
+	return result, nil
 }
 
-func setupInformer(client dynamic.Interface, gvr schema.GroupVersionResource, controller *Controller, resyncPeriod time.Duration) cache.SharedIndexInformer {
-	informer := cache.NewSharedIndexInformer(
-		&cache.ListWatch{
-			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				return client.Resource(gvr).Namespace("").List(context.TODO(), options)
-			},
-			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-				return client.Resource(gvr).Namespace("").Watch(context.TODO(), options)
-			},
-		},
-		&unstructured.Unstructured{},
-		resyncPeriod,
-		cache.Indexers{},
-	)
+// watchedNamespaces returns the namespaces to run informers for, all namespaces when none are configured.
+func watchedNamespaces(namespaces []string) []string {
+	if len(namespaces) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	return namespaces
+}
+
+// setupInformer watches gvr in namespace, metav1.NamespaceAll watches every namespace.
+// updateReason returns why an update of a CR is reconciled, empty for updates like status patches
+// that don't need it. The pause annotation doesn't change the generation and is checked on its own.
+func updateReason(oldU, newU *unstructured.Unstructured) string {
+	switch {
+	case !newU.GetDeletionTimestamp().IsZero():
+		return "Deletion requested"
+	case oldU.GetResourceVersion() == newU.GetResourceVersion():
+		return "Resync/Periodic Reconcile"
+	case oldU.GetGeneration() != newU.GetGeneration():
+		return "Generation changed"
+	case oldU.GetAnnotations()[pauseAnnotation] != newU.GetAnnotations()[pauseAnnotation]:
+		return "Pause annotation changed"
+	}
+	return ""
+}
+
+func setupInformer(client dynamic.Interface, gvr schema.GroupVersionResource, namespace string, controller *Controller, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	informer := newListWatchInformer(client, gvr, namespace, "", resyncPeriod)
 
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -672,25 +872,14 @@ func setupInformer(client dynamic.Interface, gvr schema.GroupVersionResource, co
 				return
 			}
 
-			generationChanged := oldU.GetGeneration() != newU.GetGeneration()
-			deletionRequested := !newU.GetDeletionTimestamp().IsZero()
-
-			isResync := oldU.GetResourceVersion() == newU.GetResourceVersion()
-
-			if generationChanged || deletionRequested || isResync {
-				key, err := cache.MetaNamespaceKeyFunc(newObj)
-				if err == nil {
-					reason := "Generation changed"
-					if deletionRequested {
-						reason = "Deletion requested"
-					} else if isResync {
-						reason = "Resync/Periodic Reconcile"
-					}
-					fmt.Printf("\n--- %s UPDATE EVENT DETECTED (%s). Queuing %s ---\n", controller.gvr.Resource, reason, key)
-					controller.Queue.Add(key)
-				}
+			key, err := cache.MetaNamespaceKeyFunc(newObj)
+			if err != nil {
+				return
+			}
+			if reason := updateReason(oldU, newU); reason != "" {
+				fmt.Printf("\n--- %s UPDATE EVENT DETECTED (%s). Queuing %s ---\n", controller.gvr.Resource, reason, key)
+				controller.Queue.Add(key)
 			} else {
-				key, _ := cache.MetaNamespaceKeyFunc(newObj)
 				fmt.Printf("--- %s UPDATE EVENT IGNORED Generation not changed. Key: %s ---\n", controller.gvr.Resource, key)
 			}
 		},
@@ -706,29 +895,163 @@ func setupInformer(client dynamic.Interface, gvr schema.GroupVersionResource, co
 	return informer
 }
 
+// tokenPopulatedHandler requeues the CR that owns a token secret as soon as the token controller
+// populates it, so a reconcile waiting for the token doesn't sit out tokenRequeueDelay.
+func tokenPopulatedHandler(controller *Controller, kind string) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldU, err := toUnstructured(oldObj)
+			if err != nil {
+				return
+			}
+			newU, err := toUnstructured(newObj)
+			if err != nil {
+				return
+			}
+			oldToken, _, _ := unstructured.NestedString(oldU.Object, "data", "token")
+			newToken, _, _ := unstructured.NestedString(newU.Object, "data", "token")
+			owner := metav1.GetControllerOf(newU)
+			if oldToken != "" || newToken == "" || owner == nil || owner.Kind != kind {
+				return
+			}
+			key := fmt.Sprintf("%s/%s", newU.GetNamespace(), owner.Name)
+			fmt.Printf("\n--- token secret %s populated. Queuing %s ---\n", newU.GetName(), key)
+			controller.Queue.Add(key)
+		},
+	}
+}
+
+// startControllers wires the ArgoCluster and ArgoNamespace controllers to their informers,
+// waits for the caches to sync and starts the workers. The controllers stop when ctx is cancelled.
+func startControllers(ctx context.Context, client dynamic.Interface, store *configStore) error {
+	cfg := store.markStarted()
+	rateLimiter := workqueue.NewItemExponentialFailureRateLimiter(cfg.RateLimiter.BaseDelay.Duration, cfg.RateLimiter.MaxDelay.Duration)
+	notifications := newNotifier(store)
+
+	argoClusterController := &Controller{
+		client:           client,
+		gvr:              argoClusterGVR,
+		finalizerName:    argoClusterFinalizer,
+		reconciler:       argoClusterReconciler{},
+		updateStatusFunc: updateGenericStatus,
+		config:           store,
+		notifier:         notifications,
+		Queue:            workqueue.NewRateLimitingQueue(rateLimiter),
+	}
+
+	argoNamespaceController := &Controller{
+		client:           client,
+		gvr:              argoNamespaceGVR,
+		finalizerName:    argoNamespaceFinalizer,
+		reconciler:       argoNamespaceReconciler{},
+		updateStatusFunc: updateGenericStatus,
+		config:           store,
+		notifier:         notifications,
+		Queue:            workqueue.NewRateLimitingQueue(rateLimiter),
+	}
+
+	store.onReload(argoClusterController.retryTerminal)
+	store.onReload(argoNamespaceController.retryTerminal)
+
+	// with watch namespaces every namespace gets its own informers, so only namespaced Roles are needed
+	resources := newResourceCache(client)
+	var synced []cache.InformerSynced
+	for _, namespace := range watchedNamespaces(cfg.WatchNamespaces) {
+		for _, controller := range []*Controller{argoClusterController, argoNamespaceController} {
+			informer := setupInformer(client, controller.gvr, namespace, controller, cfg.ResyncPeriod.Duration)
+			resources.add(controller.gvr, informer)
+			go informer.Run(ctx.Done())
+			synced = append(synced, informer.HasSynced)
+		}
+	}
+	synced = append(synced, resources.startCacheInformers(ctx, cfg.WatchNamespaces, tokenPopulatedHandler(argoNamespaceController, "ArgoNamespace"))...)
+	resources.startSourceInformers(ctx, cfg.WatchNamespaces,
+		propagationHandler(argoClusterController, clusterOwners(resources)),
+		propagationHandler(argoNamespaceController, namespaceOwners(resources)))
+	if len(cfg.WatchNamespaces) > 0 {
+		log.Printf("watching namespaces %v", cfg.WatchNamespaces)
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("error waiting for cache sync")
+	}
+	informerCache = resources
+
+	go argoClusterController.Run(ctx, cfg.Workers)
+	go argoNamespaceController.Run(ctx, cfg.Workers)
+	return nil
+}
+
+// buildConfig loads the local kubeconfig if there is one and falls back to the in-cluster config.
+func buildConfig() (*rest.Config, error) {
+	var kubeconfig string
+	if home := homedir.HomeDir(); home != "" {
+		log.Println("using local kubeconfig")
+		kubeconfig = filepath.Join(home, ".kube", "config")
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		log.Println("Falling back to in-cluster config")
+		return rest.InClusterConfig()
+	}
+	return config, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := runRender(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	var namespaces StringSlice
 	flag.Var(&namespaces, "blocked-ns", "blocked namespaces , these namespaces will not be allowed as argo namespace options in the CR(can be specified multiple times)")
+	var allowedNamespaces, watchNamespaces StringSlice
+	flag.Var(&allowedNamespaces, "allowed-ns", "only these namespaces are allowed as argo namespace options in the CR (can be specified multiple times)")
+	flag.Var(&watchNamespaces, "watch-namespaces", "only watch CRs in these namespaces instead of the whole cluster, the controller then only needs namespaced Roles (can be specified multiple times)")
 	resync := flag.Int("resync-period", 60, "time in seconds")
-	resyncPeriod := time.Duration(*resync) * time.Second
+	configFile := flag.String("config", "", "controller config file, fields set in it override the flags")
+	configMap := flag.String("config-map", "", "name of a ConfigMap in the controller namespace with a config.yaml key, it is watched and applied without a restart")
+	debugAddr := flag.String("debug-addr", "127.0.0.1:8080", "address of the debug endpoint reporting the active config, empty disables it")
+	webhook := webhookOptions{}
+	flag.IntVar(&webhook.port, "webhook-port", 9443, "port the crd conversion webhook listens on, 0 disables it")
+	flag.StringVar(&webhook.certDir, "webhook-cert-dir", "", "directory with tls.crt and tls.key for the webhook, a self signed certificate is generated when empty")
+	flag.StringVar(&webhook.service, "webhook-service", "argo-attach-webhook", "name of the service in front of the webhook")
+	flag.StringVar(&webhook.namespace, "webhook-namespace", os.Getenv("POD_NAMESPACE"), "namespace of the webhook service")
+	flag.StringVar(&supervisorURL, "supervisor-url", "", "supervisor api server address registered for ArgoNamespaces attached to a remote argo instance")
+	paused := flag.Bool("paused", false, "skip provisioning of every CR, deletions are still cleaned up. for maintenance windows, single CRs can be paused with the "+pauseAnnotation+" annotation")
+	migrateStorage := flag.Bool("migrate-storage", false, "rewrite existing CRs in the storage version and drop old stored versions on startup")
 
 	flag.Parse() // parse flags
 
-	var kubeconfig string
-	if home := homedir.HomeDir(); home != "" {
-		fmt.Println("using local kubeconfig")
-		kubeconfig = filepath.Join(home, ".kube", "config")
+	base := defaultConfig()
+	// the deployment passes an empty --blocked-ns when none are configured
+	empty := func(ns string) bool { return ns == "" }
+	base.BlockedNamespaces = slices.DeleteFunc(namespaces, empty)
+	base.AllowedNamespaces = slices.DeleteFunc(allowedNamespaces, empty)
+	base.WatchNamespaces = slices.DeleteFunc(watchNamespaces, empty)
+	base.ResyncPeriod = metav1.Duration{Duration: time.Duration(*resync) * time.Second}
+	base.Paused = *paused
+	store, err := newConfigStore(base)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *configFile != "" {
+		if err := store.loadFile(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	config, err := buildConfig()
 	if err != nil {
-		fmt.Println("Falling back to in-cluster config")
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			panic(err.Error())
-		}
+		panic(err.Error())
 	}
 
 	dynClient, err := dynamic.NewForConfig(config)
@@ -736,52 +1059,39 @@ func main() {
 		panic(err.Error())
 	}
 
-	rateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 60*time.Second)
-	argoClusterGVR := schema.GroupVersionResource{Group: "field.vmware.com", Version: "v1", Resource: "argoclusters"}
-	argoClusterFinalizer := "field.vmware.com/argo-attach-cluster-cleanup"
-
-	argoClusterController := &Controller{
-		client:           dynClient,
-		gvr:              argoClusterGVR,
-		finalizerName:    argoClusterFinalizer,
-		provisionFunc:    applyArgoCluster,
-		cleanupFunc:      deleteClusterCleanup,
-		updateStatusFunc: updateGenericStatus,
-		namespaces:       namespaces,
-		Queue:            workqueue.NewRateLimitingQueue(rateLimiter),
+	if *configMap != "" {
+		informer := store.watchConfigMap(ctx, dynClient, webhook.namespace, *configMap)
+		if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+			fmt.Fprintln(os.Stderr, "error waiting for config map sync")
+			os.Exit(1)
+		}
 	}
-
-	argoNamespaceGVR := schema.GroupVersionResource{Group: "field.vmware.com", Version: "v1", Resource: "argonamespaces"}
-	argoNamespaceFinalizer := "field.vmware.com/argo-attach-ns-cleanup"
-
-	argoNamespaceController := &Controller{
-		client:           dynClient,
-		gvr:              argoNamespaceGVR,
-		finalizerName:    argoNamespaceFinalizer,
-		provisionFunc:    applyArgoNamespace,
-		cleanupFunc:      deleteNamespaceCleanup,
-		updateStatusFunc: updateGenericStatus,
-		namespaces:       []string{},
-		Queue:            workqueue.NewRateLimitingQueue(rateLimiter),
+	if *debugAddr != "" {
+		startDebugServer(ctx, *debugAddr, store)
 	}
 
-	clusterInformer := setupInformer(dynClient, argoClusterController.gvr, argoClusterController, resyncPeriod)
-	nsInformer := setupInformer(dynClient, argoNamespaceController.gvr, argoNamespaceController, resyncPeriod)
-
-	stop := make(chan struct{})
-	defer close(stop)
-
-	go clusterInformer.Run(stop)
-	go nsInformer.Run(stop)
+	if webhook.port != 0 {
+		if err := startWebhook(ctx, dynClient, webhook); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
-	if !cache.WaitForCacheSync(stop, clusterInformer.HasSynced, nsInformer.HasSynced) {
-		fmt.Fprintln(os.Stderr, "Error waiting for cache sync")
+	if err := startControllers(ctx, dynClient, store); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	fmt.Println("Argo Cluster Controller started successfully")
 
-	go argoClusterController.Run(ctx, numWorkers)
-	go argoNamespaceController.Run(ctx, numWorkers)
+	if *migrateStorage {
+		go func() {
+			for _, name := range crdNames {
+				if err := migrateStorageVersion(ctx, dynClient, name, store.get().WatchNamespaces); err != nil {
+					log.Printf("storage version migration failed: %v", err)
+				}
+			}
+		}()
+	}
 
 	// Block main function until context is cancelled
 	<-ctx.Done()
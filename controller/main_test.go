@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newTestClusterController(client *fake.FakeDynamicClient, blocked []string) *Controller {
+	return &Controller{
+		client:           client,
+		gvr:              argoClusterGVR,
+		finalizerName:    argoClusterFinalizer,
+		reconciler:       argoClusterReconciler{},
+		updateStatusFunc: updateGenericStatus,
+		config:           &configStore{current: &ControllerConfig{BlockedNamespaces: blocked}},
+	}
+}
+
+func newTestNamespaceController(client *fake.FakeDynamicClient) *Controller {
+	return &Controller{
+		client:           client,
+		gvr:              argoNamespaceGVR,
+		finalizerName:    argoNamespaceFinalizer,
+		reconciler:       argoNamespaceReconciler{},
+		updateStatusFunc: updateGenericStatus,
+	}
+}
+
+func TestReconcileArgoCluster(t *testing.T) {
+	spec := map[string]interface{}{
+		"clusterName":   "wl",
+		"argoNamespace": "argocd",
+		"project":       "default",
+	}
+
+	tests := []struct {
+		name          string
+		objects       func(t *testing.T) []runtime.Object
+		blocked       []string
+		wantErr       string
+		wantRequeue   bool
+		wantFinalizer bool
+		wantSecret    bool
+		wantState     string
+	}{
+		{
+			name: "adds finalizer and provisions",
+			objects: func(t *testing.T) []runtime.Object {
+				return []runtime.Object{
+					newArgoCluster("wl", spec),
+					newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"),
+				}
+			},
+			wantFinalizer: true,
+			wantSecret:    true,
+			wantState:     "Ready",
+		},
+		{
+			name: "blocked argo namespace",
+			objects: func(t *testing.T) []runtime.Object {
+				return []runtime.Object{
+					newArgoCluster("wl", spec, argoClusterFinalizer),
+					newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"),
+				}
+			},
+			blocked:       []string{"kube-system", "argocd"},
+			wantErr:       "blocked namespaces",
+			wantFinalizer: true,
+			wantState:     "Failed",
+		},
+		{
+			name: "missing kubeconfig",
+			objects: func(t *testing.T) []runtime.Object {
+				return []runtime.Object{newArgoCluster("wl", spec, argoClusterFinalizer)}
+			},
+			wantErr:       "unable to retrieve kubeconfig secret",
+			wantRequeue:   true,
+			wantFinalizer: true,
+			wantState:     "Waiting",
+		},
+		{
+			name: "deletion removes secret and finalizer",
+			objects: func(t *testing.T) []runtime.Object {
+				return []runtime.Object{
+					markDeleted(newArgoCluster("wl", spec, argoClusterFinalizer)),
+					newSecret("argocd", "wl-argo-cluster", nil),
+				}
+			},
+		},
+		{
+			name: "deletion with missing secret",
+			objects: func(t *testing.T) []runtime.Object {
+				return []runtime.Object{markDeleted(newArgoCluster("wl", spec, argoClusterFinalizer))}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(tt.objects(t)...)
+			c := newTestClusterController(client, tt.blocked)
+
+			result, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl"))
+			cr := getObject(t, client, argoClusterGVR, "default", "wl")
+			switch {
+			case tt.wantRequeue:
+				// waiting isn't an error, the status tells what the reconcile waits for
+				message, _, _ := unstructured.NestedString(cr.Object, "status", "message")
+				if err != nil || result.RequeueAfter == 0 || !strings.Contains(message, tt.wantErr) {
+					t.Fatalf("result = %+v, %v with message %q, want a requeue waiting for %q", result, err, message, tt.wantErr)
+				}
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+
+			if got := containsFinalizer(cr, argoClusterFinalizer); got != tt.wantFinalizer {
+				t.Errorf("finalizer present = %v, want %v", got, tt.wantFinalizer)
+			}
+			if got := getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") != nil; got != tt.wantSecret {
+				t.Errorf("argo secret present = %v, want %v", got, tt.wantSecret)
+			}
+			if tt.wantState != "" {
+				state, _, _ := unstructured.NestedString(cr.Object, "status", "state")
+				if state != tt.wantState {
+					t.Errorf("status.state = %q, want %q", state, tt.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyArgoClusterSecretData(t *testing.T) {
+	cr := newArgoCluster("wl", map[string]interface{}{
+		"clusterName":        "wl",
+		"argoNamespace":      "argocd",
+		"project":            "platform",
+		"clusterLabels":      map[string]interface{}{"env": "dev"},
+		"clusterAnnotations": map[string]interface{}{"team": "a"},
+		"clusterResources":   false,
+		"namespaces":         []interface{}{"app1", "app2"},
+		"proxyUrl":           "http://proxy:3128",
+	})
+	client := newFakeClient(cr, newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"))
+
+	if err := applyArgoCluster(client, cr, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret := getObject(t, client, secretGVR, "argocd", "wl-argo-cluster")
+	if secret == nil {
+		t.Fatal("argo secret was not created")
+	}
+	data, _, _ := unstructured.NestedStringMap(secret.Object, "stringData")
+	want := map[string]string{
+		"name":             "wl",
+		"server":           "https://10.0.0.1:6443",
+		"project":          "platform",
+		"clusterResources": "false",
+		"namespaces":       "app1,app2",
+	}
+	for k, v := range want {
+		if data[k] != v {
+			t.Errorf("stringData[%s] = %q, want %q", k, data[k], v)
+		}
+	}
+	if !strings.Contains(data["config"], `"proxyUrl":"http://proxy:3128"`) {
+		t.Errorf("config missing proxyUrl: %s", data["config"])
+	}
+	if secret.GetLabels()["argocd.argoproj.io/secret-type"] != "cluster" || secret.GetLabels()["env"] != "dev" {
+		t.Errorf("unexpected labels: %v", secret.GetLabels())
+	}
+	if secret.GetAnnotations()["team"] != "a" {
+		t.Errorf("unexpected annotations: %v", secret.GetAnnotations())
+	}
+}
+
+func TestReconcileArgoNamespace(t *testing.T) {
+	spec := map[string]interface{}{
+		"argoNamespace": "argocd",
+		"project":       "default",
+	}
+	token := base64.StdEncoding.EncodeToString([]byte("sa-token"))
+
+	tests := []struct {
+		name          string
+		objects       []runtime.Object
+		wantFinalizer bool
+		wantSecret    bool
+		wantSA        bool
+	}{
+		{
+			name: "creates service account and argo secret",
+			objects: []runtime.Object{
+				newArgoNamespace("ns", spec),
+				newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": token}),
+			},
+			wantFinalizer: true,
+			wantSecret:    true,
+			wantSA:        true,
+		},
+		{
+			name: "deletion removes the argo secret",
+			objects: []runtime.Object{
+				markDeleted(newArgoNamespace("ns", spec, argoNamespaceFinalizer)),
+				newSecret("argocd", "supervisor-ns-default-argo-cluster", nil),
+			},
+		},
+		{
+			name: "deletion with missing objects",
+			objects: []runtime.Object{
+				markDeleted(newArgoNamespace("ns", spec, argoNamespaceFinalizer)),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(tt.objects...)
+			c := newTestNamespaceController(client)
+
+			if _, err := c.Reconcile(getObject(t, client, argoNamespaceGVR, "default", "ns")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cr := getObject(t, client, argoNamespaceGVR, "default", "ns")
+			if got := containsFinalizer(cr, argoNamespaceFinalizer); got != tt.wantFinalizer {
+				t.Errorf("finalizer present = %v, want %v", got, tt.wantFinalizer)
+			}
+			if got := getObject(t, client, secretGVR, "argocd", "supervisor-ns-default-argo-cluster") != nil; got != tt.wantSecret {
+				t.Errorf("argo secret present = %v, want %v", got, tt.wantSecret)
+			}
+			if got := getObject(t, client, saGVR, "default", "argo-attach-sa") != nil; got != tt.wantSA {
+				t.Errorf("service account present = %v, want %v", got, tt.wantSA)
+			}
+			if got := getObject(t, client, rbGVR, "default", "argo-attach-sa") != nil; got != tt.wantSA {
+				t.Errorf("role binding present = %v, want %v", got, tt.wantSA)
+			}
+		})
+	}
+}
+
+func TestGetSAToken(t *testing.T) {
+	token := base64.StdEncoding.EncodeToString([]byte("sa-token"))
+
+	tests := []struct {
+		name        string
+		objects     []runtime.Object
+		saName      string
+		want        string
+		wantErr     string
+		wantWaiting string
+	}{
+		{
+			name: "token already populated",
+			objects: []runtime.Object{
+				newServiceAccount("default", "argo-attach-sa"),
+				newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": token}),
+			},
+			saName: "argo-attach-sa",
+			want:   "sa-token",
+		},
+		{
+			name: "custom service account",
+			objects: []runtime.Object{
+				newServiceAccount("default", "deployer"),
+				newSecret("default", "deployer-token", map[string]interface{}{"token": token}),
+			},
+			saName: "deployer",
+			want:   "sa-token",
+		},
+		{
+			name:        "token not populated yet",
+			objects:     []runtime.Object{newServiceAccount("default", "deployer")},
+			saName:      "deployer",
+			wantErr:     "deployer-token is not populated yet",
+			wantWaiting: ReasonTokenPending,
+		},
+		{
+			name:        "missing service account",
+			saName:      "argo-attach-sa",
+			wantErr:     "not found",
+			wantWaiting: ReasonServiceAccountNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(tt.objects...)
+			start := time.Now()
+			got, err := getSAToken(client, "default", tt.saName, nil)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("getSAToken blocked for %s", elapsed)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if kind, reason := classifyError(err); kind != errorWaiting || reason != tt.wantWaiting {
+					t.Errorf("classified as %v %s, want waiting %s", kind, reason, tt.wantWaiting)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("token = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	_, err := getSAToken(newFakeClient(newServiceAccount("default", "deployer")), "default", "deployer", nil)
+	if delay := requeueDelay(err); delay != tokenRequeueDelay {
+		t.Errorf("pending token is checked again after %s, want %s", delay, tokenRequeueDelay)
+	}
+}
+
+func TestTokenPopulatedRequeues(t *testing.T) {
+	c := newTestNamespaceController(newFakeClient())
+	c.Queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer c.Queue.ShutDown()
+	handler := tokenPopulatedHandler(c, "ArgoNamespace")
+
+	pending := withController(newSecret("default", "deployer-token", nil), "ArgoNamespace", "ns", "ns-uid")
+	populated := withController(newSecret("default", "deployer-token", map[string]interface{}{"token": "dG9rZW4="}), "ArgoNamespace", "ns", "ns-uid")
+	unowned := newSecret("default", "deployer-token", map[string]interface{}{"token": "dG9rZW4="})
+
+	handler.OnUpdate(populated, populated)
+	handler.OnUpdate(newSecret("default", "deployer-token", nil), unowned)
+	if c.Queue.Len() != 0 {
+		t.Fatalf("queued %d items for secrets that weren't populated by the token controller", c.Queue.Len())
+	}
+	handler.OnUpdate(pending, populated)
+	if c.Queue.Len() != 1 {
+		t.Fatalf("queue length = %d, want 1", c.Queue.Len())
+	}
+	if key, _ := c.Queue.Get(); key != "default/ns" {
+		t.Errorf("queued %v, want default/ns", key)
+	}
+}
+
+func TestWatchNamespaces(t *testing.T) {
+	watched := newArgoCluster("wl", map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default"})
+	watched.SetNamespace("team-a")
+	other := watched.DeepCopy()
+	other.SetNamespace("team-b")
+	client := newFakeClient(watched, other)
+
+	cfg := defaultConfig()
+	cfg.WatchNamespaces = []string{"team-a"}
+	store, err := newConfigStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := startControllers(ctx, client, store); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { informerCache = nil }()
+
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return containsFinalizer(getObject(t, client, argoClusterGVR, "team-a", "wl"), argoClusterFinalizer), nil
+	})
+	if err != nil {
+		t.Fatalf("CR in the watched namespace was not reconciled")
+	}
+	for _, action := range client.Actions() {
+		if action.GetNamespace() == "" && (action.GetVerb() == "list" || action.GetVerb() == "watch") {
+			t.Errorf("unexpected cluster wide %s of %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+	if containsFinalizer(getObject(t, client, argoClusterGVR, "team-b", "wl"), argoClusterFinalizer) {
+		t.Errorf("CR outside the watched namespaces was reconciled")
+	}
+}
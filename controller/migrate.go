@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+// migrateStorageVersion rewrites every object of the CRD so the API server persists it in the
+// storage version, then drops the old versions from status.storedVersions. When namespaces are
+// given only their objects are rewritten and storedVersions is left alone, objects in other
+// namespaces may still be stored in an old version.
+func migrateStorageVersion(ctx context.Context, client dynamic.Interface, crdName string, namespaces []string) error {
+	crd, err := client.Resource(crdGVR).Get(ctx, crdName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get crd %s: %w", crdName, err)
+	}
+
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	storageVersion := ""
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if storage, _, _ := unstructured.NestedBool(version, "storage"); storage {
+			storageVersion, _, _ = unstructured.NestedString(version, "name")
+		}
+	}
+	if storageVersion == "" {
+		return fmt.Errorf("crd %s has no storage version", crdName)
+	}
+
+	storedVersions, _, _ := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	if len(storedVersions) == 1 && storedVersions[0] == storageVersion {
+		log.Printf("crd %s is already stored as %s", crdName, storageVersion)
+		return nil
+	}
+
+	gvr := schema.GroupVersionResource{Group: group, Version: storageVersion, Resource: plural}
+	migrated := 0
+	for _, namespace := range watchedNamespaces(namespaces) {
+		opts := metav1.ListOptions{Limit: 500}
+		for {
+			list, err := client.Resource(gvr).Namespace(namespace).List(ctx, opts)
+			if err != nil {
+				return fmt.Errorf("unable to list %s: %w", plural, err)
+			}
+			for _, item := range list.Items {
+				if err := rewriteObject(ctx, client, gvr, item.GetNamespace(), item.GetName()); err != nil {
+					return fmt.Errorf("unable to migrate %s %s/%s: %w", plural, item.GetNamespace(), item.GetName(), err)
+				}
+				migrated++
+			}
+			if list.GetContinue() == "" {
+				break
+			}
+			opts.Continue = list.GetContinue()
+		}
+	}
+	if len(namespaces) > 0 {
+		log.Printf("migrated %d %s in %v to %s, not updating stored versions in namespaced mode", migrated, plural, namespaces, storageVersion)
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"storedVersions": []string{storageVersion}},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := client.Resource(crdGVR).Patch(ctx, crdName, types.MergePatchType, patch, metav1.PatchOptions{}, "status"); err != nil {
+		return fmt.Errorf("unable to update stored versions of crd %s: %w", crdName, err)
+	}
+	log.Printf("migrated %d %s to %s", migrated, plural, storageVersion)
+	return nil
+}
+
+// rewriteObject issues a no-op update so the object is written back in the storage version.
+func rewriteObject(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := client.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = client.Resource(gvr).Namespace(namespace).Update(ctx, obj, metav1.UpdateOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
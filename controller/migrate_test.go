@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func newCRD(name, plural string, storedVersions ...string) *unstructured.Unstructured {
+	versions := []interface{}{}
+	for _, v := range []string{"v1", "v2"} {
+		versions = append(versions, map[string]interface{}{"name": v, "served": true, "storage": v == "v2"})
+	}
+	stored := []interface{}{}
+	for _, v := range storedVersions {
+		stored = append(stored, v)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"group":    "field.vmware.com",
+			"names":    map[string]interface{}{"plural": plural},
+			"versions": versions,
+		},
+		"status": map[string]interface{}{"storedVersions": stored},
+	}}
+}
+
+func TestMigrateStorageVersion(t *testing.T) {
+	v2GVR := schema.GroupVersionResource{Group: "field.vmware.com", Version: "v2", Resource: "argoclusters"}
+	cluster := newArgoCluster("wl", map[string]interface{}{"clusterName": "wl"})
+	cluster.SetAPIVersion("field.vmware.com/v2")
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		v2GVR:  "ArgoClusterList",
+		crdGVR: "CustomResourceDefinitionList",
+	}, newCRD("argoclusters.field.vmware.com", "argoclusters", "v1", "v2"), cluster)
+
+	if err := migrateStorageVersion(context.TODO(), client, "argoclusters.field.vmware.com", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	updated := false
+	for _, action := range client.Actions() {
+		if action.Matches("update", "argoclusters") {
+			updated = action.(clienttesting.UpdateAction).GetObject().(*unstructured.Unstructured).GetName() == "wl"
+		}
+	}
+	if !updated {
+		t.Errorf("expected wl to be rewritten")
+	}
+
+	crd := getObject(t, client, crdGVR, "", "argoclusters.field.vmware.com")
+	stored, _, _ := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	if len(stored) != 1 || stored[0] != "v2" {
+		t.Errorf("storedVersions = %v, want [v2]", stored)
+	}
+
+	// a second run has nothing to do
+	client.ClearActions()
+	if err := migrateStorageVersion(context.TODO(), client, "argoclusters.field.vmware.com", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("unexpected action %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestMigrateStorageVersionNamespaced(t *testing.T) {
+	v2GVR := schema.GroupVersionResource{Group: "field.vmware.com", Version: "v2", Resource: "argoclusters"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		v2GVR:  "ArgoClusterList",
+		crdGVR: "CustomResourceDefinitionList",
+	}, newCRD("argoclusters.field.vmware.com", "argoclusters", "v1", "v2"))
+
+	if err := migrateStorageVersion(context.TODO(), client, "argoclusters.field.vmware.com", []string{"team-a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "list" && action.GetNamespace() != "team-a" {
+			t.Errorf("unexpected list in namespace %q", action.GetNamespace())
+		}
+		if action.GetVerb() == "patch" {
+			t.Errorf("storedVersions must not be changed when only some namespaces were migrated")
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+)
+
+// NamesStatusFieldManager owns status.registeredName and status.secretName, they are only
+// written once a cluster is registered under them.
+const NamesStatusFieldManager = "names-status-controller"
+
+// the names clusters were registered with before they could be templated
+const (
+	defaultNameTemplate       = "{{ .ClusterName }}"
+	defaultSecretNameTemplate = "{{ .ClusterName }}-argo-cluster"
+)
+
+var nameFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"default": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+}
+
+// nameData is what name templates can refer to.
+type nameData struct {
+	// Namespace and Name of the CR.
+	Namespace string
+	Name      string
+	// ClusterName is the CAPI cluster of an ArgoCluster, see namespaceClusterName for an ArgoNamespace.
+	ClusterName string
+	// Labels of the CR and ClusterLabels of the argo cluster secret.
+	Labels        map[string]string
+	ClusterLabels map[string]string
+}
+
+// namespaceClusterName is the cluster name of an ArgoNamespace, its v1 clusterName or
+// supervisor-ns-<namespace> when that is empty.
+func namespaceClusterName(namespace, clusterName string) string {
+	if clusterName != "" {
+		return clusterName
+	}
+	return fmt.Sprintf("supervisor-ns-%s", namespace)
+}
+
+func newNameData(meta metav1.ObjectMeta, clusterName string, clusterLabels map[string]string) nameData {
+	return nameData{
+		Namespace:     meta.Namespace,
+		Name:          meta.Name,
+		ClusterName:   clusterName,
+		Labels:        meta.Labels,
+		ClusterLabels: clusterLabels,
+	}
+}
+
+// clusterNames are the name of a cluster in Argo CD and the name of its argo cluster secret.
+type clusterNames struct {
+	name   string
+	secret string
+}
+
+func defaultNames(clusterName string) clusterNames {
+	return clusterNames{name: clusterName, secret: fmt.Sprintf("%s-argo-cluster", clusterName)}
+}
+
+func parseNameTemplate(text string) (*template.Template, error) {
+	return template.New("name").Option("missingkey=zero").Funcs(nameFuncs).Parse(text)
+}
+
+// renderName executes a name template, the result has to be a DNS-1123 subdomain.
+func renderName(text string, data nameData) (string, error) {
+	tmpl, err := parseNameTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(out.String())
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return "", fmt.Errorf("%q rendered the invalid name %q: %s", text, name, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// resolveNames renders the name templates, empty templates use the default names.
+func resolveNames(nameTemplate, secretNameTemplate string, data nameData) (clusterNames, error) {
+	if nameTemplate == "" {
+		nameTemplate = defaultNameTemplate
+	}
+	if secretNameTemplate == "" {
+		secretNameTemplate = defaultSecretNameTemplate
+	}
+	name, err := renderName(nameTemplate, data)
+	if err != nil {
+		return clusterNames{}, terminalError(ReasonInvalidSpec, fmt.Errorf("invalid nameTemplate: %w", err))
+	}
+	secret, err := renderName(secretNameTemplate, data)
+	if err != nil {
+		return clusterNames{}, terminalError(ReasonInvalidSpec, fmt.Errorf("invalid secretNameTemplate: %w", err))
+	}
+	return clusterNames{name: name, secret: secret}, nil
+}
+
+// registeredNames are the names the cluster was last registered with. CRs reconciled before the
+// names were recorded used the default names.
+func registeredNames(status argov1.ArgoStatus, clusterName string) clusterNames {
+	if status.RegisteredName == "" || status.SecretName == "" {
+		return defaultNames(clusterName)
+	}
+	return clusterNames{name: status.RegisteredName, secret: status.SecretName}
+}
+
+// renameCluster removes the registration under the previous names once the cluster is registered
+// under the new ones. The applications still deploy to the same server, so the detach policy
+// doesn't apply.
+func renameCluster(client dynamic.Interface, namespace string, argoCluster *argov1.ArgoCluster, previous, names clusterNames) error {
+	if previous == names {
+		return nil
+	}
+	// the argo api identifies clusters by name and has no secret, a secret backend by its secret
+	if usesArgoAPI(client, argoCluster.Spec.ArgoInstance) {
+		if previous.name == names.name {
+			return nil
+		}
+	} else if previous.secret == names.secret {
+		// only the name changed, the secret was updated in place
+		return nil
+	}
+	view := argoCluster.DeepCopy()
+	view.Spec.DetachPolicy = argov1.DetachPolicyProceed
+	if err := unregisterCluster(client, namespace, view, previous); err != nil {
+		return fmt.Errorf("unable to remove the registration as %s after renaming it to %s: %w", previous.name, names.name, err)
+	}
+	log.Printf("renamed argo cluster %s (secret %s) to %s (secret %s)", previous.name, previous.secret, names.name, names.secret)
+	return nil
+}
+
+// patchNamesStatus records the names, the project and the credentials hash the cluster is
+// registered with under their own field manager, so a failed reconcile doesn't forget them.
+func patchNamesStatus(client dynamic.Interface, gvr schema.GroupVersionResource, obj interface{}, names clusterNames, project, credentials string) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+	status := map[string]interface{}{"registeredName": names.name, "secretName": names.secret, "registeredProject": project}
+	if credentials != "" {
+		status["credentialsHash"] = credentials
+	}
+	return applyStatus(context.TODO(), client, gvr, u, status, NamesStatusFieldManager)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestResolveNames(t *testing.T) {
+	data := nameData{
+		Namespace:     "team-a",
+		Name:          "wl",
+		ClusterName:   "wl",
+		Labels:        map[string]string{"team": "Payments"},
+		ClusterLabels: map[string]string{"env": "prod"},
+	}
+	tests := []struct {
+		name         string
+		nameTemplate string
+		secretName   string
+		want         clusterNames
+		wantErr      string
+	}{
+		{name: "defaults", want: clusterNames{name: "wl", secret: "wl-argo-cluster"}},
+		{
+			name:         "labels and funcs",
+			nameTemplate: "{{ .Labels.team | lower }}-{{ .ClusterLabels.env }}-{{ .ClusterName }}",
+			secretName:   "{{ .Labels.owner | default .Namespace }}-{{ .Name }}",
+			want:         clusterNames{name: "payments-prod-wl", secret: "team-a-wl"},
+		},
+		{name: "not a dns name", nameTemplate: "{{ .Labels.team }}", wantErr: `rendered the invalid name "Payments"`},
+		{name: "empty result", secretName: "{{ .Labels.owner }}", wantErr: "invalid secretNameTemplate"},
+		{name: "parse error", nameTemplate: "{{ .Namespace", wantErr: "invalid nameTemplate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveNames(tt.nameTemplate, tt.secretName, data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if kind, _ := classifyError(err); kind != errorTerminal {
+					t.Errorf("classified as %v, want terminal", kind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("names = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameMigration(t *testing.T) {
+	spec := map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default"}
+	cr := newArgoCluster("wl", spec, argoClusterFinalizer)
+	cr.SetLabels(map[string]string{"team": "payments"})
+	// registered before the names were recorded in the status
+	legacy := newSecret("argocd", "wl-argo-cluster", nil)
+	client := newFakeClient(cr, legacy, newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"))
+	c := newTestClusterController(client, nil)
+
+	renamed := getObject(t, client, argoClusterGVR, "default", "wl")
+	_ = unstructured.SetNestedField(renamed.Object, "{{ .Labels.team }}-{{ .ClusterName }}", "spec", "nameTemplate")
+	_ = unstructured.SetNestedField(renamed.Object, "{{ .Labels.team }}-{{ .ClusterName }}", "spec", "secretNameTemplate")
+	if err := client.Tracker().Update(argoClusterGVR, renamed, "default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") != nil {
+		t.Errorf("secret under the previous name was not deleted")
+	}
+	secret := getObject(t, client, secretGVR, "argocd", "payments-wl")
+	if secret == nil {
+		t.Fatal("secret under the new name was not created")
+	}
+	if name, _, _ := unstructured.NestedString(secret.Object, "stringData", "name"); name != "payments-wl" {
+		t.Errorf("argo cluster name = %q, want payments-wl", name)
+	}
+	recorded := getObject(t, client, argoClusterGVR, "default", "wl")
+	registeredName, _, _ := unstructured.NestedString(recorded.Object, "status", "registeredName")
+	secretName, _, _ := unstructured.NestedString(recorded.Object, "status", "secretName")
+	if registeredName != "payments-wl" || secretName != "payments-wl" {
+		t.Errorf("recorded names %q and %q, want payments-wl", registeredName, secretName)
+	}
+
+	// deletion cleans up the recorded names, even after the template changed again
+	deleted := getObject(t, client, argoClusterGVR, "default", "wl")
+	_ = unstructured.SetNestedField(deleted.Object, "other", "spec", "secretNameTemplate")
+	if _, err := c.Reconcile(markDeleted(deleted)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, client, secretGVR, "argocd", "payments-wl") != nil {
+		t.Errorf("secret under the recorded name was not deleted")
+	}
+}
+
+func TestNamespaceNameTemplate(t *testing.T) {
+	token := "c2EtdG9rZW4="
+	objects := []runtime.Object{
+		newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd", "project": "default"}),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": token}),
+	}
+	client := newFakeClient(objects...)
+	cfg := &ControllerConfig{Defaults: ConfigDefaults{NameTemplate: "{{ .Namespace }}.supervisor", SecretNameTemplate: "ns-{{ .Namespace }}"}}
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret := getObject(t, client, secretGVR, "argocd", "ns-default")
+	if secret == nil {
+		t.Fatal("secret was not created under the default template")
+	}
+	if name, _, _ := unstructured.NestedString(secret.Object, "stringData", "name"); name != "default.supervisor" {
+		t.Errorf("argo cluster name = %q, want default.supervisor", name)
+	}
+}
+
+func TestNamespaceClusterName(t *testing.T) {
+	token := "c2EtdG9rZW4="
+	objects := []runtime.Object{
+		newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd", "project": "default", "clusterName": "legacy"}),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": token}),
+	}
+	client := newFakeClient(objects...)
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, client, secretGVR, "argocd", "legacy-argo-cluster") == nil {
+		t.Fatal("secret was not created under the clusterName of the spec")
+	}
+
+	cfg := &ControllerConfig{Defaults: ConfigDefaults{NameTemplate: "{{ .ClusterName }}.supervisor"}}
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the secret name doesn't change with the name, it is updated in place
+	secret := getObject(t, client, secretGVR, "argocd", "legacy-argo-cluster")
+	if secret == nil {
+		t.Fatal("secret updated with the new name was deleted")
+	}
+	if name, _, _ := unstructured.NestedString(secret.Object, "stringData", "name"); name != "legacy.supervisor" {
+		t.Errorf("argo cluster name = %q, want legacy.supervisor", name)
+	}
+}
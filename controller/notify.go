@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Events sent to notification endpoints.
+const (
+	EventReady              = "Ready"
+	EventFailed             = "Failed"
+	EventDetached           = "Detached"
+	EventCredentialsRotated = "CredentialsRotated"
+)
+
+var knownEvents = []string{EventReady, EventFailed, EventDetached, EventCredentialsRotated}
+
+// signatureHeader carries the hex HMAC-SHA256 of the body, prefixed with sha256=.
+const signatureHeader = "X-Argo-Attach-Signature"
+
+const (
+	defaultNotificationAttempts = 3
+	defaultNotificationTimeout  = 10 * time.Second
+)
+
+// notificationBackoff is the delay before the second attempt of a delivery, it doubles after that.
+var notificationBackoff = time.Second
+
+// NotificationEndpoint receives a JSON POST for every event that passes its filters.
+type NotificationEndpoint struct {
+	// Name identifies the endpoint in the logs.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Events sent to the endpoint, every event when empty.
+	Events []string `json:"events,omitempty"`
+	// Kinds and Namespaces limit the events to CRs of these kinds and namespaces when set.
+	Kinds      []string `json:"kinds,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// SecretFile holds the key the payload is signed with, it is read on every delivery so a
+	// mounted secret can be rotated. Payloads aren't signed without it.
+	SecretFile string `json:"secretFile,omitempty"`
+	// Attempts per event before it is dropped, 3 when unset.
+	Attempts int `json:"attempts,omitempty"`
+	// Timeout of a single attempt, 10s when unset.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+func (e *NotificationEndpoint) validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("notification endpoints need a name")
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("notification endpoint %s needs an http or https url", e.Name)
+	}
+	for _, event := range e.Events {
+		if !slices.Contains(knownEvents, event) {
+			return fmt.Errorf("notification endpoint %s has unknown event %s, known events are %v", e.Name, event, knownEvents)
+		}
+	}
+	for _, kind := range e.Kinds {
+		if kind != "ArgoCluster" && kind != "ArgoNamespace" {
+			return fmt.Errorf("notification endpoint %s has unknown kind %s", e.Name, kind)
+		}
+	}
+	if e.Attempts < 0 || e.Timeout.Duration < 0 {
+		return fmt.Errorf("notification endpoint %s can't have negative attempts or timeout", e.Name)
+	}
+	return nil
+}
+
+// matches reports whether the endpoint wants n.
+func (e *NotificationEndpoint) matches(n *notification) bool {
+	return (len(e.Events) == 0 || slices.Contains(e.Events, n.Event)) &&
+		(len(e.Kinds) == 0 || slices.Contains(e.Kinds, n.Kind)) &&
+		(len(e.Namespaces) == 0 || slices.Contains(e.Namespaces, n.Namespace))
+}
+
+// notification is the payload posted to the endpoints.
+type notification struct {
+	Event         string      `json:"event"`
+	Kind          string      `json:"kind"`
+	Namespace     string      `json:"namespace"`
+	Name          string      `json:"name"`
+	ClusterName   string      `json:"clusterName"`
+	ArgoNamespace string      `json:"argoNamespace"`
+	Project       string      `json:"project"`
+	Message       string      `json:"message"`
+	Reason        string      `json:"reason,omitempty"`
+	Time          metav1.Time `json:"time"`
+}
+
+// newNotification describes event on u. The cluster name and the project are the ones recorded in
+// the status, before the first registration they are resolved from the spec and the config defaults
+// like the reconcile does.
+func newNotification(event string, u *unstructured.Unstructured, cfg *ControllerConfig, message, reason string) *notification {
+	n := &notification{
+		Event:     event,
+		Kind:      u.GetKind(),
+		Namespace: u.GetNamespace(),
+		Name:      u.GetName(),
+		Message:   message,
+		Reason:    reason,
+		Time:      metav1.Now(),
+	}
+	n.ClusterName, _, _ = unstructured.NestedString(u.Object, "status", "registeredName")
+	if n.ClusterName == "" {
+		n.ClusterName, _, _ = unstructured.NestedString(u.Object, "spec", "clusterName")
+		if n.Kind == "ArgoNamespace" {
+			n.ClusterName = namespaceClusterName(n.Namespace, n.ClusterName)
+		}
+	}
+	n.ArgoNamespace, _, _ = unstructured.NestedString(u.Object, "spec", "argoNamespace")
+	n.Project, _, _ = unstructured.NestedString(u.Object, "status", "registeredProject")
+	if n.Project == "" {
+		n.Project, _, _ = unstructured.NestedString(u.Object, "spec", "project")
+	}
+	if cfg != nil {
+		if n.ArgoNamespace == "" {
+			n.ArgoNamespace = cfg.Defaults.ArgoNamespace
+		}
+		if n.Project == "" {
+			n.Project = cfg.Defaults.Project
+		}
+	}
+	return n
+}
+
+// notifier posts notifications to the endpoints of the active config. Deliveries run in the
+// background so a slow endpoint never holds up a reconcile.
+type notifier struct {
+	config *configStore
+	client *http.Client
+}
+
+func newNotifier(config *configStore) *notifier {
+	return &notifier{config: config, client: &http.Client{}}
+}
+
+// enabled reports whether the active config has any endpoint. A nil notifier has none.
+func (n *notifier) enabled() bool {
+	if n == nil {
+		return false
+	}
+	cfg := n.config.get()
+	return cfg != nil && len(cfg.Notifications) > 0
+}
+
+// notify sends event on u to every endpoint that wants it.
+func (n *notifier) notify(event string, u *unstructured.Unstructured, message, reason string) {
+	if !n.enabled() {
+		return
+	}
+	cfg := n.config.get()
+	payload := newNotification(event, u, cfg, message, reason)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("unable to encode %s notification for %s/%s: %v", event, payload.Namespace, payload.Name, err)
+		return
+	}
+	for _, endpoint := range cfg.Notifications {
+		if !endpoint.matches(payload) {
+			continue
+		}
+		go n.deliver(endpoint, event, body)
+	}
+}
+
+// deliver posts body to endpoint, retrying with backoff on connection errors, 429 and 5xx.
+func (n *notifier) deliver(endpoint NotificationEndpoint, event string, body []byte) {
+	attempts := endpoint.Attempts
+	if attempts == 0 {
+		attempts = defaultNotificationAttempts
+	}
+	delay := notificationBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(endpoint, event, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= attempts {
+			log.Printf("dropping %s notification for endpoint %s after %d attempts: %v", event, endpoint.Name, attempt, err)
+			return
+		}
+		log.Printf("%s notification for endpoint %s failed, retrying in %s: %v", event, endpoint.Name, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post makes a single attempt and reports whether a failure is worth retrying.
+func (n *notifier) post(endpoint NotificationEndpoint, event string, body []byte) (bool, error) {
+	timeout := endpoint.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultNotificationTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Argo-Attach-Event", event)
+	if endpoint.SecretFile != "" {
+		key, err := os.ReadFile(endpoint.SecretFile)
+		if err != nil {
+			return true, fmt.Errorf("unable to read the signing key: %w", err)
+		}
+		req.Header.Set(signatureHeader, signPayload(bytes.TrimSpace(key), body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("endpoint returned %s", resp.Status)
+}
+
+// notifyReconciled announces a successful reconcile of u. It is Ready unless u was ready before,
+// and CredentialsRotated when the credentials differ from the ones u was registered with before.
+// Both describe the object as the reconcile left it.
+func (c *Controller) notifyReconciled(u *unstructured.Unstructured) {
+	if !c.notifier.enabled() {
+		return
+	}
+	state, _, _ := unstructured.NestedString(u.Object, "status", "state")
+	previous, _, _ := unstructured.NestedString(u.Object, "status", "credentialsHash")
+	if state == "Ready" && previous == "" {
+		return
+	}
+	latest, err := c.client.Resource(c.gvr).Namespace(u.GetNamespace()).Get(context.TODO(), u.GetName(), metav1.GetOptions{})
+	if err != nil {
+		log.Printf("unable to get %s/%s for its notifications: %v", u.GetNamespace(), u.GetName(), err)
+		return
+	}
+	if state != "Ready" {
+		c.notifier.notify(EventReady, latest, "Cluster is attached to argo.", "")
+	}
+	current, _, _ := unstructured.NestedString(latest.Object, "status", "credentialsHash")
+	if previous != "" && current != "" && current != previous {
+		c.notifier.notify(EventCredentialsRotated, latest, "Credentials of the cluster were rotated.", "")
+	}
+}
+
+// signPayload returns the value of the signature header for body.
+func signPayload(key, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// credentialsHash fingerprints the credentials a cluster is registered with, empty when there are none.
+func credentialsHash(parts ...string) string {
+	if strings.Join(parts, "") == "" {
+		return ""
+	}
+	sum := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(sum, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(sum.Sum(nil))[:16]
+}
+
+// kubeconfigCredentialsHash fingerprints the CA and the admin credentials of a workload kubeconfig.
+func kubeconfigCredentialsHash(config *clientcmdapi.Config, clusterName string) string {
+	parts := []string{string(config.Clusters[clusterName].CertificateAuthorityData)}
+	if auth := config.AuthInfos[fmt.Sprintf("%s-admin", clusterName)]; auth != nil {
+		parts = append(parts, string(auth.ClientCertificateData), string(auth.ClientKeyData), auth.Token)
+	}
+	return credentialsHash(parts...)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type receivedNotification struct {
+	path      string
+	signature string
+	payload   notification
+}
+
+// notificationStub records the notifications posted to it.
+func notificationStub(t *testing.T) (*httptest.Server, chan receivedNotification) {
+	t.Helper()
+	received := make(chan receivedNotification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		n := receivedNotification{path: r.URL.Path, signature: r.Header.Get(signatureHeader)}
+		if err := json.Unmarshal(body, &n.payload); err != nil {
+			t.Errorf("invalid payload %s: %v", body, err)
+		}
+		if n.signature != "" && n.signature != signPayload([]byte("s3cret"), body) {
+			t.Errorf("signature %s doesn't match the body", n.signature)
+		}
+		received <- n
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func nextNotification(t *testing.T, received chan receivedNotification) receivedNotification {
+	t.Helper()
+	select {
+	case n := <-received:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a notification")
+		return receivedNotification{}
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	server, received := notificationStub(t)
+	secretFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ControllerConfig{Notifications: []NotificationEndpoint{
+		{Name: "chat", URL: server.URL + "/chat", SecretFile: secretFile},
+		{Name: "failures", URL: server.URL + "/failures", Events: []string{EventFailed}},
+		{Name: "clusters", URL: server.URL + "/clusters", Kinds: []string{"ArgoCluster"}},
+	}}
+	spec := map[string]interface{}{"argoNamespace": "argocd", "project": "team"}
+	client := newFakeClient(
+		newArgoNamespace("ns", spec),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": base64.StdEncoding.EncodeToString([]byte("token-1"))}),
+	)
+	c := newTestNamespaceController(client)
+	c.config = &configStore{current: cfg}
+	c.notifier = newNotifier(c.config)
+
+	if _, err := c.Reconcile(getObject(t, client, argoNamespaceGVR, "default", "ns")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n := nextNotification(t, received)
+	want := notification{Event: EventReady, Kind: "ArgoNamespace", Namespace: "default", Name: "ns",
+		ClusterName: "supervisor-ns-default", ArgoNamespace: "argocd", Project: "team", Message: n.payload.Message}
+	n.payload.Time = metav1.Time{}
+	if n.path != "/chat" || n.payload != want {
+		t.Errorf("got %s %+v, want /chat %+v", n.path, n.payload, want)
+	}
+	if n.signature == "" {
+		t.Errorf("payload wasn't signed")
+	}
+
+	// an unchanged reconcile is no transition, the token rotation is the next event
+	if _, err := c.Reconcile(getObject(t, client, argoNamespaceGVR, "default", "ns")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token := getObject(t, client, secretGVR, "default", "argo-attach-sa-token")
+	_ = unstructured.SetNestedField(token.Object, base64.StdEncoding.EncodeToString([]byte("token-2")), "data", "token")
+	if _, err := client.Resource(secretGVR).Namespace("default").Update(context.TODO(), token, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reconcile(getObject(t, client, argoNamespaceGVR, "default", "ns")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := nextNotification(t, received); n.payload.Event != EventCredentialsRotated {
+		t.Errorf("got %s, want %s", n.payload.Event, EventCredentialsRotated)
+	}
+
+	if _, err := c.Reconcile(markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := nextNotification(t, received); n.payload.Event != EventDetached || n.path != "/chat" {
+		t.Errorf("got %s on %s, want %s on /chat", n.payload.Event, n.path, EventDetached)
+	}
+}
+
+func TestNotificationFailed(t *testing.T) {
+	server, received := notificationStub(t)
+	cfg := &ControllerConfig{
+		BlockedNamespaces: []string{"kube-system"},
+		Notifications:     []NotificationEndpoint{{Name: "failures", URL: server.URL + "/failures", Events: []string{EventFailed}}},
+	}
+	client := newFakeClient(newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "kube-system", "project": "team"}, argoNamespaceFinalizer))
+	c := newTestNamespaceController(client)
+	c.config = &configStore{current: cfg}
+	c.notifier = newNotifier(c.config)
+
+	for range 2 {
+		if _, err := c.Reconcile(getObject(t, client, argoNamespaceGVR, "default", "ns")); err == nil {
+			t.Fatal("expected an error for the blocked namespace")
+		}
+	}
+	n := nextNotification(t, received)
+	if n.payload.Event != EventFailed || n.payload.Reason != ReasonBlockedNamespace || n.payload.ArgoNamespace != "kube-system" {
+		t.Errorf("unexpected notification %+v", n.payload)
+	}
+	select {
+	case n := <-received:
+		t.Errorf("the second failure was announced again: %+v", n.payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNotificationResolvedNames(t *testing.T) {
+	server, received := notificationStub(t)
+	cfg := &ControllerConfig{
+		Defaults:      ConfigDefaults{NameTemplate: "{{ .Namespace }}.supervisor"},
+		Notifications: []NotificationEndpoint{{Name: "chat", URL: server.URL}},
+	}
+	spec := map[string]interface{}{"argoNamespace": "argocd", "projectTemplate": map[string]interface{}{"nameTemplate": "tenant-{{ .Namespace }}"}}
+	client := newFakeClient(
+		newArgoNamespace("ns", spec, argoNamespaceFinalizer),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": base64.StdEncoding.EncodeToString([]byte("token-1"))}),
+	)
+	c := newTestNamespaceController(client)
+	c.config = &configStore{current: cfg}
+	c.notifier = newNotifier(c.config)
+
+	if _, err := c.Reconcile(getObject(t, client, argoNamespaceGVR, "default", "ns")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := nextNotification(t, received); n.payload.ClusterName != "default.supervisor" || n.payload.Project != "tenant-default" {
+		t.Errorf("got cluster %q in project %q, want default.supervisor in tenant-default", n.payload.ClusterName, n.payload.Project)
+	}
+
+	if _, err := c.Reconcile(markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := nextNotification(t, received); n.payload.Event != EventDetached || n.payload.ClusterName != "default.supervisor" || n.payload.Project != "tenant-default" {
+		t.Errorf("unexpected notification %+v", n.payload)
+	}
+}
+
+func TestNotificationRetries(t *testing.T) {
+	defer func(d time.Duration) { notificationBackoff = d }(notificationBackoff)
+	notificationBackoff = time.Millisecond
+
+	var calls atomic.Int32
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(status)
+		}
+	}))
+	defer server.Close()
+	n := newNotifier(nil)
+
+	n.deliver(NotificationEndpoint{Name: "chat", URL: server.URL}, EventReady, []byte("{}"))
+	if got := calls.Load(); got != 3 {
+		t.Errorf("delivered after %d attempts, want 3", got)
+	}
+
+	// client errors aren't retried
+	calls.Store(0)
+	status = http.StatusBadRequest
+	n.deliver(NotificationEndpoint{Name: "chat", URL: server.URL, Attempts: 5}, EventReady, []byte("{}"))
+	if got := calls.Load(); got != 1 {
+		t.Errorf("bad request was attempted %d times, want 1", got)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var namespaceGVR = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+
+// controllerRef makes the CR the controller of a child in its namespace, so the garbage collector
+// removes the child with the CR. blockOwnerDeletion is left unset, it needs update on the CR finalizers.
+func controllerRef(typeMeta metav1.TypeMeta, objectMeta metav1.ObjectMeta) metav1.OwnerReference {
+	apiVersion, kind := typeMeta.APIVersion, typeMeta.Kind
+	if apiVersion == "" {
+		apiVersion = argov1.SchemeGroupVersion.String()
+	}
+	controller := true
+	return metav1.OwnerReference{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Name:       objectMeta.Name,
+		UID:        objectMeta.UID,
+		Controller: &controller,
+	}
+}
+
+// checkAdoption returns an error if an existing child is controlled by anything other than owner.
+// A child without a controller, like the ones created before owner references were set, is adopted
+// by applying it with the owner reference.
+func checkAdoption(client dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string, owner metav1.OwnerReference) error {
+	existing, err := cachedGet(client, gvr, namespace, name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get %s %s/%s: %w", gvr.Resource, namespace, name, err)
+	}
+	current := metav1.GetControllerOf(existing)
+	if current == nil {
+		log.Printf("adopting %s %s/%s into %s %s", gvr.Resource, namespace, name, owner.Kind, owner.Name)
+		return nil
+	}
+	if current.UID != owner.UID {
+		return waitingError(ReasonOwnershipConflict, fmt.Errorf("%s %s/%s is controlled by %s %s", gvr.Resource, namespace, name, current.Kind, current.Name))
+	}
+	return nil
+}
+
+// namespaceTerminating reports whether namespace is being deleted. Errors count as not terminating,
+// the controller may not be allowed to read namespaces.
+func namespaceTerminating(client dynamic.Interface, namespace string) bool {
+	ns, err := client.Resource(namespaceGVR).Get(context.TODO(), namespace, metav1.GetOptions{})
+	if err != nil {
+		return false
+	}
+	return !ns.GetDeletionTimestamp().IsZero()
+}
+
+// deletionPolicy returns the policy to apply, Delete when none is set.
+func deletionPolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return argov1.DeletionPolicyDelete, nil
+	case argov1.DeletionPolicyDelete, argov1.DeletionPolicyRetain, argov1.DeletionPolicyOrphanCredentialsOnly:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown deletionPolicy %q, must be one of %s, %s or %s", policy,
+		argov1.DeletionPolicyDelete, argov1.DeletionPolicyRetain, argov1.DeletionPolicyOrphanCredentialsOnly)
+}
+
+// releaseChild removes the owner references of owner from a child so the garbage collector keeps it
+// after the owner is deleted. A later owner with the same child adopts it again.
+func releaseChild(client dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string, owner types.UID) error {
+	child, err := client.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get %s %s/%s: %w", gvr.Resource, namespace, name, err)
+	}
+	refs := child.GetOwnerReferences()
+	kept := slices.DeleteFunc(slices.Clone(refs), func(ref metav1.OwnerReference) bool { return ref.UID == owner })
+	if len(kept) == len(refs) {
+		return nil
+	}
+	child.SetOwnerReferences(kept)
+	if _, err := client.Resource(gvr).Namespace(namespace).Update(context.TODO(), child, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("unable to release %s %s/%s: %w", gvr.Resource, namespace, name, err)
+	}
+	log.Printf("released %s %s/%s, it is kept after the owner is deleted", gvr.Resource, namespace, name)
+	return nil
+}
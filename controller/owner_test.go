@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func withController(u *unstructured.Unstructured, kind, name string, uid types.UID) *unstructured.Unstructured {
+	controller := true
+	u.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "field.vmware.com/v1", Kind: kind, Name: name, UID: uid, Controller: &controller}})
+	return u
+}
+
+func TestArgoNamespaceOwnerReferences(t *testing.T) {
+	spec := map[string]interface{}{"argoNamespace": "argocd", "project": "default"}
+	token := base64.StdEncoding.EncodeToString([]byte("sa-token"))
+	cr := newArgoNamespace("ns", spec, argoNamespaceFinalizer)
+	cr.SetUID("ns-uid")
+
+	tests := []struct {
+		name     string
+		existing []runtime.Object
+		wantErr  bool
+	}{
+		{
+			name:     "new children",
+			existing: []runtime.Object{newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": token})},
+		},
+		{
+			name: "adopts unowned children",
+			existing: []runtime.Object{
+				newServiceAccount("default", "argo-attach-sa"),
+				newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": token}),
+			},
+		},
+		{
+			name: "children of another controller",
+			existing: []runtime.Object{
+				withController(newServiceAccount("default", "argo-attach-sa"), "ArgoNamespace", "other", "other-uid"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(append(tt.existing, cr.DeepCopy())...)
+			err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil)
+			if tt.wantErr {
+				if kind, reason := classifyError(err); kind != errorWaiting || reason != ReasonOwnershipConflict {
+					t.Fatalf("expected an ownership conflict, got %v", err)
+				}
+				if getObject(t, client, rbGVR, "default", "argo-attach-sa") != nil {
+					t.Errorf("role binding was created for a service account of another controller")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, child := range []*unstructured.Unstructured{
+				getObject(t, client, saGVR, "default", "argo-attach-sa"),
+				getObject(t, client, rbGVR, "default", "argo-attach-sa"),
+				getObject(t, client, secretGVR, "default", "argo-attach-sa-token"),
+			} {
+				owner := metav1.GetControllerOf(child)
+				if owner == nil || owner.UID != "ns-uid" || owner.Kind != "ArgoNamespace" {
+					t.Errorf("%s %s is not controlled by the ArgoNamespace: %v", child.GetKind(), child.GetName(), child.GetOwnerReferences())
+				}
+			}
+		})
+	}
+
+	// an existing service account is not owned, only the token secret created for it
+	existing := map[string]interface{}{"argoNamespace": "argocd", "project": "default", "serviceAccount": "deployer"}
+	client := newFakeClient(newArgoNamespace("ns", existing), newServiceAccount("default", "deployer"), newSecret("default", "deployer-token", map[string]interface{}{"token": token}))
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if refs := getObject(t, client, saGVR, "default", "deployer").GetOwnerReferences(); len(refs) != 0 {
+		t.Errorf("existing service account got owner references %v", refs)
+	}
+	if metav1.GetControllerOf(getObject(t, client, secretGVR, "default", "deployer-token")) == nil {
+		t.Errorf("token secret is not controlled by the ArgoNamespace")
+	}
+}
+
+func TestCleanupInTerminatingNamespace(t *testing.T) {
+	spec := map[string]interface{}{
+		"argoNamespace": "argocd",
+		"project":       "default",
+		"argoInstance":  map[string]interface{}{"kubeconfigSecret": "hub-kubeconfig"},
+	}
+	namespace := markDeleted(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": "default"},
+	}})
+
+	// a broken remote kubeconfig can't be fixed by retrying
+	kubeconfig := newSecret("default", "hub-kubeconfig", map[string]interface{}{"value": base64.StdEncoding.EncodeToString([]byte("{{"))})
+	client := newFakeClient(markDeleted(newArgoNamespace("ns", spec, argoNamespaceFinalizer)), kubeconfig.DeepCopy())
+	c := newTestNamespaceController(client)
+	if _, err := c.Reconcile(getObject(t, client, argoNamespaceGVR, "default", "ns")); err == nil {
+		t.Fatalf("expected cleanup to fail while the namespace is active")
+	}
+	if !containsFinalizer(getObject(t, client, argoNamespaceGVR, "default", "ns"), argoNamespaceFinalizer) {
+		t.Fatalf("finalizer was removed after a failed cleanup")
+	}
+
+	client = newFakeClient(markDeleted(newArgoNamespace("ns", spec, argoNamespaceFinalizer)), kubeconfig, namespace)
+	c = newTestNamespaceController(client)
+	if _, err := c.Reconcile(getObject(t, client, argoNamespaceGVR, "default", "ns")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cr := getObject(t, client, argoNamespaceGVR, "default", "ns"); cr != nil && containsFinalizer(cr, argoNamespaceFinalizer) {
+		t.Errorf("finalizer blocks the deletion of a terminating namespace")
+	}
+
+	// neither can applications that keep a cleanup waiting
+	registered := newSecret("argocd", "wl-argo-cluster", nil)
+	registered.Object["stringData"] = map[string]interface{}{"name": "wl", "server": "https://10.0.0.1:6443"}
+	clusterSpec := map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default", "detachPolicy": "Block"}
+	client = newFakeClient(
+		markDeleted(newArgoCluster("wl", clusterSpec, argoClusterFinalizer)),
+		registered,
+		newApplication("Application", "web", map[string]interface{}{"server": "https://10.0.0.1:6443"}),
+		namespace,
+	)
+	if _, err := newTestClusterController(client, nil).Reconcile(getObject(t, client, argoClusterGVR, "default", "wl")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cr := getObject(t, client, argoClusterGVR, "default", "wl"); cr != nil && containsFinalizer(cr, argoClusterFinalizer) {
+		t.Errorf("waiting cleanup blocks the deletion of a terminating namespace")
+	}
+}
+
+func TestDeletionPolicy(t *testing.T) {
+	tests := []struct {
+		policy        string
+		defaultPolicy string
+		wantSecret    bool
+		wantOwned     bool
+	}{
+		{policy: "", wantOwned: true},
+		{policy: "Delete", defaultPolicy: "Retain", wantOwned: true},
+		{policy: "Retain", wantSecret: true},
+		{policy: "", defaultPolicy: "Retain", wantSecret: true},
+		{policy: "OrphanCredentialsOnly"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+"/"+tt.defaultPolicy, func(t *testing.T) {
+			spec := map[string]interface{}{"argoNamespace": "argocd", "project": "default"}
+			if tt.policy != "" {
+				spec["deletionPolicy"] = tt.policy
+			}
+			cr := markDeleted(newArgoNamespace("ns", spec, argoNamespaceFinalizer))
+			cr.SetUID("ns-uid")
+			client := newFakeClient(
+				cr,
+				withController(newServiceAccount("default", "argo-attach-sa"), "ArgoNamespace", "ns", "ns-uid"),
+				withController(newSecret("default", "argo-attach-sa-token", nil), "ArgoNamespace", "ns", "ns-uid"),
+				newSecret("argocd", "supervisor-ns-default-argo-cluster", nil),
+			)
+			cfg := &ControllerConfig{Defaults: ConfigDefaults{DeletionPolicy: tt.defaultPolicy}}
+			if err := deleteNamespaceCleanup(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), cfg); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := getObject(t, client, secretGVR, "argocd", "supervisor-ns-default-argo-cluster") != nil; got != tt.wantSecret {
+				t.Errorf("argo secret present = %v, want %v", got, tt.wantSecret)
+			}
+			for _, child := range []*unstructured.Unstructured{
+				getObject(t, client, saGVR, "default", "argo-attach-sa"),
+				getObject(t, client, secretGVR, "default", "argo-attach-sa-token"),
+			} {
+				if got := metav1.GetControllerOf(child) != nil; got != tt.wantOwned {
+					t.Errorf("%s owned = %v, want %v", child.GetName(), got, tt.wantOwned)
+				}
+			}
+		})
+	}
+
+	// a retained ArgoCluster stays registered
+	spec := map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default", "deletionPolicy": "Retain"}
+	client := newFakeClient(markDeleted(newArgoCluster("wl", spec, argoClusterFinalizer)), newSecret("argocd", "wl-argo-cluster", nil))
+	if err := deleteClusterCleanup(client, getObject(t, client, argoClusterGVR, "default", "wl"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") == nil {
+		t.Errorf("argo secret of a retained cluster was deleted")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// pauseAnnotation stops the controller from touching a CR. "true" pauses provisioning and still
+// cleans up on deletion, "all" also keeps the finalizer until the annotation is removed.
+const pauseAnnotation = "field.vmware.com/paused"
+
+const (
+	pauseProvisioning = "true"
+	pauseAll          = "all"
+)
+
+// pausedBy returns what pauses the reconcile of u, empty when it isn't paused. deletion reports
+// whether the cleanup on deletion is paused as well.
+func pausedBy(u *unstructured.Unstructured, cfg *ControllerConfig) (source string, deletion bool) {
+	switch u.GetAnnotations()[pauseAnnotation] {
+	case pauseAll:
+		return fmt.Sprintf("the %s annotation", pauseAnnotation), true
+	case pauseProvisioning:
+		return fmt.Sprintf("the %s annotation", pauseAnnotation), false
+	}
+	if cfg != nil && cfg.Paused {
+		return "the controller config", false
+	}
+	return "", false
+}
+
+// pausedStatus reports a paused reconcile and keeps the ready flag of the last reconcile, the
+// objects it created are left as they are.
+func pausedStatus(u *unstructured.Unstructured, source string) map[string]interface{} {
+	ready, _, _ := unstructured.NestedBool(u.Object, "status", "ready")
+	return map[string]interface{}{
+		"state":       "Paused",
+		"message":     fmt.Sprintf("Reconciliation is paused by %s.", source),
+		"ready":       ready,
+		"paused":      true,
+		"lastUpdated": metav1.Now(),
+	}
+}
+
+// isPausedStatus avoids rewriting the status on every resync while the object stays paused.
+func isPausedStatus(u *unstructured.Unstructured, source string) bool {
+	paused, _, _ := unstructured.NestedBool(u.Object, "status", "paused")
+	message, _, _ := unstructured.NestedString(u.Object, "status", "message")
+	return paused && message == fmt.Sprintf("Reconciliation is paused by %s.", source)
+}
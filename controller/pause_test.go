@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPausedReconcile(t *testing.T) {
+	spec := map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default"}
+	paused := func(value string, deleted bool) *unstructured.Unstructured {
+		u := newArgoCluster("wl", spec, argoClusterFinalizer)
+		if value != "" {
+			u.SetAnnotations(map[string]string{pauseAnnotation: value})
+		}
+		if deleted {
+			markDeleted(u)
+		}
+		return u
+	}
+
+	tests := []struct {
+		name          string
+		cr            *unstructured.Unstructured
+		configPaused  bool
+		wantSecret    bool
+		wantFinalizer bool
+		wantPaused    bool
+	}{
+		{name: "annotation skips provisioning", cr: paused("true", false), wantFinalizer: true, wantPaused: true},
+		{name: "config skips provisioning", cr: paused("", false), configPaused: true, wantFinalizer: true, wantPaused: true},
+		{name: "unpaused provisions", cr: paused("false", false), wantSecret: true, wantFinalizer: true},
+		{name: "deletion is still cleaned up", cr: paused("true", true)},
+		{name: "deletion is cleaned up during a maintenance window", cr: paused("", true), configPaused: true},
+		{name: "all also pauses deletion", cr: paused("all", true), wantFinalizer: true, wantPaused: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(tt.cr, newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"))
+			c := newTestClusterController(client, nil)
+			c.config.current.Paused = tt.configPaused
+			if _, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") != nil; got != tt.wantSecret {
+				t.Errorf("argo secret present = %v, want %v", got, tt.wantSecret)
+			}
+			cr := getObject(t, client, argoClusterGVR, "default", "wl")
+			if got := cr != nil && containsFinalizer(cr, argoClusterFinalizer); got != tt.wantFinalizer {
+				t.Errorf("finalizer present = %v, want %v", got, tt.wantFinalizer)
+			}
+			if cr == nil {
+				return
+			}
+			state, _, _ := unstructured.NestedString(cr.Object, "status", "state")
+			gotPaused, _, _ := unstructured.NestedBool(cr.Object, "status", "paused")
+			if gotPaused != tt.wantPaused || (tt.wantPaused && state != "Paused") {
+				t.Errorf("status state %q paused %v, want paused %v", state, gotPaused, tt.wantPaused)
+			}
+		})
+	}
+}
+
+func TestPauseAnnotationUpdate(t *testing.T) {
+	spec := map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default"}
+	object := func(resourceVersion string, generation int64, pause string) *unstructured.Unstructured {
+		u := newArgoCluster("wl", spec)
+		u.SetResourceVersion(resourceVersion)
+		u.SetGeneration(generation)
+		if pause != "" {
+			u.SetAnnotations(map[string]string{pauseAnnotation: pause})
+		}
+		return u
+	}
+	tests := []struct {
+		name     string
+		old, new *unstructured.Unstructured
+		want     string
+	}{
+		{name: "status update", old: object("1", 1, ""), new: object("2", 1, ""), want: ""},
+		{name: "paused", old: object("1", 1, ""), new: object("2", 1, pauseProvisioning), want: "Pause annotation changed"},
+		{name: "pause widened", old: object("1", 1, pauseProvisioning), new: object("2", 1, pauseAll), want: "Pause annotation changed"},
+		{name: "unpaused", old: object("1", 1, pauseAll), new: object("2", 1, ""), want: "Pause annotation changed"},
+		{name: "status update while paused", old: object("1", 1, pauseAll), new: object("2", 1, pauseAll), want: ""},
+		{name: "spec change", old: object("1", 1, ""), new: object("2", 2, ""), want: "Generation changed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := updateReason(tt.old, tt.new); got != tt.want {
+				t.Errorf("updateReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	http "net/http"
+
+	apiv1 "github.com/warroyo/argocd-attach-service/api/v1"
+	scheme "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/scheme"
+	rest "k8s.io/client-go/rest"
+)
+
+type FieldV1Interface interface {
+	RESTClient() rest.Interface
+	ArgoClustersGetter
+	ArgoNamespacesGetter
+}
+
+// FieldV1Client is used to interact with features provided by the field.vmware.com group.
+type FieldV1Client struct {
+	restClient rest.Interface
+}
+
+func (c *FieldV1Client) ArgoClusters(namespace string) ArgoClusterInterface {
+	return newArgoClusters(c, namespace)
+}
+
+func (c *FieldV1Client) ArgoNamespaces(namespace string) ArgoNamespaceInterface {
+	return newArgoNamespaces(c, namespace)
+}
+
+// NewForConfig creates a new FieldV1Client for the given config.
+// NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
+// where httpClient was generated with rest.HTTPClientFor(c).
+func NewForConfig(c *rest.Config) (*FieldV1Client, error) {
+	config := *c
+	setConfigDefaults(&config)
+	httpClient, err := rest.HTTPClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return NewForConfigAndClient(&config, httpClient)
+}
+
+// NewForConfigAndClient creates a new FieldV1Client for the given config and http client.
+// Note the http client provided takes precedence over the configured transport values.
+func NewForConfigAndClient(c *rest.Config, h *http.Client) (*FieldV1Client, error) {
+	config := *c
+	setConfigDefaults(&config)
+	client, err := rest.RESTClientForConfigAndClient(&config, h)
+	if err != nil {
+		return nil, err
+	}
+	return &FieldV1Client{client}, nil
+}
+
+// NewForConfigOrDie creates a new FieldV1Client for the given config and
+// panics if there is an error in the config.
+func NewForConfigOrDie(c *rest.Config) *FieldV1Client {
+	client, err := NewForConfig(c)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// New creates a new FieldV1Client for the given RESTClient.
+func New(c rest.Interface) *FieldV1Client {
+	return &FieldV1Client{c}
+}
+
+func setConfigDefaults(config *rest.Config) {
+	gv := apiv1.SchemeGroupVersion
+	config.GroupVersion = &gv
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = rest.CodecFactoryForGeneratedClient(scheme.Scheme, scheme.Codecs).WithoutConversion()
+
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+}
+
+// RESTClient returns a RESTClient that is used to communicate
+// with API server by this client implementation.
+func (c *FieldV1Client) RESTClient() rest.Interface {
+	if c == nil {
+		return nil
+	}
+	return c.restClient
+}
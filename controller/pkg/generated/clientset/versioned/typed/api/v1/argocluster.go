@@ -0,0 +1,54 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	context "context"
+
+	apiv1 "github.com/warroyo/argocd-attach-service/api/v1"
+	scheme "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// ArgoClustersGetter has a method to return a ArgoClusterInterface.
+// A group's client should implement this interface.
+type ArgoClustersGetter interface {
+	ArgoClusters(namespace string) ArgoClusterInterface
+}
+
+// ArgoClusterInterface has methods to work with ArgoCluster resources.
+type ArgoClusterInterface interface {
+	Create(ctx context.Context, argoCluster *apiv1.ArgoCluster, opts metav1.CreateOptions) (*apiv1.ArgoCluster, error)
+	Update(ctx context.Context, argoCluster *apiv1.ArgoCluster, opts metav1.UpdateOptions) (*apiv1.ArgoCluster, error)
+	// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+	UpdateStatus(ctx context.Context, argoCluster *apiv1.ArgoCluster, opts metav1.UpdateOptions) (*apiv1.ArgoCluster, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*apiv1.ArgoCluster, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*apiv1.ArgoClusterList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (result *apiv1.ArgoCluster, err error)
+	ArgoClusterExpansion
+}
+
+// argoClusters implements ArgoClusterInterface
+type argoClusters struct {
+	*gentype.ClientWithList[*apiv1.ArgoCluster, *apiv1.ArgoClusterList]
+}
+
+// newArgoClusters returns a ArgoClusters
+func newArgoClusters(c *FieldV1Client, namespace string) *argoClusters {
+	return &argoClusters{
+		gentype.NewClientWithList[*apiv1.ArgoCluster, *apiv1.ArgoClusterList](
+			"argoclusters",
+			c.RESTClient(),
+			scheme.ParameterCodec,
+			namespace,
+			func() *apiv1.ArgoCluster { return &apiv1.ArgoCluster{} },
+			func() *apiv1.ArgoClusterList { return &apiv1.ArgoClusterList{} },
+		),
+	}
+}
@@ -0,0 +1,54 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	context "context"
+
+	apiv1 "github.com/warroyo/argocd-attach-service/api/v1"
+	scheme "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// ArgoNamespacesGetter has a method to return a ArgoNamespaceInterface.
+// A group's client should implement this interface.
+type ArgoNamespacesGetter interface {
+	ArgoNamespaces(namespace string) ArgoNamespaceInterface
+}
+
+// ArgoNamespaceInterface has methods to work with ArgoNamespace resources.
+type ArgoNamespaceInterface interface {
+	Create(ctx context.Context, argoNamespace *apiv1.ArgoNamespace, opts metav1.CreateOptions) (*apiv1.ArgoNamespace, error)
+	Update(ctx context.Context, argoNamespace *apiv1.ArgoNamespace, opts metav1.UpdateOptions) (*apiv1.ArgoNamespace, error)
+	// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+	UpdateStatus(ctx context.Context, argoNamespace *apiv1.ArgoNamespace, opts metav1.UpdateOptions) (*apiv1.ArgoNamespace, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*apiv1.ArgoNamespace, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*apiv1.ArgoNamespaceList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (result *apiv1.ArgoNamespace, err error)
+	ArgoNamespaceExpansion
+}
+
+// argoNamespaces implements ArgoNamespaceInterface
+type argoNamespaces struct {
+	*gentype.ClientWithList[*apiv1.ArgoNamespace, *apiv1.ArgoNamespaceList]
+}
+
+// newArgoNamespaces returns a ArgoNamespaces
+func newArgoNamespaces(c *FieldV1Client, namespace string) *argoNamespaces {
+	return &argoNamespaces{
+		gentype.NewClientWithList[*apiv1.ArgoNamespace, *apiv1.ArgoNamespaceList](
+			"argonamespaces",
+			c.RESTClient(),
+			scheme.ParameterCodec,
+			namespace,
+			func() *apiv1.ArgoNamespace { return &apiv1.ArgoNamespace{} },
+			func() *apiv1.ArgoNamespaceList { return &apiv1.ArgoNamespaceList{} },
+		),
+	}
+}
@@ -0,0 +1,28 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1 "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/typed/api/v1"
+	rest "k8s.io/client-go/rest"
+	testing "k8s.io/client-go/testing"
+)
+
+type FakeFieldV1 struct {
+	*testing.Fake
+}
+
+func (c *FakeFieldV1) ArgoClusters(namespace string) v1.ArgoClusterInterface {
+	return newFakeArgoClusters(c, namespace)
+}
+
+func (c *FakeFieldV1) ArgoNamespaces(namespace string) v1.ArgoNamespaceInterface {
+	return newFakeArgoNamespaces(c, namespace)
+}
+
+// RESTClient returns a RESTClient that is used to communicate
+// with API server by this client implementation.
+func (c *FakeFieldV1) RESTClient() rest.Interface {
+	var ret *rest.RESTClient
+	return ret
+}
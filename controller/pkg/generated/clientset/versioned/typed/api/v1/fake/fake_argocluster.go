@@ -0,0 +1,32 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1 "github.com/warroyo/argocd-attach-service/api/v1"
+	apiv1 "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/typed/api/v1"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// fakeArgoClusters implements ArgoClusterInterface
+type fakeArgoClusters struct {
+	*gentype.FakeClientWithList[*v1.ArgoCluster, *v1.ArgoClusterList]
+	Fake *FakeFieldV1
+}
+
+func newFakeArgoClusters(fake *FakeFieldV1, namespace string) apiv1.ArgoClusterInterface {
+	return &fakeArgoClusters{
+		gentype.NewFakeClientWithList[*v1.ArgoCluster, *v1.ArgoClusterList](
+			fake.Fake,
+			namespace,
+			v1.SchemeGroupVersion.WithResource("argoclusters"),
+			v1.SchemeGroupVersion.WithKind("ArgoCluster"),
+			func() *v1.ArgoCluster { return &v1.ArgoCluster{} },
+			func() *v1.ArgoClusterList { return &v1.ArgoClusterList{} },
+			func(dst, src *v1.ArgoClusterList) { dst.ListMeta = src.ListMeta },
+			func(list *v1.ArgoClusterList) []*v1.ArgoCluster { return gentype.ToPointerSlice(list.Items) },
+			func(list *v1.ArgoClusterList, items []*v1.ArgoCluster) { list.Items = gentype.FromPointerSlice(items) },
+		),
+		fake,
+	}
+}
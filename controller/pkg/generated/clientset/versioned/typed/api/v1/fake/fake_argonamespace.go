@@ -0,0 +1,34 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1 "github.com/warroyo/argocd-attach-service/api/v1"
+	apiv1 "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/typed/api/v1"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// fakeArgoNamespaces implements ArgoNamespaceInterface
+type fakeArgoNamespaces struct {
+	*gentype.FakeClientWithList[*v1.ArgoNamespace, *v1.ArgoNamespaceList]
+	Fake *FakeFieldV1
+}
+
+func newFakeArgoNamespaces(fake *FakeFieldV1, namespace string) apiv1.ArgoNamespaceInterface {
+	return &fakeArgoNamespaces{
+		gentype.NewFakeClientWithList[*v1.ArgoNamespace, *v1.ArgoNamespaceList](
+			fake.Fake,
+			namespace,
+			v1.SchemeGroupVersion.WithResource("argonamespaces"),
+			v1.SchemeGroupVersion.WithKind("ArgoNamespace"),
+			func() *v1.ArgoNamespace { return &v1.ArgoNamespace{} },
+			func() *v1.ArgoNamespaceList { return &v1.ArgoNamespaceList{} },
+			func(dst, src *v1.ArgoNamespaceList) { dst.ListMeta = src.ListMeta },
+			func(list *v1.ArgoNamespaceList) []*v1.ArgoNamespace { return gentype.ToPointerSlice(list.Items) },
+			func(list *v1.ArgoNamespaceList, items []*v1.ArgoNamespace) {
+				list.Items = gentype.FromPointerSlice(items)
+			},
+		),
+		fake,
+	}
+}
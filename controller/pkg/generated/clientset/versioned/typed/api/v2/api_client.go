@@ -0,0 +1,90 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package v2
+
+import (
+	http "net/http"
+
+	apiv2 "github.com/warroyo/argocd-attach-service/api/v2"
+	scheme "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/scheme"
+	rest "k8s.io/client-go/rest"
+)
+
+type FieldV2Interface interface {
+	RESTClient() rest.Interface
+	ArgoClustersGetter
+	ArgoNamespacesGetter
+}
+
+// FieldV2Client is used to interact with features provided by the field.vmware.com group.
+type FieldV2Client struct {
+	restClient rest.Interface
+}
+
+func (c *FieldV2Client) ArgoClusters(namespace string) ArgoClusterInterface {
+	return newArgoClusters(c, namespace)
+}
+
+func (c *FieldV2Client) ArgoNamespaces(namespace string) ArgoNamespaceInterface {
+	return newArgoNamespaces(c, namespace)
+}
+
+// NewForConfig creates a new FieldV2Client for the given config.
+// NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
+// where httpClient was generated with rest.HTTPClientFor(c).
+func NewForConfig(c *rest.Config) (*FieldV2Client, error) {
+	config := *c
+	setConfigDefaults(&config)
+	httpClient, err := rest.HTTPClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return NewForConfigAndClient(&config, httpClient)
+}
+
+// NewForConfigAndClient creates a new FieldV2Client for the given config and http client.
+// Note the http client provided takes precedence over the configured transport values.
+func NewForConfigAndClient(c *rest.Config, h *http.Client) (*FieldV2Client, error) {
+	config := *c
+	setConfigDefaults(&config)
+	client, err := rest.RESTClientForConfigAndClient(&config, h)
+	if err != nil {
+		return nil, err
+	}
+	return &FieldV2Client{client}, nil
+}
+
+// NewForConfigOrDie creates a new FieldV2Client for the given config and
+// panics if there is an error in the config.
+func NewForConfigOrDie(c *rest.Config) *FieldV2Client {
+	client, err := NewForConfig(c)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// New creates a new FieldV2Client for the given RESTClient.
+func New(c rest.Interface) *FieldV2Client {
+	return &FieldV2Client{c}
+}
+
+func setConfigDefaults(config *rest.Config) {
+	gv := apiv2.SchemeGroupVersion
+	config.GroupVersion = &gv
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = rest.CodecFactoryForGeneratedClient(scheme.Scheme, scheme.Codecs).WithoutConversion()
+
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+}
+
+// RESTClient returns a RESTClient that is used to communicate
+// with API server by this client implementation.
+func (c *FieldV2Client) RESTClient() rest.Interface {
+	if c == nil {
+		return nil
+	}
+	return c.restClient
+}
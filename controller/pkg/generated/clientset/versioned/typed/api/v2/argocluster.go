@@ -0,0 +1,54 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package v2
+
+import (
+	context "context"
+
+	apiv2 "github.com/warroyo/argocd-attach-service/api/v2"
+	scheme "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// ArgoClustersGetter has a method to return a ArgoClusterInterface.
+// A group's client should implement this interface.
+type ArgoClustersGetter interface {
+	ArgoClusters(namespace string) ArgoClusterInterface
+}
+
+// ArgoClusterInterface has methods to work with ArgoCluster resources.
+type ArgoClusterInterface interface {
+	Create(ctx context.Context, argoCluster *apiv2.ArgoCluster, opts v1.CreateOptions) (*apiv2.ArgoCluster, error)
+	Update(ctx context.Context, argoCluster *apiv2.ArgoCluster, opts v1.UpdateOptions) (*apiv2.ArgoCluster, error)
+	// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+	UpdateStatus(ctx context.Context, argoCluster *apiv2.ArgoCluster, opts v1.UpdateOptions) (*apiv2.ArgoCluster, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*apiv2.ArgoCluster, error)
+	List(ctx context.Context, opts v1.ListOptions) (*apiv2.ArgoClusterList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *apiv2.ArgoCluster, err error)
+	ArgoClusterExpansion
+}
+
+// argoClusters implements ArgoClusterInterface
+type argoClusters struct {
+	*gentype.ClientWithList[*apiv2.ArgoCluster, *apiv2.ArgoClusterList]
+}
+
+// newArgoClusters returns a ArgoClusters
+func newArgoClusters(c *FieldV2Client, namespace string) *argoClusters {
+	return &argoClusters{
+		gentype.NewClientWithList[*apiv2.ArgoCluster, *apiv2.ArgoClusterList](
+			"argoclusters",
+			c.RESTClient(),
+			scheme.ParameterCodec,
+			namespace,
+			func() *apiv2.ArgoCluster { return &apiv2.ArgoCluster{} },
+			func() *apiv2.ArgoClusterList { return &apiv2.ArgoClusterList{} },
+		),
+	}
+}
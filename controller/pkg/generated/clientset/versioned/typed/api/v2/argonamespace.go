@@ -0,0 +1,54 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package v2
+
+import (
+	context "context"
+
+	apiv2 "github.com/warroyo/argocd-attach-service/api/v2"
+	scheme "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// ArgoNamespacesGetter has a method to return a ArgoNamespaceInterface.
+// A group's client should implement this interface.
+type ArgoNamespacesGetter interface {
+	ArgoNamespaces(namespace string) ArgoNamespaceInterface
+}
+
+// ArgoNamespaceInterface has methods to work with ArgoNamespace resources.
+type ArgoNamespaceInterface interface {
+	Create(ctx context.Context, argoNamespace *apiv2.ArgoNamespace, opts v1.CreateOptions) (*apiv2.ArgoNamespace, error)
+	Update(ctx context.Context, argoNamespace *apiv2.ArgoNamespace, opts v1.UpdateOptions) (*apiv2.ArgoNamespace, error)
+	// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+	UpdateStatus(ctx context.Context, argoNamespace *apiv2.ArgoNamespace, opts v1.UpdateOptions) (*apiv2.ArgoNamespace, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*apiv2.ArgoNamespace, error)
+	List(ctx context.Context, opts v1.ListOptions) (*apiv2.ArgoNamespaceList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *apiv2.ArgoNamespace, err error)
+	ArgoNamespaceExpansion
+}
+
+// argoNamespaces implements ArgoNamespaceInterface
+type argoNamespaces struct {
+	*gentype.ClientWithList[*apiv2.ArgoNamespace, *apiv2.ArgoNamespaceList]
+}
+
+// newArgoNamespaces returns a ArgoNamespaces
+func newArgoNamespaces(c *FieldV2Client, namespace string) *argoNamespaces {
+	return &argoNamespaces{
+		gentype.NewClientWithList[*apiv2.ArgoNamespace, *apiv2.ArgoNamespaceList](
+			"argonamespaces",
+			c.RESTClient(),
+			scheme.ParameterCodec,
+			namespace,
+			func() *apiv2.ArgoNamespace { return &apiv2.ArgoNamespace{} },
+			func() *apiv2.ArgoNamespaceList { return &apiv2.ArgoNamespaceList{} },
+		),
+	}
+}
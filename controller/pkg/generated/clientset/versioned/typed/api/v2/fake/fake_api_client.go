@@ -0,0 +1,28 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v2 "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/typed/api/v2"
+	rest "k8s.io/client-go/rest"
+	testing "k8s.io/client-go/testing"
+)
+
+type FakeFieldV2 struct {
+	*testing.Fake
+}
+
+func (c *FakeFieldV2) ArgoClusters(namespace string) v2.ArgoClusterInterface {
+	return newFakeArgoClusters(c, namespace)
+}
+
+func (c *FakeFieldV2) ArgoNamespaces(namespace string) v2.ArgoNamespaceInterface {
+	return newFakeArgoNamespaces(c, namespace)
+}
+
+// RESTClient returns a RESTClient that is used to communicate
+// with API server by this client implementation.
+func (c *FakeFieldV2) RESTClient() rest.Interface {
+	var ret *rest.RESTClient
+	return ret
+}
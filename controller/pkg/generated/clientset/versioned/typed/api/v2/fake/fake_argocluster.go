@@ -0,0 +1,32 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v2 "github.com/warroyo/argocd-attach-service/api/v2"
+	apiv2 "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/typed/api/v2"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// fakeArgoClusters implements ArgoClusterInterface
+type fakeArgoClusters struct {
+	*gentype.FakeClientWithList[*v2.ArgoCluster, *v2.ArgoClusterList]
+	Fake *FakeFieldV2
+}
+
+func newFakeArgoClusters(fake *FakeFieldV2, namespace string) apiv2.ArgoClusterInterface {
+	return &fakeArgoClusters{
+		gentype.NewFakeClientWithList[*v2.ArgoCluster, *v2.ArgoClusterList](
+			fake.Fake,
+			namespace,
+			v2.SchemeGroupVersion.WithResource("argoclusters"),
+			v2.SchemeGroupVersion.WithKind("ArgoCluster"),
+			func() *v2.ArgoCluster { return &v2.ArgoCluster{} },
+			func() *v2.ArgoClusterList { return &v2.ArgoClusterList{} },
+			func(dst, src *v2.ArgoClusterList) { dst.ListMeta = src.ListMeta },
+			func(list *v2.ArgoClusterList) []*v2.ArgoCluster { return gentype.ToPointerSlice(list.Items) },
+			func(list *v2.ArgoClusterList, items []*v2.ArgoCluster) { list.Items = gentype.FromPointerSlice(items) },
+		),
+		fake,
+	}
+}
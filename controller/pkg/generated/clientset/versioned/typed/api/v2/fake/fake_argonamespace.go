@@ -0,0 +1,34 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v2 "github.com/warroyo/argocd-attach-service/api/v2"
+	apiv2 "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned/typed/api/v2"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// fakeArgoNamespaces implements ArgoNamespaceInterface
+type fakeArgoNamespaces struct {
+	*gentype.FakeClientWithList[*v2.ArgoNamespace, *v2.ArgoNamespaceList]
+	Fake *FakeFieldV2
+}
+
+func newFakeArgoNamespaces(fake *FakeFieldV2, namespace string) apiv2.ArgoNamespaceInterface {
+	return &fakeArgoNamespaces{
+		gentype.NewFakeClientWithList[*v2.ArgoNamespace, *v2.ArgoNamespaceList](
+			fake.Fake,
+			namespace,
+			v2.SchemeGroupVersion.WithResource("argonamespaces"),
+			v2.SchemeGroupVersion.WithKind("ArgoNamespace"),
+			func() *v2.ArgoNamespace { return &v2.ArgoNamespace{} },
+			func() *v2.ArgoNamespaceList { return &v2.ArgoNamespaceList{} },
+			func(dst, src *v2.ArgoNamespaceList) { dst.ListMeta = src.ListMeta },
+			func(list *v2.ArgoNamespaceList) []*v2.ArgoNamespace { return gentype.ToPointerSlice(list.Items) },
+			func(list *v2.ArgoNamespaceList, items []*v2.ArgoNamespace) {
+				list.Items = gentype.FromPointerSlice(items)
+			},
+		),
+		fake,
+	}
+}
@@ -0,0 +1,86 @@
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	context "context"
+	time "time"
+
+	argocdattachserviceapiv1 "github.com/warroyo/argocd-attach-service/api/v1"
+	versioned "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned"
+	internalinterfaces "github.com/warroyo/argocd-attach-service/pkg/generated/informers/externalversions/internalinterfaces"
+	apiv1 "github.com/warroyo/argocd-attach-service/pkg/generated/listers/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// ArgoClusterInformer provides access to a shared informer and lister for
+// ArgoClusters.
+type ArgoClusterInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() apiv1.ArgoClusterLister
+}
+
+type argoClusterInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewArgoClusterInformer constructs a new informer for ArgoCluster type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewArgoClusterInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredArgoClusterInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredArgoClusterInformer constructs a new informer for ArgoCluster type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredArgoClusterInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.FieldV1().ArgoClusters(namespace).List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.FieldV1().ArgoClusters(namespace).Watch(context.Background(), options)
+			},
+			ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.FieldV1().ArgoClusters(namespace).List(ctx, options)
+			},
+			WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.FieldV1().ArgoClusters(namespace).Watch(ctx, options)
+			},
+		},
+		&argocdattachserviceapiv1.ArgoCluster{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *argoClusterInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredArgoClusterInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *argoClusterInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&argocdattachserviceapiv1.ArgoCluster{}, f.defaultInformer)
+}
+
+func (f *argoClusterInformer) Lister() apiv1.ArgoClusterLister {
+	return apiv1.NewArgoClusterLister(f.Informer().GetIndexer())
+}
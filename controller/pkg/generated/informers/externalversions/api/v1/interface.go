@@ -0,0 +1,36 @@
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	internalinterfaces "github.com/warroyo/argocd-attach-service/pkg/generated/informers/externalversions/internalinterfaces"
+)
+
+// Interface provides access to all the informers in this group version.
+type Interface interface {
+	// ArgoClusters returns a ArgoClusterInformer.
+	ArgoClusters() ArgoClusterInformer
+	// ArgoNamespaces returns a ArgoNamespaceInformer.
+	ArgoNamespaces() ArgoNamespaceInformer
+}
+
+type version struct {
+	factory          internalinterfaces.SharedInformerFactory
+	namespace        string
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+}
+
+// New returns a new Interface.
+func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakListOptions internalinterfaces.TweakListOptionsFunc) Interface {
+	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
+}
+
+// ArgoClusters returns a ArgoClusterInformer.
+func (v *version) ArgoClusters() ArgoClusterInformer {
+	return &argoClusterInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// ArgoNamespaces returns a ArgoNamespaceInformer.
+func (v *version) ArgoNamespaces() ArgoNamespaceInformer {
+	return &argoNamespaceInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
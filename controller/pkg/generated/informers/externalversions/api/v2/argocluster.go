@@ -0,0 +1,86 @@
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v2
+
+import (
+	context "context"
+	time "time"
+
+	argocdattachserviceapiv2 "github.com/warroyo/argocd-attach-service/api/v2"
+	versioned "github.com/warroyo/argocd-attach-service/pkg/generated/clientset/versioned"
+	internalinterfaces "github.com/warroyo/argocd-attach-service/pkg/generated/informers/externalversions/internalinterfaces"
+	apiv2 "github.com/warroyo/argocd-attach-service/pkg/generated/listers/api/v2"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// ArgoClusterInformer provides access to a shared informer and lister for
+// ArgoClusters.
+type ArgoClusterInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() apiv2.ArgoClusterLister
+}
+
+type argoClusterInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewArgoClusterInformer constructs a new informer for ArgoCluster type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewArgoClusterInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredArgoClusterInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredArgoClusterInformer constructs a new informer for ArgoCluster type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredArgoClusterInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.FieldV2().ArgoClusters(namespace).List(context.Background(), options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.FieldV2().ArgoClusters(namespace).Watch(context.Background(), options)
+			},
+			ListWithContextFunc: func(ctx context.Context, options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.FieldV2().ArgoClusters(namespace).List(ctx, options)
+			},
+			WatchFuncWithContext: func(ctx context.Context, options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.FieldV2().ArgoClusters(namespace).Watch(ctx, options)
+			},
+		},
+		&argocdattachserviceapiv2.ArgoCluster{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *argoClusterInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredArgoClusterInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *argoClusterInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&argocdattachserviceapiv2.ArgoCluster{}, f.defaultInformer)
+}
+
+func (f *argoClusterInformer) Lister() apiv2.ArgoClusterLister {
+	return apiv2.NewArgoClusterLister(f.Informer().GetIndexer())
+}
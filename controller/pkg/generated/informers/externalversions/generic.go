@@ -0,0 +1,55 @@
+// Code generated by informer-gen. DO NOT EDIT.
+
+package externalversions
+
+import (
+	fmt "fmt"
+
+	v1 "github.com/warroyo/argocd-attach-service/api/v1"
+	v2 "github.com/warroyo/argocd-attach-service/api/v2"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// GenericInformer is type of SharedIndexInformer which will locate and delegate to other
+// sharedInformers based on type
+type GenericInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() cache.GenericLister
+}
+
+type genericInformer struct {
+	informer cache.SharedIndexInformer
+	resource schema.GroupResource
+}
+
+// Informer returns the SharedIndexInformer.
+func (f *genericInformer) Informer() cache.SharedIndexInformer {
+	return f.informer
+}
+
+// Lister returns the GenericLister.
+func (f *genericInformer) Lister() cache.GenericLister {
+	return cache.NewGenericLister(f.Informer().GetIndexer(), f.resource)
+}
+
+// ForResource gives generic access to a shared informer of the matching type
+// TODO extend this to unknown resources with a client pool
+func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
+	switch resource {
+	// Group=field.vmware.com, Version=v1
+	case v1.SchemeGroupVersion.WithResource("argoclusters"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Field().V1().ArgoClusters().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("argonamespaces"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Field().V1().ArgoNamespaces().Informer()}, nil
+
+		// Group=field.vmware.com, Version=v2
+	case v2.SchemeGroupVersion.WithResource("argoclusters"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Field().V2().ArgoClusters().Informer()}, nil
+	case v2.SchemeGroupVersion.WithResource("argonamespaces"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Field().V2().ArgoNamespaces().Informer()}, nil
+
+	}
+
+	return nil, fmt.Errorf("no informer found for %v", resource)
+}
@@ -0,0 +1,54 @@
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	apiv1 "github.com/warroyo/argocd-attach-service/api/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	listers "k8s.io/client-go/listers"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// ArgoNamespaceLister helps list ArgoNamespaces.
+// All objects returned here must be treated as read-only.
+type ArgoNamespaceLister interface {
+	// List lists all ArgoNamespaces in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*apiv1.ArgoNamespace, err error)
+	// ArgoNamespaces returns an object that can list and get ArgoNamespaces.
+	ArgoNamespaces(namespace string) ArgoNamespaceNamespaceLister
+	ArgoNamespaceListerExpansion
+}
+
+// argoNamespaceLister implements the ArgoNamespaceLister interface.
+type argoNamespaceLister struct {
+	listers.ResourceIndexer[*apiv1.ArgoNamespace]
+}
+
+// NewArgoNamespaceLister returns a new ArgoNamespaceLister.
+func NewArgoNamespaceLister(indexer cache.Indexer) ArgoNamespaceLister {
+	return &argoNamespaceLister{listers.New[*apiv1.ArgoNamespace](indexer, apiv1.Resource("argonamespace"))}
+}
+
+// ArgoNamespaces returns an object that can list and get ArgoNamespaces.
+func (s *argoNamespaceLister) ArgoNamespaces(namespace string) ArgoNamespaceNamespaceLister {
+	return argoNamespaceNamespaceLister{listers.NewNamespaced[*apiv1.ArgoNamespace](s.ResourceIndexer, namespace)}
+}
+
+// ArgoNamespaceNamespaceLister helps list and get ArgoNamespaces.
+// All objects returned here must be treated as read-only.
+type ArgoNamespaceNamespaceLister interface {
+	// List lists all ArgoNamespaces in the indexer for a given namespace.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*apiv1.ArgoNamespace, err error)
+	// Get retrieves the ArgoNamespace from the indexer for a given namespace and name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*apiv1.ArgoNamespace, error)
+	ArgoNamespaceNamespaceListerExpansion
+}
+
+// argoNamespaceNamespaceLister implements the ArgoNamespaceNamespaceLister
+// interface.
+type argoNamespaceNamespaceLister struct {
+	listers.ResourceIndexer[*apiv1.ArgoNamespace]
+}
@@ -0,0 +1,54 @@
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v2
+
+import (
+	apiv2 "github.com/warroyo/argocd-attach-service/api/v2"
+	labels "k8s.io/apimachinery/pkg/labels"
+	listers "k8s.io/client-go/listers"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// ArgoClusterLister helps list ArgoClusters.
+// All objects returned here must be treated as read-only.
+type ArgoClusterLister interface {
+	// List lists all ArgoClusters in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*apiv2.ArgoCluster, err error)
+	// ArgoClusters returns an object that can list and get ArgoClusters.
+	ArgoClusters(namespace string) ArgoClusterNamespaceLister
+	ArgoClusterListerExpansion
+}
+
+// argoClusterLister implements the ArgoClusterLister interface.
+type argoClusterLister struct {
+	listers.ResourceIndexer[*apiv2.ArgoCluster]
+}
+
+// NewArgoClusterLister returns a new ArgoClusterLister.
+func NewArgoClusterLister(indexer cache.Indexer) ArgoClusterLister {
+	return &argoClusterLister{listers.New[*apiv2.ArgoCluster](indexer, apiv2.Resource("argocluster"))}
+}
+
+// ArgoClusters returns an object that can list and get ArgoClusters.
+func (s *argoClusterLister) ArgoClusters(namespace string) ArgoClusterNamespaceLister {
+	return argoClusterNamespaceLister{listers.NewNamespaced[*apiv2.ArgoCluster](s.ResourceIndexer, namespace)}
+}
+
+// ArgoClusterNamespaceLister helps list and get ArgoClusters.
+// All objects returned here must be treated as read-only.
+type ArgoClusterNamespaceLister interface {
+	// List lists all ArgoClusters in the indexer for a given namespace.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*apiv2.ArgoCluster, err error)
+	// Get retrieves the ArgoCluster from the indexer for a given namespace and name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*apiv2.ArgoCluster, error)
+	ArgoClusterNamespaceListerExpansion
+}
+
+// argoClusterNamespaceLister implements the ArgoClusterNamespaceLister
+// interface.
+type argoClusterNamespaceLister struct {
+	listers.ResourceIndexer[*apiv2.ArgoCluster]
+}
@@ -0,0 +1,54 @@
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v2
+
+import (
+	apiv2 "github.com/warroyo/argocd-attach-service/api/v2"
+	labels "k8s.io/apimachinery/pkg/labels"
+	listers "k8s.io/client-go/listers"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// ArgoNamespaceLister helps list ArgoNamespaces.
+// All objects returned here must be treated as read-only.
+type ArgoNamespaceLister interface {
+	// List lists all ArgoNamespaces in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*apiv2.ArgoNamespace, err error)
+	// ArgoNamespaces returns an object that can list and get ArgoNamespaces.
+	ArgoNamespaces(namespace string) ArgoNamespaceNamespaceLister
+	ArgoNamespaceListerExpansion
+}
+
+// argoNamespaceLister implements the ArgoNamespaceLister interface.
+type argoNamespaceLister struct {
+	listers.ResourceIndexer[*apiv2.ArgoNamespace]
+}
+
+// NewArgoNamespaceLister returns a new ArgoNamespaceLister.
+func NewArgoNamespaceLister(indexer cache.Indexer) ArgoNamespaceLister {
+	return &argoNamespaceLister{listers.New[*apiv2.ArgoNamespace](indexer, apiv2.Resource("argonamespace"))}
+}
+
+// ArgoNamespaces returns an object that can list and get ArgoNamespaces.
+func (s *argoNamespaceLister) ArgoNamespaces(namespace string) ArgoNamespaceNamespaceLister {
+	return argoNamespaceNamespaceLister{listers.NewNamespaced[*apiv2.ArgoNamespace](s.ResourceIndexer, namespace)}
+}
+
+// ArgoNamespaceNamespaceLister helps list and get ArgoNamespaces.
+// All objects returned here must be treated as read-only.
+type ArgoNamespaceNamespaceLister interface {
+	// List lists all ArgoNamespaces in the indexer for a given namespace.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*apiv2.ArgoNamespace, err error)
+	// Get retrieves the ArgoNamespace from the indexer for a given namespace and name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*apiv2.ArgoNamespace, error)
+	ArgoNamespaceNamespaceListerExpansion
+}
+
+// argoNamespaceNamespaceLister implements the ArgoNamespaceNamespaceLister
+// interface.
+type argoNamespaceNamespaceLister struct {
+	listers.ResourceIndexer[*apiv2.ArgoNamespace]
+}
@@ -0,0 +1,19 @@
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v2
+
+// ArgoClusterListerExpansion allows custom methods to be added to
+// ArgoClusterLister.
+type ArgoClusterListerExpansion interface{}
+
+// ArgoClusterNamespaceListerExpansion allows custom methods to be added to
+// ArgoClusterNamespaceLister.
+type ArgoClusterNamespaceListerExpansion interface{}
+
+// ArgoNamespaceListerExpansion allows custom methods to be added to
+// ArgoNamespaceLister.
+type ArgoNamespaceListerExpansion interface{}
+
+// ArgoNamespaceNamespaceListerExpansion allows custom methods to be added to
+// ArgoNamespaceNamespaceLister.
+type ArgoNamespaceNamespaceListerExpansion interface{}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// tenantProjectLabel marks the AppProjects created for an ArgoNamespace with its namespace. A new
+// ArgoNamespace in the same namespace adopts them, any other project is never touched.
+const tenantProjectLabel = "field.vmware.com/argo-attach-tenant"
+
+const defaultProjectNameTemplate = "{{ .Namespace }}"
+
+// tenantProjectName renders the name of the AppProject of argoNs.
+func tenantProjectName(tmpl *argov1.ProjectTemplate, data nameData) (string, error) {
+	text := tmpl.NameTemplate
+	if text == "" {
+		text = defaultProjectNameTemplate
+	}
+	name, err := renderName(text, data)
+	if err != nil {
+		return "", terminalError(ReasonInvalidSpec, fmt.Errorf("invalid projectTemplate.nameTemplate: %w", err))
+	}
+	return name, nil
+}
+
+// newTenantProject builds an AppProject that only deploys namespaced resources into namespace on
+// the cluster registered as server and name.
+func newTenantProject(tmpl *argov1.ProjectTemplate, argoNamespace, project, namespace, server, name string) *unstructured.Unstructured {
+	repos := []interface{}{}
+	for _, repo := range tmpl.SourceRepos {
+		repos = append(repos, repo)
+	}
+	roles := []interface{}{}
+	for _, role := range tmpl.Roles {
+		actions := role.Actions
+		if len(actions) == 0 {
+			actions = []string{"get"}
+		}
+		policies := []interface{}{}
+		for _, action := range actions {
+			policies = append(policies, fmt.Sprintf("p, proj:%s:%s, applications, %s, %s/*, allow", project, role.Name, action, project))
+		}
+		groups := []interface{}{}
+		for _, group := range role.Groups {
+			groups = append(groups, group)
+		}
+		roles = append(roles, map[string]interface{}{
+			"name":        role.Name,
+			"description": role.Description,
+			"policies":    policies,
+			"groups":      groups,
+		})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "AppProject",
+		"metadata": map[string]interface{}{
+			"name":      project,
+			"namespace": argoNamespace,
+			"labels":    map[string]interface{}{tenantProjectLabel: namespace},
+		},
+		"spec": map[string]interface{}{
+			"description": fmt.Sprintf("applications of supervisor namespace %s", namespace),
+			"destinations": []interface{}{
+				map[string]interface{}{"server": server, "namespace": namespace},
+				map[string]interface{}{"name": name, "namespace": namespace},
+			},
+			"clusterResourceBlacklist": []interface{}{
+				map[string]interface{}{"group": "*", "kind": "*"},
+			},
+			"sourceRepos": repos,
+			"roles":       roles,
+		},
+	}}
+}
+
+// applyTenantProject creates or updates the AppProject of argoNs in the argo instance and returns its
+// name. Projects with the same name that weren't created for the namespace are left alone.
+func applyTenantProject(client dynamic.Interface, argoNs *argov1.ArgoNamespace, names clusterNames, server string) (string, error) {
+	tmpl := argoNs.Spec.ProjectTemplate
+	ref := argoNs.Spec.ArgoInstance
+	if ref != nil && ref.API != nil {
+		return "", terminalError(ReasonInvalidSpec, fmt.Errorf("projectTemplate isn't supported with the argo api"))
+	}
+	project, err := tenantProjectName(tmpl, newNameData(argoNs.ObjectMeta, argoNs.Spec.ClusterName, argoNs.Spec.ClusterLabels))
+	if err != nil {
+		return "", err
+	}
+	target, argoNamespace, err := argoTarget(client, argoNs.Namespace, argoNs.Spec.ArgoNamespace, ref)
+	if err != nil {
+		return "", err
+	}
+	existing, err := target.Resource(appProjectGVR).Namespace(argoNamespace).Get(context.TODO(), project, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("unable to get appproject %s: %w", project, err)
+	}
+	if err == nil && existing.GetLabels()[tenantProjectLabel] != argoNs.Namespace {
+		return "", waitingError(ReasonOwnershipConflict, fmt.Errorf("appproject %s/%s already exists and wasn't created for namespace %s", argoNamespace, project, argoNs.Namespace))
+	}
+
+	obj := newTenantProject(tmpl, argoNamespace, project, argoNs.Namespace, server, names.name)
+	if _, err := target.Resource(appProjectGVR).Namespace(argoNamespace).Apply(context.TODO(), project, obj, metav1.ApplyOptions{FieldManager: "argo-attach-controller", Force: true}); err != nil {
+		return "", fmt.Errorf("unable to create or update appproject %s: %w", project, err)
+	}
+	log.Printf("created or updated appproject %s/%s for namespace %s", argoNamespace, project, argoNs.Namespace)
+	return project, nil
+}
+
+// pruneTenantProjects deletes the AppProjects created for the namespace of argoNs except keep. A
+// project that applications or application sets still use is left in place.
+func pruneTenantProjects(client dynamic.Interface, argoNs *argov1.ArgoNamespace, keep string) error {
+	ref := argoNs.Spec.ArgoInstance
+	if ref != nil && ref.API != nil {
+		return nil
+	}
+	target, argoNamespace, err := argoTarget(client, argoNs.Namespace, argoNs.Spec.ArgoNamespace, ref)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	selector := fmt.Sprintf("%s=%s", tenantProjectLabel, argoNs.Namespace)
+	projects, err := target.Resource(appProjectGVR).Namespace(argoNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list appprojects in %s: %w", argoNamespace, err)
+	}
+	for _, project := range projects.Items {
+		name := project.GetName()
+		if name == keep {
+			continue
+		}
+		// the namespace of the tenant is checked even if it is no source namespace anymore
+		users, err := projectApplications(target, projectNamespaces(&project, argoNamespace, argoNs.Namespace), name)
+		if apierrors.IsForbidden(err) {
+			log.Printf("keeping appproject %s/%s, unable to check the applications using it: %v", argoNamespace, name, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if users > 0 {
+			log.Printf("keeping appproject %s/%s, %d applications still use it", argoNamespace, name, users)
+			continue
+		}
+		err = target.Resource(appProjectGVR).Namespace(argoNamespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete appproject %s/%s: %w", argoNamespace, name, err)
+		}
+		log.Printf("deleted appproject %s/%s", argoNamespace, name)
+	}
+	return nil
+}
+
+// projectNamespaces adds the source namespaces of project to namespaces, the namespaces applications
+// using it can live in. Patterns can't be listed and are skipped.
+func projectNamespaces(project *unstructured.Unstructured, namespaces ...string) []string {
+	if project != nil {
+		sources, _, _ := unstructured.NestedStringSlice(project.Object, "spec", "sourceNamespaces")
+		for _, namespace := range sources {
+			if !strings.ContainsAny(namespace, "*?[/") && !slices.Contains(namespaces, namespace) {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+	}
+	return namespaces
+}
+
+// projectApplications counts the applications and application sets in namespaces using project.
+func projectApplications(client dynamic.Interface, namespaces []string, project string) (int, error) {
+	count := 0
+	kinds := []struct {
+		gvr  schema.GroupVersionResource
+		path []string
+	}{
+		{applicationGVR, []string{"spec", "project"}},
+		{applicationSetGVR, []string{"spec", "template", "spec", "project"}},
+	}
+	for _, namespace := range namespaces {
+		for _, kind := range kinds {
+			list, err := client.Resource(kind.gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return 0, fmt.Errorf("unable to list %s in %s: %w", kind.gvr.Resource, namespace, err)
+			}
+			for _, item := range list.Items {
+				if p, _, _ := unstructured.NestedString(item.Object, kind.path...); p == project {
+					count++
+				}
+			}
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestTenantProject(t *testing.T) {
+	spec := map[string]interface{}{
+		"argoNamespace": "argocd",
+		"projectTemplate": map[string]interface{}{
+			"nameTemplate": "tenant-{{ .Namespace }}",
+			"sourceRepos":  []interface{}{"https://git.example.com/team/*"},
+			"roles": []interface{}{
+				map[string]interface{}{"name": "dev", "groups": []interface{}{"team-dev"}, "actions": []interface{}{"get", "sync"}},
+			},
+		},
+	}
+	objects := []runtime.Object{
+		newArgoNamespace("ns", spec, argoNamespaceFinalizer),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": "c2EtdG9rZW4="}),
+	}
+	client := newFakeClient(objects...)
+
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	project := getObject(t, client, appProjectGVR, "argocd", "tenant-default")
+	if project == nil {
+		t.Fatal("appproject was not created")
+	}
+	if project.GetLabels()[tenantProjectLabel] != "default" {
+		t.Errorf("appproject labels = %v", project.GetLabels())
+	}
+	destinations, _, _ := unstructured.NestedSlice(project.Object, "spec", "destinations")
+	for _, d := range destinations {
+		if d.(map[string]interface{})["namespace"] != "default" {
+			t.Errorf("destination %v isn't restricted to the namespace", d)
+		}
+	}
+	if denied, _, _ := unstructured.NestedSlice(project.Object, "spec", "clusterResourceBlacklist"); len(denied) != 1 {
+		t.Errorf("cluster scoped resources aren't denied: %v", denied)
+	}
+	roles, _, _ := unstructured.NestedSlice(project.Object, "spec", "roles")
+	policies, _, _ := unstructured.NestedStringSlice(roles[0].(map[string]interface{}), "policies")
+	want := []string{
+		"p, proj:tenant-default:dev, applications, get, tenant-default/*, allow",
+		"p, proj:tenant-default:dev, applications, sync, tenant-default/*, allow",
+	}
+	if len(policies) != 2 || policies[0] != want[0] || policies[1] != want[1] {
+		t.Errorf("policies = %v, want %v", policies, want)
+	}
+	secret := getObject(t, client, secretGVR, "argocd", "supervisor-ns-default-argo-cluster")
+	if got, _, _ := unstructured.NestedString(secret.Object, "stringData", "project"); got != "tenant-default" {
+		t.Errorf("cluster project = %q, want tenant-default", got)
+	}
+
+	// a new name template replaces the project
+	cr := getObject(t, client, argoNamespaceGVR, "default", "ns")
+	_ = unstructured.SetNestedField(cr.Object, "team-{{ .Namespace }}", "spec", "projectTemplate", "nameTemplate")
+	if _, err := client.Resource(argoNamespaceGVR).Namespace("default").Update(context.TODO(), cr, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, client, appProjectGVR, "argocd", "tenant-default") != nil {
+		t.Errorf("project of the previous name template was not deleted")
+	}
+	if getObject(t, client, appProjectGVR, "argocd", "team-default") == nil {
+		t.Fatal("project was not created under the new name")
+	}
+
+	// a project still in use survives the detach
+	app := newApplication("Application", "web", map[string]interface{}{"namespace": "default"})
+	_ = unstructured.SetNestedField(app.Object, "team-default", "spec", "project")
+	if err := client.Tracker().Add(app); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteNamespaceCleanup(client, markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns")), nil); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	if getObject(t, client, appProjectGVR, "argocd", "team-default") == nil {
+		t.Errorf("project was deleted while an application uses it")
+	}
+	if err := client.Tracker().Delete(applicationGVR, "argocd", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteNamespaceCleanup(client, markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns")), nil); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	if getObject(t, client, appProjectGVR, "argocd", "team-default") != nil {
+		t.Errorf("unused project was not deleted on detach")
+	}
+}
+
+func TestTenantProjectConflict(t *testing.T) {
+	objects := []runtime.Object{
+		newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd", "projectTemplate": map[string]interface{}{}}),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": "c2EtdG9rZW4="}),
+		// argo's own default project has the name the template renders for the default namespace
+		newArgoObject("argoproj.io/v1alpha1", "AppProject", "default", map[string]interface{}{}),
+	}
+	client := newFakeClient(objects...)
+
+	err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil)
+	if kind, reason := classifyError(err); kind != errorWaiting || reason != ReasonOwnershipConflict {
+		t.Fatalf("error = %v, want an ownership conflict", err)
+	}
+	if labels := getObject(t, client, appProjectGVR, "argocd", "default").GetLabels(); labels[tenantProjectLabel] != "" {
+		t.Errorf("existing project was taken over: %v", labels)
+	}
+}
+
+func TestTenantProjectSourceNamespaces(t *testing.T) {
+	// the tenant namespace was already removed from the source namespaces by the detach
+	project := newArgoObject("argoproj.io/v1alpha1", "AppProject", "tenant-default", map[string]interface{}{
+		"spec": map[string]interface{}{"sourceNamespaces": []interface{}{"shared", "team-*"}},
+	})
+	project.SetLabels(map[string]string{tenantProjectLabel: "default"})
+	app := func(name, namespace string) *unstructured.Unstructured {
+		u := newApplication("Application", name, map[string]interface{}{"namespace": "default"})
+		u.SetNamespace(namespace)
+		_ = unstructured.SetNestedField(u.Object, "tenant-default", "spec", "project")
+		return u
+	}
+	client := newFakeClient(project, app("web", "default"), app("db", "shared"))
+	argoNs := &argov1.ArgoNamespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Namespace: "default"}, Spec: &argov1.ArgoNamespaceSpec{ArgoNamespace: "argocd"}}
+
+	for _, app := range []struct{ namespace, name string }{{"shared", "db"}, {"default", "web"}} {
+		if err := pruneTenantProjects(client, argoNs, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if getObject(t, client, appProjectGVR, "argocd", "tenant-default") == nil {
+			t.Fatalf("project was deleted while application %s/%s uses it", app.namespace, app.name)
+		}
+		if err := client.Tracker().Delete(applicationGVR, app.namespace, app.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pruneTenantProjects(client, argoNs, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, client, appProjectGVR, "argocd", "tenant-default") != nil {
+		t.Errorf("unused project was not deleted")
+	}
+}
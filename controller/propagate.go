@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+// capiClusterGVR is the CAPI Cluster an ArgoCluster registers, it has the same name as spec.clusterName.
+var capiClusterGVR = schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "clusters"}
+
+// defaultPropagationPrefix replaces the prefix of propagated keys when the config doesn't set one.
+const defaultPropagationPrefix = "argo-attach.field.vmware.com"
+
+// PropagationConfig copies labels and annotations of the source of a CR, the CAPI Cluster of an
+// ArgoCluster or the supervisor Namespace of an ArgoNamespace, to its argo cluster secret.
+type PropagationConfig struct {
+	// Prefix replaces the prefix of propagated keys, it has to start with argo-attach.
+	Prefix string `json:"prefix,omitempty"`
+	// Labels and Annotations select the keys of the source that are copied.
+	Labels      PropagationRules `json:"labels,omitempty"`
+	Annotations PropagationRules `json:"annotations,omitempty"`
+	// ClusterInfo adds the kubernetes version, cluster class and failure domain of CAPI clusters.
+	ClusterInfo bool `json:"clusterInfo,omitempty"`
+}
+
+// PropagationRules select keys by prefix. A key is copied when it starts with one of Include and
+// none of Exclude, an empty string in Include matches every key.
+type PropagationRules struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+func (r PropagationRules) deepCopy() PropagationRules {
+	return PropagationRules{Include: slices.Clone(r.Include), Exclude: slices.Clone(r.Exclude)}
+}
+
+func (r PropagationRules) matches(key string) bool {
+	hasPrefix := func(prefix string) bool { return strings.HasPrefix(key, prefix) }
+	return slices.ContainsFunc(r.Include, hasPrefix) && !slices.ContainsFunc(r.Exclude, hasPrefix)
+}
+
+func (p *PropagationConfig) enabled() bool {
+	return p != nil && (len(p.Labels.Include) > 0 || len(p.Annotations.Include) > 0 || p.ClusterInfo)
+}
+
+func (p *PropagationConfig) validate() error {
+	if p.Prefix == "" {
+		return nil
+	}
+	if !strings.HasPrefix(p.Prefix, "argo-attach.") {
+		return fmt.Errorf("propagation prefix %q has to start with \"argo-attach.\"", p.Prefix)
+	}
+	if errs := validation.IsDNS1123Subdomain(p.Prefix); len(errs) > 0 {
+		return fmt.Errorf("invalid propagation prefix %q: %v", p.Prefix, errs)
+	}
+	return nil
+}
+
+// key rewrites a source key into the propagation prefix, the prefix of the source key is dropped.
+func (p *PropagationConfig) key(name string) string {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = defaultPropagationPrefix
+	}
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return fmt.Sprintf("%s/%s", prefix, name)
+}
+
+// copyKeys copies the keys of source matching rules, rewritten into the prefix. Keys that end up
+// the same are resolved in key order so the result doesn't change between reconciles.
+func (p *PropagationConfig) copyKeys(rules PropagationRules, source map[string]string) map[string]string {
+	out := map[string]string{}
+	for _, k := range slices.Sorted(maps.Keys(source)) {
+		if !rules.matches(k) {
+			continue
+		}
+		key := p.key(k)
+		if _, ok := out[key]; ok {
+			log.Printf("not propagating %s, %s was already propagated from another key", k, key)
+			continue
+		}
+		out[key] = source[k]
+	}
+	return out
+}
+
+// sourceMetadata returns the labels and annotations propagated from source.
+func sourceMetadata(p *PropagationConfig, source *unstructured.Unstructured) (labels, annotations map[string]string) {
+	if !p.enabled() || source == nil {
+		return nil, nil
+	}
+	labels = p.copyKeys(p.Labels, source.GetLabels())
+	annotations = p.copyKeys(p.Annotations, source.GetAnnotations())
+	if p.ClusterInfo && source.GetKind() == "Cluster" {
+		addClusterInfo(p, source, labels, annotations)
+	}
+	return labels, annotations
+}
+
+// addClusterInfo describes a CAPI cluster for cluster generators. Label values can't hold every
+// version or list of failure domains, the raw values are kept in annotations.
+func addClusterInfo(p *PropagationConfig, cluster *unstructured.Unstructured, labels, annotations map[string]string) {
+	if version, _, _ := unstructured.NestedString(cluster.Object, "spec", "topology", "version"); version != "" {
+		annotations[p.key("kubernetes-version")] = version
+		// the same encoding TKR names use for build metadata
+		if value := strings.ReplaceAll(version, "+", "---"); len(validation.IsValidLabelValue(value)) == 0 {
+			labels[p.key("kubernetes-version")] = value
+		}
+	}
+	if class, _, _ := unstructured.NestedString(cluster.Object, "spec", "topology", "class"); class != "" {
+		labels[p.key("cluster-class")] = class
+	}
+
+	var domains []string
+	deployments, _, _ := unstructured.NestedSlice(cluster.Object, "spec", "topology", "workers", "machineDeployments")
+	for _, md := range deployments {
+		if m, ok := md.(map[string]interface{}); ok {
+			if domain, _, _ := unstructured.NestedString(m, "failureDomain"); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	failureDomains, _, _ := unstructured.NestedMap(cluster.Object, "status", "failureDomains")
+	for domain := range failureDomains {
+		if controlPlane, _, _ := unstructured.NestedBool(failureDomains, domain, "controlPlane"); controlPlane {
+			domains = append(domains, domain)
+		}
+	}
+	slices.Sort(domains)
+	domains = slices.Compact(domains)
+	if len(domains) > 0 {
+		annotations[p.key("failure-domains")] = strings.Join(domains, ",")
+	}
+	if len(domains) == 1 && len(validation.IsValidLabelValue(domains[0])) == 0 {
+		labels[p.key("failure-domain")] = domains[0]
+	}
+}
+
+// propagatedMetadata reads the source gvr namespace/name and returns its propagated labels and
+// annotations. A missing source propagates nothing.
+func propagatedMetadata(client dynamic.Interface, cfg *ControllerConfig, gvr schema.GroupVersionResource, namespace, name string) (labels, annotations map[string]string, err error) {
+	if cfg == nil || !cfg.Propagation.enabled() {
+		return nil, nil, nil
+	}
+	source, err := cachedGet(client, gvr, namespace, name)
+	if apierrors.IsNotFound(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get %s %s to propagate its metadata: %w", gvr.Resource, name, err)
+	}
+	labels, annotations = sourceMetadata(&cfg.Propagation, source)
+	return labels, annotations, nil
+}
+
+// propagationHandler requeues the CRs of controller that propagate the metadata of a source object
+// when it changes. owners returns their keys.
+func propagationHandler(controller *Controller, owners func(source *unstructured.Unstructured) []string) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldU, err := toUnstructured(oldObj)
+			if err != nil {
+				return
+			}
+			newU, err := toUnstructured(newObj)
+			if err != nil {
+				return
+			}
+			cfg := controller.config.get()
+			if cfg == nil {
+				return
+			}
+			oldLabels, oldAnnotations := sourceMetadata(&cfg.Propagation, oldU)
+			newLabels, newAnnotations := sourceMetadata(&cfg.Propagation, newU)
+			if maps.Equal(oldLabels, newLabels) && maps.Equal(oldAnnotations, newAnnotations) {
+				return
+			}
+			for _, key := range owners(newU) {
+				fmt.Printf("\n--- %s %s metadata changed. Queuing %s ---\n", newU.GetKind(), newU.GetName(), key)
+				controller.Queue.Add(key)
+			}
+		},
+	}
+}
+
+// clusterOwners returns the keys of the cached ArgoClusters registering the CAPI cluster source.
+func clusterOwners(resources *resourceCache) func(source *unstructured.Unstructured) []string {
+	return func(source *unstructured.Unstructured) []string {
+		var keys []string
+		for _, cr := range resources.list(argoClusterGVR, source.GetNamespace()) {
+			if name, _, _ := unstructured.NestedString(cr.Object, "spec", "clusterName"); name == source.GetName() {
+				keys = append(keys, fmt.Sprintf("%s/%s", cr.GetNamespace(), cr.GetName()))
+			}
+		}
+		return keys
+	}
+}
+
+// namespaceOwners returns the keys of the cached ArgoNamespaces in the namespace source.
+func namespaceOwners(resources *resourceCache) func(source *unstructured.Unstructured) []string {
+	return func(source *unstructured.Unstructured) []string {
+		var keys []string
+		for _, cr := range resources.list(argoNamespaceGVR, source.GetName()) {
+			keys = append(keys, fmt.Sprintf("%s/%s", cr.GetNamespace(), cr.GetName()))
+		}
+		return keys
+	}
+}
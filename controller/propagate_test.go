@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newCAPICluster(name string, labels map[string]string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.x-k8s.io/v1beta1",
+		"kind":       "Cluster",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+		},
+		"spec": map[string]interface{}{
+			"topology": map[string]interface{}{
+				"class":   "tanzukubernetescluster",
+				"version": "v1.29.4+vmware.3-fips.1",
+				"workers": map[string]interface{}{
+					"machineDeployments": []interface{}{
+						map[string]interface{}{"name": "np-1", "failureDomain": "zone-a"},
+					},
+				},
+			},
+		},
+	}}
+	u.SetLabels(labels)
+	return u
+}
+
+func newNamespace(name string, labels map[string]string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": name},
+	}}
+	u.SetLabels(labels)
+	return u
+}
+
+func TestPropagatedMetadata(t *testing.T) {
+	propagation := PropagationConfig{
+		Labels:      PropagationRules{Include: []string{""}, Exclude: []string{"cluster.x-k8s.io/", "kubernetes.io/"}},
+		Annotations: PropagationRules{Include: []string{"example.com/"}},
+		ClusterInfo: true,
+	}
+	cfg := &ControllerConfig{Propagation: propagation}
+
+	spec := map[string]interface{}{
+		"clusterName":   "wl",
+		"argoNamespace": "argocd",
+		"project":       "default",
+		"clusterLabels": map[string]interface{}{"argo-attach.field.vmware.com/env": "override"},
+	}
+	source := newCAPICluster("wl", map[string]string{
+		"env":                            "prod",
+		"example.com/team":               "a",
+		"cluster.x-k8s.io/cluster-name":  "wl",
+		"kubernetes.io/metadata.name":    "wl",
+		"run.tanzu.vmware.com/tkr":       "v1.29.4---vmware.3-fips.1",
+		"run.tanzu.vmware.com/dup":       "second",
+		"run.tanzu.vmware.com.other/dup": "first",
+	})
+	source.SetAnnotations(map[string]string{"example.com/owner": "platform", "other": "skipped"})
+	client := newFakeClient(newArgoCluster("wl", spec), newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"), source)
+	if err := applyArgoCluster(client, getObject(t, client, argoClusterGVR, "default", "wl"), cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret := getObject(t, client, secretGVR, "argocd", "wl-argo-cluster")
+	wantLabels := map[string]string{
+		"argocd.argoproj.io/secret-type":                  "cluster",
+		"argo-attach.field.vmware.com/env":                "override",
+		"argo-attach.field.vmware.com/team":               "a",
+		"argo-attach.field.vmware.com/tkr":                "v1.29.4---vmware.3-fips.1",
+		"argo-attach.field.vmware.com/dup":                "first",
+		"argo-attach.field.vmware.com/kubernetes-version": "v1.29.4---vmware.3-fips.1",
+		"argo-attach.field.vmware.com/cluster-class":      "tanzukubernetescluster",
+		"argo-attach.field.vmware.com/failure-domain":     "zone-a",
+	}
+	if got := secret.GetLabels(); len(got) != len(wantLabels) {
+		t.Errorf("labels = %v, want %v", got, wantLabels)
+	}
+	for k, v := range wantLabels {
+		if got := secret.GetLabels()[k]; got != v {
+			t.Errorf("label %s = %q, want %q", k, got, v)
+		}
+	}
+	annotations := secret.GetAnnotations()
+	if annotations["argo-attach.field.vmware.com/owner"] != "platform" || annotations["argo-attach.field.vmware.com/kubernetes-version"] != "v1.29.4+vmware.3-fips.1" || len(annotations) != 3 {
+		t.Errorf("unexpected annotations %v", annotations)
+	}
+
+	// an ArgoNamespace propagates the labels of its supervisor namespace
+	token := "c2EtdG9rZW4="
+	client = newFakeClient(
+		newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd", "project": "default"}),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": token}),
+		newNamespace("default", map[string]string{"env": "dev", "kubernetes.io/metadata.name": "default"}),
+	)
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	labels := getObject(t, client, secretGVR, "argocd", "supervisor-ns-default-argo-cluster").GetLabels()
+	if labels["argo-attach.field.vmware.com/env"] != "dev" || labels["argo-attach.field.vmware.com/metadata.name"] != "" {
+		t.Errorf("unexpected namespace labels %v", labels)
+	}
+}
+
+func TestPropagationHandler(t *testing.T) {
+	resources := newResourceCache(nil)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range []string{"a", "b"} {
+		cr := newArgoCluster(name, map[string]interface{}{"clusterName": name})
+		if err := indexer.Add(cr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resources.indexers[argoClusterGVR] = []cache.Indexer{indexer}
+
+	c := &Controller{
+		config: &configStore{current: &ControllerConfig{Propagation: PropagationConfig{Labels: PropagationRules{Include: []string{"env"}}}}},
+		Queue:  workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	handler := propagationHandler(c, clusterOwners(resources))
+
+	old := newCAPICluster("a", map[string]string{"env": "dev", "team": "a"})
+	ignored := newCAPICluster("a", map[string]string{"env": "dev", "team": "b"})
+	handler.OnUpdate(old, ignored)
+	if c.Queue.Len() != 0 {
+		t.Fatalf("a change to a key that isn't propagated queued %d keys", c.Queue.Len())
+	}
+	changed := newCAPICluster("a", map[string]string{"env": "prod", "team": "a"})
+	handler.OnUpdate(old, changed)
+	if key, _ := c.Queue.Get(); c.Queue.Len() != 0 || key != "default/a" {
+		t.Errorf("queued %v, want only default/a", key)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// Result tells the workqueue when to look at an object again after a reconcile.
+type Result struct {
+	// Requeue adds the object back to the queue with the rate limiter backoff.
+	Requeue bool
+	// RequeueAfter adds the object back after the delay, it takes precedence over Requeue.
+	RequeueAfter time.Duration
+	// Status is merged into the status the controller writes after the reconcile.
+	Status map[string]interface{}
+}
+
+// Reconciler implements a resource kind for the generic Controller, which owns the finalizer, the
+// status and the queue.
+type Reconciler interface {
+	// Provision creates or updates everything obj describes.
+	Provision(client dynamic.Interface, obj *unstructured.Unstructured, cfg *ControllerConfig) (Result, error)
+	// Cleanup removes what the garbage collector can't before the finalizer is removed.
+	Cleanup(client dynamic.Interface, obj *unstructured.Unstructured, cfg *ControllerConfig) (Result, error)
+}
+
+// ReconcilerFuncs is a Reconciler built from funcs, a nil func does nothing.
+type ReconcilerFuncs struct {
+	ProvisionFunc func(dynamic.Interface, *unstructured.Unstructured, *ControllerConfig) (Result, error)
+	CleanupFunc   func(dynamic.Interface, *unstructured.Unstructured, *ControllerConfig) (Result, error)
+}
+
+func (f ReconcilerFuncs) Provision(client dynamic.Interface, obj *unstructured.Unstructured, cfg *ControllerConfig) (Result, error) {
+	if f.ProvisionFunc == nil {
+		return Result{}, nil
+	}
+	return f.ProvisionFunc(client, obj, cfg)
+}
+
+func (f ReconcilerFuncs) Cleanup(client dynamic.Interface, obj *unstructured.Unstructured, cfg *ControllerConfig) (Result, error) {
+	if f.CleanupFunc == nil {
+		return Result{}, nil
+	}
+	return f.CleanupFunc(client, obj, cfg)
+}
+
+// waitingResult turns a waiting err into a result that requeues the object once the dependency may
+// be there and records the Waiting status, other errors are returned as they are.
+func waitingResult(err error) (Result, error) {
+	if kind, _ := classifyError(err); kind != errorWaiting {
+		return Result{}, err
+	}
+	return Result{RequeueAfter: requeueDelay(err), Status: updateGenericStatus(nil, false, err)}, nil
+}
+
+// argoClusterReconciler registers workload clusters, see applyArgoCluster.
+type argoClusterReconciler struct{}
+
+func (argoClusterReconciler) Provision(client dynamic.Interface, obj *unstructured.Unstructured, cfg *ControllerConfig) (Result, error) {
+	return waitingResult(applyArgoCluster(client, obj, cfg))
+}
+
+func (argoClusterReconciler) Cleanup(client dynamic.Interface, obj *unstructured.Unstructured, cfg *ControllerConfig) (Result, error) {
+	return waitingResult(deleteClusterCleanup(client, obj, cfg))
+}
+
+// argoNamespaceReconciler registers supervisor namespaces, see applyArgoNamespace.
+type argoNamespaceReconciler struct{}
+
+func (argoNamespaceReconciler) Provision(client dynamic.Interface, obj *unstructured.Unstructured, cfg *ControllerConfig) (Result, error) {
+	return waitingResult(applyArgoNamespace(client, obj, cfg))
+}
+
+func (argoNamespaceReconciler) Cleanup(client dynamic.Interface, obj *unstructured.Unstructured, cfg *ControllerConfig) (Result, error) {
+	return waitingResult(deleteNamespaceCleanup(client, obj, cfg))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// supervisorURL is the address a remote Argo CD uses to reach this supervisor for ArgoNamespace attachments.
+var supervisorURL string
+
+// newRemoteClient builds a client from a remote kubeconfig, tests replace it with a fake.
+var newRemoteClient = func(config *rest.Config) (dynamic.Interface, error) {
+	return dynamic.NewForConfig(config)
+}
+
+type remoteClient struct {
+	resourceVersion string
+	client          dynamic.Interface
+}
+
+// remoteClients caches a client per kubeconfig secret. A client is rebuilt when the secret changes.
+type remoteClients struct {
+	mu      sync.Mutex
+	clients map[string]remoteClient
+}
+
+var argoInstances = &remoteClients{clients: map[string]remoteClient{}}
+
+func (r *remoteClients) get(client dynamic.Interface, namespace string, ref *argov1.ArgoInstanceReference) (dynamic.Interface, error) {
+	secret, err := client.Resource(secretGVR).Namespace(namespace).Get(context.TODO(), ref.KubeconfigSecret, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, waitingError(ReasonSecretNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := namespace + "/" + ref.KubeconfigSecret + "/" + ref.Key
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cached, ok := r.clients[cacheKey]; ok && cached.resourceVersion == secret.GetResourceVersion() {
+		return cached.client, nil
+	}
+
+	key := ref.Key
+	if key == "" {
+		key = "value"
+	}
+	encoded, found, _ := unstructured.NestedString(secret.Object, "data", key)
+	if !found {
+		return nil, terminalError(ReasonInvalidKubeconfig, fmt.Errorf("%s does not exist in argo instance secret %s/%s", key, namespace, ref.KubeconfigSecret))
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, terminalError(ReasonInvalidKubeconfig, fmt.Errorf("failed to decode argo instance kubeconfig: %v", err))
+	}
+	config, err := clientcmd.RESTConfigFromKubeConfig(decoded)
+	if err != nil {
+		return nil, terminalError(ReasonInvalidKubeconfig, fmt.Errorf("failed to read argo instance kubeconfig: %v", err))
+	}
+	remote, err := newRemoteClient(config)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("created client for argo instance %s at %s", cacheKey, config.Host)
+	r.clients[cacheKey] = remoteClient{resourceVersion: secret.GetResourceVersion(), client: remote}
+	return remote, nil
+}
+
+// remoteStandIn is implemented by clients that keep what would be written to remote clusters and
+// argo apis to themselves. A render shows those objects in its own output instead of connecting.
+type remoteStandIn interface {
+	dynamic.Interface
+	standsInForRemotes()
+}
+
+// argoTarget returns the client and namespace the argo cluster secret is written to. Without an
+// ArgoInstance that is the local cluster, otherwise the remote cluster from the referenced kubeconfig.
+func argoTarget(client dynamic.Interface, namespace, argoNamespace string, ref *argov1.ArgoInstanceReference) (dynamic.Interface, string, error) {
+	if ref == nil {
+		return client, argoNamespace, nil
+	}
+	if ref.Namespace != "" {
+		argoNamespace = ref.Namespace
+	}
+	if standIn, ok := client.(remoteStandIn); ok {
+		return standIn, argoNamespace, nil
+	}
+	if ref.KubeconfigSecret == "" {
+		return nil, "", terminalError(ReasonInvalidSpec, fmt.Errorf("argoInstance needs either kubeconfigSecret or api"))
+	}
+	remote, err := argoInstances.get(client, namespace, ref)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to connect to argo instance %s: %w", ref.KubeconfigSecret, err)
+	}
+	return remote, argoNamespace, nil
+}
+
+// supervisorServer is the server address registered for an ArgoNamespace. A local Argo CD uses
+// the in-cluster service, a remote one needs the external supervisor address.
+func supervisorServer(namespace string, ref *argov1.ArgoInstanceReference) (string, error) {
+	server := "https://kubernetes.default.svc.cluster.local:443"
+	if ref != nil && ref.KubeconfigSecret != "" && supervisorURL == "" {
+		return "", terminalError(ReasonInvalidSpec, fmt.Errorf("--supervisor-url is required to attach a namespace to a remote argo instance"))
+	}
+	// an argo api instance may be local or remote, use the external address when there is one
+	if ref != nil && supervisorURL != "" {
+		server = strings.TrimSuffix(supervisorURL, "/")
+	}
+	return server + "/?context=" + namespace, nil
+}
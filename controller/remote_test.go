@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// useRemote routes remote argo instance clients to remote and counts how often one is built.
+func useRemote(t *testing.T, remote dynamic.Interface) *int {
+	t.Helper()
+	built := 0
+	origClient, origCache, origURL := newRemoteClient, argoInstances, supervisorURL
+	newRemoteClient = func(config *rest.Config) (dynamic.Interface, error) {
+		if config.Host != "https://argo.example.com:6443" {
+			t.Errorf("remote client built for %s", config.Host)
+		}
+		built++
+		return remote, nil
+	}
+	argoInstances = &remoteClients{clients: map[string]remoteClient{}}
+	t.Cleanup(func() {
+		newRemoteClient, argoInstances, supervisorURL = origClient, origCache, origURL
+	})
+	return &built
+}
+
+func TestArgoClusterRemoteInstance(t *testing.T) {
+	spec := map[string]interface{}{
+		"clusterName":   "wl",
+		"argoNamespace": "argocd",
+		"project":       "default",
+		"argoInstance":  map[string]interface{}{"kubeconfigSecret": "hub-kubeconfig", "namespace": "central-argo"},
+	}
+	local := newFakeClient(
+		newArgoCluster("wl", spec),
+		newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"),
+		newKubeconfigSecret(t, "default", "hub", "https://argo.example.com:6443"),
+	)
+	remote := newFakeClient()
+	built := useRemote(t, remote)
+	c := newTestClusterController(local, nil)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Reconcile(getObject(t, local, argoClusterGVR, "default", "wl")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if *built != 1 {
+		t.Errorf("remote client built %d times, want 1", *built)
+	}
+	if getObject(t, remote, secretGVR, "central-argo", "wl-argo-cluster") == nil {
+		t.Error("argo secret was not created on the remote cluster")
+	}
+	if getObject(t, local, secretGVR, "argocd", "wl-argo-cluster") != nil || getObject(t, local, secretGVR, "central-argo", "wl-argo-cluster") != nil {
+		t.Error("argo secret should not be created locally")
+	}
+
+	if err := local.Tracker().Update(argoClusterGVR, markDeleted(getObject(t, local, argoClusterGVR, "default", "wl")), "default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reconcile(getObject(t, local, argoClusterGVR, "default", "wl")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, remote, secretGVR, "central-argo", "wl-argo-cluster") != nil {
+		t.Error("argo secret was not removed from the remote cluster")
+	}
+}
+
+func TestArgoNamespaceRemoteInstance(t *testing.T) {
+	spec := map[string]interface{}{
+		"argoNamespace":  "argocd",
+		"project":        "default",
+		"serviceAccount": "deployer",
+		"argoInstance":   map[string]interface{}{"kubeconfigSecret": "hub-kubeconfig"},
+	}
+	local := newFakeClient(
+		newArgoNamespace("ns", spec),
+		newServiceAccount("default", "deployer"),
+		newSecret("default", "deployer-token", map[string]interface{}{"token": "dG9rZW4="}),
+		newKubeconfigSecret(t, "default", "hub", "https://argo.example.com:6443"),
+	)
+	remote := newFakeClient()
+	useRemote(t, remote)
+
+	err := applyArgoNamespace(local, getObject(t, local, argoNamespaceGVR, "default", "ns"), nil)
+	if err == nil || !strings.Contains(err.Error(), "--supervisor-url") {
+		t.Fatalf("expected supervisor url error, got %v", err)
+	}
+
+	supervisorURL = "https://supervisor.example.com:6443/"
+	if err := applyArgoNamespace(local, getObject(t, local, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret := getObject(t, remote, secretGVR, "argocd", "supervisor-ns-default-argo-cluster")
+	if secret == nil {
+		t.Fatal("argo secret was not created on the remote cluster")
+	}
+	server, _, _ := unstructured.NestedString(secret.Object, "stringData", "server")
+	if server != "https://supervisor.example.com:6443/?context=default" {
+		t.Errorf("server = %q", server)
+	}
+}
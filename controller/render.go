@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+	sigyaml "sigs.k8s.io/yaml"
+)
+
+const redacted = "REDACTED"
+
+// placeholderToken stands in for the service account token the token controller would populate.
+const placeholderToken = "<service-account-token>"
+
+// renderClient wraps a dynamic client so the provision funcs can run without changing the cluster.
+// Written objects are recorded in order and served back on later reads, deletions are recorded and
+// hidden from them. When offline, writes never reach the delegate, otherwise every write is sent
+// with DryRun: All. It also stands in for remote clusters and argo apis, see remoteStandIn.
+type renderClient struct {
+	delegate dynamic.Interface
+	offline  bool
+
+	mu      sync.Mutex
+	applied []*unstructured.Unstructured
+	deleted []string
+	// overlay holds the objects written so far, a nil object was deleted
+	overlay map[string]*unstructured.Unstructured
+}
+
+type renderResource struct {
+	dynamic.ResourceInterface
+	client    *renderClient
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+type renderNamespaceableResource struct {
+	renderResource
+}
+
+func newRenderClient(delegate dynamic.Interface, offline bool) *renderClient {
+	return &renderClient{
+		delegate: delegate,
+		offline:  offline,
+		overlay:  map[string]*unstructured.Unstructured{},
+	}
+}
+
+// offlineListKinds are the kinds the provision funcs list, an offline render has none of them.
+var offlineListKinds = map[schema.GroupVersionResource]string{
+	secretGVR:         "SecretList",
+	applicationGVR:    "ApplicationList",
+	applicationSetGVR: "ApplicationSetList",
+	appProjectGVR:     "AppProjectList",
+}
+
+// existingKinds are the kinds --existing accepts.
+var existingKinds = map[schema.GroupKind]schema.GroupVersionResource{
+	{Kind: "Secret"}:                            secretGVR,
+	{Kind: "ServiceAccount"}:                    saGVR,
+	{Kind: "ConfigMap"}:                         configMapGVR,
+	{Group: "argoproj.io", Kind: "AppProject"}:  appProjectGVR,
+	{Group: "argoproj.io", Kind: "ArgoCD"}:      argoCDGVR,
+	{Group: "argoproj.io", Kind: "Application"}: applicationGVR,
+}
+
+// newOfflineRenderClient renders without a cluster, only seeded objects exist.
+func newOfflineRenderClient() *renderClient {
+	return newRenderClient(fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), offlineListKinds), true)
+}
+
+func overlayKey(gvr schema.GroupVersionResource, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", gvr.String(), namespace, name)
+}
+
+func (c *renderClient) standsInForRemotes() {}
+
+// seed makes obj visible to reads without recording it as generated output.
+func (c *renderClient) seed(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.overlay[overlayKey(gvr, obj.GetNamespace(), obj.GetName())] = obj.DeepCopy()
+}
+
+// record returns the object as later reads see it.
+func (c *renderClient) record(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) *unstructured.Unstructured {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.applied = append(c.applied, obj.DeepCopy())
+
+	// nothing populates token secrets during a render, fill one in so token lookups succeed
+	stored := obj.DeepCopy()
+	if secretType, _, _ := unstructured.NestedString(stored.Object, "type"); secretType == "kubernetes.io/service-account-token" {
+		_ = unstructured.SetNestedField(stored.Object, base64.StdEncoding.EncodeToString([]byte(placeholderToken)), "data", "token")
+	}
+	c.overlay[overlayKey(gvr, obj.GetNamespace(), obj.GetName())] = stored
+	return stored.DeepCopy()
+}
+
+// recordDelete hides the object from later reads.
+func (c *renderClient) recordDelete(gvr schema.GroupVersionResource, namespace, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleted = append(c.deleted, fmt.Sprintf("%s %s", gvr.Resource, strings.TrimPrefix(namespace+"/"+name, "/")))
+	c.overlay[overlayKey(gvr, namespace, name)] = nil
+}
+
+func (c *renderClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &renderNamespaceableResource{renderResource{
+		ResourceInterface: c.delegate.Resource(gvr),
+		client:            c,
+		gvr:               gvr,
+	}}
+}
+
+func (r *renderNamespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &renderResource{
+		ResourceInterface: r.client.delegate.Resource(r.gvr).Namespace(namespace),
+		client:            r.client,
+		gvr:               r.gvr,
+		namespace:         namespace,
+	}
+}
+
+func (r *renderResource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.client.mu.Lock()
+	obj, ok := r.client.overlay[overlayKey(r.gvr, r.namespace, name)]
+	r.client.mu.Unlock()
+	if ok && obj == nil {
+		return nil, apierrors.NewNotFound(r.gvr.GroupResource(), name)
+	}
+	if ok {
+		return obj.DeepCopy(), nil
+	}
+	return r.ResourceInterface.Get(ctx, name, options, subresources...)
+}
+
+// List serves the written objects over the ones of the delegate.
+func (r *renderResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := r.ResourceInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	items := list.Items[:0]
+	for _, item := range list.Items {
+		if _, ok := r.client.overlay[overlayKey(r.gvr, item.GetNamespace(), item.GetName())]; !ok {
+			items = append(items, item)
+		}
+	}
+	for key, obj := range r.client.overlay {
+		if obj == nil || key != overlayKey(r.gvr, obj.GetNamespace(), obj.GetName()) {
+			continue
+		}
+		if (r.namespace == "" || obj.GetNamespace() == r.namespace) && selector.Matches(labels.Set(obj.GetLabels())) {
+			items = append(items, *obj.DeepCopy())
+		}
+	}
+	list.Items = items
+	return list, nil
+}
+
+func (r *renderResource) Apply(ctx context.Context, name string, obj *unstructured.Unstructured, options metav1.ApplyOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	result := obj.DeepCopy()
+	if !r.client.offline {
+		options.DryRun = []string{metav1.DryRunAll}
+		var err error
+		result, err = r.ResourceInterface.Apply(ctx, name, obj, options, subresources...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.recordWrite(result), nil
+}
+
+// ApplyStatus is dropped, a render has no object whose status could change.
+func (r *renderResource) ApplyStatus(ctx context.Context, name string, obj *unstructured.Unstructured, options metav1.ApplyOptions) (*unstructured.Unstructured, error) {
+	return obj, nil
+}
+
+// UpdateStatus is dropped like ApplyStatus.
+func (r *renderResource) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return obj, nil
+}
+
+func (r *renderResource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	result := obj.DeepCopy()
+	if r.client.offline {
+		if _, err := r.Get(ctx, obj.GetName(), metav1.GetOptions{}); err == nil {
+			return nil, apierrors.NewAlreadyExists(r.gvr.GroupResource(), obj.GetName())
+		}
+	} else {
+		options.DryRun = []string{metav1.DryRunAll}
+		var err error
+		if result, err = r.ResourceInterface.Create(ctx, obj, options, subresources...); err != nil {
+			return nil, err
+		}
+	}
+	return r.recordWrite(result), nil
+}
+
+func (r *renderResource) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	result := obj.DeepCopy()
+	if r.client.offline {
+		if _, err := r.Get(ctx, obj.GetName(), metav1.GetOptions{}); err != nil {
+			return nil, err
+		}
+	} else {
+		options.DryRun = []string{metav1.DryRunAll}
+		var err error
+		if result, err = r.ResourceInterface.Update(ctx, obj, options, subresources...); err != nil {
+			return nil, err
+		}
+	}
+	return r.recordWrite(result), nil
+}
+
+// Patch can't be computed offline and is refused, otherwise it is sent with DryRun: All.
+func (r *renderResource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if r.client.offline {
+		return nil, fmt.Errorf("patching %s %s is not supported in an offline render", r.gvr.Resource, name)
+	}
+	options.DryRun = []string{metav1.DryRunAll}
+	result, err := r.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+	if err != nil {
+		return nil, err
+	}
+	return r.recordWrite(result), nil
+}
+
+func (r *renderResource) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	if r.client.offline {
+		if _, err := r.Get(ctx, name, metav1.GetOptions{}); err != nil {
+			return err
+		}
+	} else {
+		options.DryRun = []string{metav1.DryRunAll}
+		if err := r.ResourceInterface.Delete(ctx, name, options, subresources...); err != nil {
+			return err
+		}
+	}
+	r.client.recordDelete(r.gvr, r.namespace, name)
+	return nil
+}
+
+func (r *renderResource) DeleteCollection(ctx context.Context, options metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	return fmt.Errorf("deleting a collection of %s is not supported while rendering", r.gvr.Resource)
+}
+
+// recordWrite records the result of a write the delegate didn't persist.
+func (r *renderResource) recordWrite(result *unstructured.Unstructured) *unstructured.Unstructured {
+	unstructured.RemoveNestedField(result.Object, "metadata", "managedFields")
+	result.SetNamespace(r.namespace)
+	return r.client.record(r.gvr, result)
+}
+
+// redactCredentials replaces tokens and private keys in rendered secrets.
+func redactCredentials(u *unstructured.Unstructured) {
+	if u.GetKind() != "Secret" {
+		return
+	}
+	if cfg, found, _ := unstructured.NestedString(u.Object, "stringData", "config"); found {
+		_ = unstructured.SetNestedField(u.Object, redactArgoConfig(cfg), "stringData", "config")
+	}
+	if cfg, found, _ := unstructured.NestedString(u.Object, "data", "config"); found {
+		if decoded, err := base64.StdEncoding.DecodeString(cfg); err == nil {
+			_ = unstructured.SetNestedField(u.Object, base64.StdEncoding.EncodeToString([]byte(redactArgoConfig(string(decoded)))), "data", "config")
+		}
+	}
+	if _, found, _ := unstructured.NestedString(u.Object, "data", "token"); found {
+		_ = unstructured.SetNestedField(u.Object, redacted, "data", "token")
+	}
+}
+
+func redactArgoConfig(cfg string) string {
+	argoConfig := &ArgoConfig{}
+	if err := json.Unmarshal([]byte(cfg), argoConfig); err != nil {
+		return redacted
+	}
+	if argoConfig.BearerToken != "" {
+		argoConfig.BearerToken = redacted
+	}
+	if argoConfig.TLSClientConfig != nil {
+		if argoConfig.TLSClientConfig.KeyData != "" {
+			argoConfig.TLSClientConfig.KeyData = redacted
+		}
+		if argoConfig.TLSClientConfig.CertData != "" {
+			argoConfig.TLSClientConfig.CertData = redacted
+		}
+	}
+	out, err := json.Marshal(argoConfig)
+	if err != nil {
+		return redacted
+	}
+	return string(out)
+}
+
+func readObject(path string) (*unstructured.Unstructured, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &u.Object); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return u, nil
+}
+
+// render runs the provision func for cr against client and writes the generated objects as YAML.
+func render(client *renderClient, cr *unstructured.Unstructured, cfg *ControllerConfig, showCredentials bool, out io.Writer) error {
+	var provision func(dynamic.Interface, interface{}, *ControllerConfig) error
+	switch cr.GetKind() {
+	case "ArgoCluster":
+		provision = applyArgoCluster
+	case "ArgoNamespace":
+		provision = applyArgoNamespace
+		if saName, _, _ := unstructured.NestedString(cr.Object, "spec", "serviceAccount"); saName != "" && client.offline {
+			// an existing service account can't be checked offline, assume it is there
+			client.seed(saGVR, &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ServiceAccount",
+				"metadata":   map[string]interface{}{"name": saName, "namespace": cr.GetNamespace()},
+			}})
+		}
+	default:
+		return fmt.Errorf("unsupported kind %q, expected ArgoCluster or ArgoNamespace", cr.GetKind())
+	}
+
+	if err := provision(client, cr, cfg); err != nil {
+		return err
+	}
+
+	for _, obj := range client.applied {
+		if !showCredentials {
+			redactCredentials(obj)
+		}
+		data, err := sigyaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "---\n%s", data)
+	}
+	for _, deleted := range client.deleted {
+		fmt.Fprintf(out, "---\n# deleted %s\n", deleted)
+	}
+	return nil
+}
+
+// runRender implements the render subcommand.
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	file := fs.String("f", "", "ArgoCluster or ArgoNamespace manifest to render")
+	kubeconfigSecret := fs.String("kubeconfig-secret", "", "manifest of the cluster kubeconfig secret, required for an offline ArgoCluster render")
+	serverDryRun := fs.Bool("server-dry-run", false, "send the generated objects to the cluster with DryRun: All instead of rendering offline")
+	showCredentials := fs.Bool("show-credentials", false, "print tokens and private keys instead of redacting them")
+	configFile := fs.String("config", "", "controller config file whose defaults and namespace rules are applied")
+	var existing StringSlice
+	fs.Var(&existing, "existing", "manifest of an object that exists already in an offline render, like the project of the CR (can be specified multiple times)")
+	_ = fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("-f is required")
+	}
+	cr, err := readObject(*file)
+	if err != nil {
+		return err
+	}
+	if cr.GetNamespace() == "" {
+		cr.SetNamespace("default")
+	}
+
+	var client *renderClient
+	if *serverDryRun {
+		config, err := buildConfig()
+		if err != nil {
+			return err
+		}
+		dynClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return err
+		}
+		client = newRenderClient(dynClient, false)
+	} else {
+		client = newOfflineRenderClient()
+	}
+
+	if *kubeconfigSecret != "" {
+		secret, err := readObject(*kubeconfigSecret)
+		if err != nil {
+			return err
+		}
+		if secret.GetNamespace() == "" {
+			secret.SetNamespace(cr.GetNamespace())
+		}
+		client.seed(secretGVR, secret)
+	} else if cr.GetKind() == "ArgoCluster" && !*serverDryRun {
+		return fmt.Errorf("--kubeconfig-secret is required to render an ArgoCluster offline")
+	}
+
+	for _, path := range existing {
+		obj, err := readObject(path)
+		if err != nil {
+			return err
+		}
+		gvk := obj.GroupVersionKind()
+		mapping, ok := existingKinds[gvk.GroupKind()]
+		if !ok {
+			return fmt.Errorf("%s: unsupported kind %s", path, gvk.Kind)
+		}
+		client.seed(mapping, obj)
+	}
+
+	var cfg *ControllerConfig
+	if *configFile != "" {
+		store, err := newConfigStore(defaultConfig())
+		if err != nil {
+			return err
+		}
+		if err := store.loadFile(*configFile); err != nil {
+			return err
+		}
+		cfg = store.get()
+	}
+
+	return render(client, cr, cfg, *showCredentials, os.Stdout)
+}
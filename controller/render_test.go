@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+func TestRenderOffline(t *testing.T) {
+	tests := []struct {
+		name            string
+		cr              func(t *testing.T, c *renderClient) *unstructured.Unstructured
+		showCredentials bool
+		wantKinds       []string
+		want            []string
+		notWant         []string
+	}{
+		{
+			name: "argocluster redacts client key",
+			cr: func(t *testing.T, c *renderClient) *unstructured.Unstructured {
+				c.seed(secretGVR, newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"))
+				return newArgoCluster("wl", map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default"})
+			},
+			wantKinds: []string{"Secret"},
+			want:      []string{"name: wl-argo-cluster", "server: https://10.0.0.1:6443", `"keyData":"REDACTED"`},
+			notWant:   []string{"a2V5"},
+		},
+		{
+			name: "argocluster with a name template",
+			cr: func(t *testing.T, c *renderClient) *unstructured.Unstructured {
+				c.seed(secretGVR, newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"))
+				return newArgoCluster("wl", map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default",
+					"secretNameTemplate": "{{ .Namespace }}-{{ .ClusterName }}"})
+			},
+			wantKinds: []string{"Secret"},
+			want:      []string{"name: default-wl"},
+			notWant:   []string{"name: wl-argo-cluster"},
+		},
+		{
+			name: "argocluster shows credentials",
+			cr: func(t *testing.T, c *renderClient) *unstructured.Unstructured {
+				c.seed(secretGVR, newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"))
+				return newArgoCluster("wl", map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default"})
+			},
+			showCredentials: true,
+			wantKinds:       []string{"Secret"},
+			want:            []string{`"keyData":"a2V5"`},
+		},
+		{
+			name: "argonamespace renders service account resources",
+			cr: func(t *testing.T, c *renderClient) *unstructured.Unstructured {
+				return newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd", "project": "default"})
+			},
+			wantKinds: []string{"ServiceAccount", "RoleBinding", "Secret", "Secret"},
+			want:      []string{"name: supervisor-ns-default-argo-cluster", `"bearerToken":"REDACTED"`},
+			notWant:   []string{placeholderToken},
+		},
+		{
+			name: "argonamespace with a project template",
+			cr: func(t *testing.T, c *renderClient) *unstructured.Unstructured {
+				return newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd",
+					"projectTemplate": map[string]interface{}{"nameTemplate": "tenant-{{ .Namespace }}"}})
+			},
+			wantKinds: []string{"ServiceAccount", "RoleBinding", "Secret", "AppProject", "Secret"},
+			want:      []string{"name: tenant-default", "project: tenant-default"},
+		},
+		{
+			name: "argonamespace renamed",
+			cr: func(t *testing.T, c *renderClient) *unstructured.Unstructured {
+				c.seed(secretGVR, newSecret("argocd", "supervisor-ns-default-argo-cluster", nil))
+				return newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd", "project": "default",
+					"secretNameTemplate": "{{ .Namespace }}-cluster"})
+			},
+			wantKinds: []string{"ServiceAccount", "RoleBinding", "Secret", "Secret"},
+			want:      []string{"name: default-cluster", "# deleted secrets argocd/supervisor-ns-default-argo-cluster"},
+		},
+		{
+			name: "argonamespace with impersonation",
+			cr: func(t *testing.T, c *renderClient) *unstructured.Unstructured {
+				c.seed(appProjectGVR, newArgoObject("argoproj.io/v1alpha1", "AppProject", "default", map[string]interface{}{}))
+				return newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd", "project": "default", "credentialMode": "Impersonation"})
+			},
+			wantKinds: []string{"ServiceAccount", "RoleBinding", "Secret", "AppProject"},
+			want:      []string{"server: " + inClusterServer, impersonationProjectAnnotation + ": default", "defaultServiceAccount: argo-attach-sa"},
+			notWant:   []string{"bearerToken"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newOfflineRenderClient()
+			cr := tt.cr(t, client)
+
+			out := &bytes.Buffer{}
+			if err := render(client, cr, nil, tt.showCredentials, out); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var kinds []string
+			for _, obj := range client.applied {
+				kinds = append(kinds, obj.GetKind())
+			}
+			if strings.Join(kinds, ",") != strings.Join(tt.wantKinds, ",") {
+				t.Errorf("rendered kinds = %v, want %v", kinds, tt.wantKinds)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(out.String(), w) {
+					t.Errorf("output missing %q:\n%s", w, out.String())
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(out.String(), w) {
+					t.Errorf("output should not contain %q:\n%s", w, out.String())
+				}
+			}
+		})
+	}
+}
+
+// dryRunServer answers dry run writes like the api server without changing the delegate and
+// records every write that isn't one.
+type dryRunServer struct {
+	dynamic.ResourceInterface
+	wet *[]string
+}
+
+type dryRunNamespaceable struct {
+	dynamic.NamespaceableResourceInterface
+	wet *[]string
+}
+
+type dryRunClient struct {
+	dynamic.Interface
+	wet []string
+}
+
+func (c *dryRunClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return dryRunNamespaceable{c.Interface.Resource(gvr), &c.wet}
+}
+
+func (r dryRunNamespaceable) Namespace(namespace string) dynamic.ResourceInterface {
+	return dryRunServer{r.NamespaceableResourceInterface.Namespace(namespace), r.wet}
+}
+
+func (r dryRunServer) check(verb string, dryRun []string) {
+	if len(dryRun) == 0 {
+		*r.wet = append(*r.wet, verb)
+	}
+}
+
+func (r dryRunServer) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.check("update", options.DryRun)
+	return obj, nil
+}
+
+func (r dryRunServer) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	r.check("delete", options.DryRun)
+	return nil
+}
+
+func (r dryRunServer) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.check("patch", options.DryRun)
+	return r.Get(ctx, name, metav1.GetOptions{})
+}
+
+func TestRenderServerDryRun(t *testing.T) {
+	delegate := &dryRunClient{Interface: newFakeClient(newArgoObject("argoproj.io/v1alpha1", "AppProject", "team", map[string]interface{}{}))}
+	client := newRenderClient(delegate, false)
+	projects := client.Resource(appProjectGVR).Namespace("argocd")
+
+	project, err := projects.Get(context.TODO(), "team", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	project.SetLabels(map[string]string{"changed": "true"})
+	if _, err := projects.Update(context.TODO(), project, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected update error: %v", err)
+	}
+	if got, _ := projects.Get(context.TODO(), "team", metav1.GetOptions{}); got.GetLabels()["changed"] != "true" {
+		t.Errorf("a read after the update doesn't see it")
+	}
+	if _, err := projects.Patch(context.TODO(), "team", types.MergePatchType, []byte("{}"), metav1.PatchOptions{}); err != nil {
+		t.Fatalf("unexpected patch error: %v", err)
+	}
+	if err := projects.Delete(context.TODO(), "team", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unexpected delete error: %v", err)
+	}
+	if _, err := projects.Get(context.TODO(), "team", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("a read after the delete found the project: %v", err)
+	}
+	if list, _ := projects.List(context.TODO(), metav1.ListOptions{}); len(list.Items) != 0 {
+		t.Errorf("a list after the delete returned %d projects", len(list.Items))
+	}
+	if len(delegate.wet) > 0 {
+		t.Errorf("writes sent without dry run: %v", delegate.wet)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != "appprojects argocd/team" {
+		t.Errorf("deleted = %v", client.deleted)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var argoCDGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1beta1", Resource: "argocds"}
+var appProjectGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "appprojects"}
+var roleGVR = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}
+
+const (
+	// cmdParamsConfigMap holds the command line parameters of the Argo CD components.
+	cmdParamsConfigMap = "argocd-cmd-params-cm"
+	defaultArgoCDName  = "argocd"
+	// sourceNamespaceRole is the Role and RoleBinding letting the Argo CD controllers manage the
+	// Applications in an attached namespace.
+	sourceNamespaceRole = "argo-attach-source-namespace"
+	// sourceNamespacesAnnotation lists the namespaces the controller added to an Argo CD object,
+	// namespaces that were there before are never removed.
+	sourceNamespacesAnnotation = "field.vmware.com/argo-attach-source-namespaces"
+	// sourceObjectsAnnotation on the Role lists the Argo CD objects the namespace was added to, so they
+	// can be reverted after the spec changed.
+	sourceObjectsAnnotation = "field.vmware.com/argo-attach-source-objects"
+)
+
+// sourceObject is an Argo CD object holding a list of application source namespaces.
+type sourceObject struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+func (o sourceObject) String() string {
+	return fmt.Sprintf("%s/%s/%s", o.namespace, o.gvr.Resource, o.name)
+}
+
+func parseSourceObjects(value string) []sourceObject {
+	var objects []sourceObject
+	for _, s := range splitList(value) {
+		parts := strings.Split(s, "/")
+		if len(parts) != 3 {
+			continue
+		}
+		for _, gvr := range []schema.GroupVersionResource{configMapGVR, argoCDGVR, appProjectGVR} {
+			if gvr.Resource == parts[1] {
+				objects = append(objects, sourceObject{gvr: gvr, namespace: parts[0], name: parts[2]})
+			}
+		}
+	}
+	return objects
+}
+
+func formatSourceObjects(objects []sourceObject) string {
+	var values []string
+	for _, o := range objects {
+		values = append(values, o.String())
+	}
+	return strings.Join(values, ",")
+}
+
+// splitList splits a comma separated list, the format of application.namespaces.
+func splitList(value string) []string {
+	var out []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// sourceNamespaces returns the source namespaces of obj, the cmd params ConfigMap keeps them in
+// application.namespaces, the ArgoCD and AppProject in spec.sourceNamespaces.
+func (o sourceObject) sourceNamespaces(obj *unstructured.Unstructured) []string {
+	if o.gvr == configMapGVR {
+		value, _, _ := unstructured.NestedString(obj.Object, "data", "application.namespaces")
+		return splitList(value)
+	}
+	namespaces, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "sourceNamespaces")
+	return namespaces
+}
+
+func (o sourceObject) setSourceNamespaces(obj *unstructured.Unstructured, namespaces []string) {
+	if o.gvr == configMapGVR {
+		_ = unstructured.SetNestedField(obj.Object, strings.Join(namespaces, ","), "data", "application.namespaces")
+		return
+	}
+	if len(namespaces) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "spec", "sourceNamespaces")
+		return
+	}
+	_ = unstructured.SetNestedStringSlice(obj.Object, namespaces, "spec", "sourceNamespaces")
+}
+
+// desiredSourceObjects returns the Argo CD objects the namespace of argoNs has to be added to.
+func desiredSourceObjects(spec *argov1.ArgoNamespaceSpec) ([]sourceObject, error) {
+	if spec.SourceNamespace == nil {
+		return nil, nil
+	}
+	if spec.ArgoInstance != nil {
+		return nil, terminalError(ReasonInvalidSpec, fmt.Errorf("sourceNamespace is only supported for an argocd in the same cluster"))
+	}
+	instance := sourceObject{gvr: configMapGVR, namespace: spec.ArgoNamespace, name: cmdParamsConfigMap}
+	switch spec.SourceNamespace.Mode {
+	case "", argov1.SourceNamespaceModeConfigMap:
+	case argov1.SourceNamespaceModeArgoCD:
+		instance = sourceObject{gvr: argoCDGVR, namespace: spec.ArgoNamespace, name: argoCDName(spec.SourceNamespace)}
+	default:
+		return nil, terminalError(ReasonInvalidSpec, fmt.Errorf("unknown sourceNamespace mode %q", spec.SourceNamespace.Mode))
+	}
+	return []sourceObject{instance, {gvr: appProjectGVR, namespace: spec.ArgoNamespace, name: spec.Project}}, nil
+}
+
+func argoCDName(s *argov1.SourceNamespace) string {
+	if s.ArgoCD == "" {
+		return defaultArgoCDName
+	}
+	return s.ArgoCD
+}
+
+// updateSourceNamespaces adds namespace to the source namespaces of o, or removes it again if the
+// controller added it. A missing object has nothing to remove.
+func updateSourceNamespaces(client dynamic.Interface, o sourceObject, namespace string, add bool) error {
+	obj, err := client.Resource(o.gvr).Namespace(o.namespace).Get(context.TODO(), o.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if !add {
+			return nil
+		}
+		return waitingError(ReasonArgoConfigNotFound, fmt.Errorf("%s %s not found in %s, it is needed to use %s as a source namespace", o.gvr.Resource, o.name, o.namespace, namespace))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get %s %s/%s: %w", o.gvr.Resource, o.namespace, o.name, err)
+	}
+	namespaces := o.sourceNamespaces(obj)
+	tracked := splitList(obj.GetAnnotations()[sourceNamespacesAnnotation])
+	switch {
+	case add && slices.Contains(namespaces, namespace):
+		return nil
+	case add:
+		namespaces = append(namespaces, namespace)
+		tracked = append(tracked, namespace)
+	case !slices.Contains(tracked, namespace):
+		return nil
+	default:
+		namespaces = removeString(namespaces, namespace)
+		tracked = removeString(tracked, namespace)
+	}
+
+	o.setSourceNamespaces(obj, namespaces)
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[sourceNamespacesAnnotation] = strings.Join(tracked, ",")
+	if len(tracked) == 0 {
+		delete(annotations, sourceNamespacesAnnotation)
+	}
+	obj.SetAnnotations(annotations)
+	if _, err := client.Resource(o.gvr).Namespace(o.namespace).Update(context.TODO(), obj, metav1.UpdateOptions{FieldManager: "argo-attach-controller"}); err != nil {
+		return fmt.Errorf("unable to update the source namespaces of %s %s/%s: %w", o.gvr.Resource, o.namespace, o.name, err)
+	}
+	if add {
+		log.Printf("added source namespace %s to %s %s/%s", namespace, o.gvr.Resource, o.namespace, o.name)
+	} else {
+		log.Printf("removed source namespace %s from %s %s/%s", namespace, o.gvr.Resource, o.namespace, o.name)
+	}
+	if o.gvr == configMapGVR {
+		log.Printf("the argocd server and application controller in %s have to be restarted to pick up %s", o.namespace, cmdParamsConfigMap)
+	}
+	return nil
+}
+
+// recordedSourceObjects reads the objects the namespace was added to from the Role, nil without one.
+func recordedSourceObjects(client dynamic.Interface, namespace string) ([]sourceObject, bool, error) {
+	role, err := client.Resource(roleGVR).Namespace(namespace).Get(context.TODO(), sourceNamespaceRole, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to get role %s: %w", sourceNamespaceRole, err)
+	}
+	return parseSourceObjects(role.GetAnnotations()[sourceObjectsAnnotation]), true, nil
+}
+
+// applySourceNamespace makes the namespace of argoNs an application source namespace of its Argo CD,
+// or reverts it once spec.sourceNamespace is removed.
+func applySourceNamespace(client dynamic.Interface, argoNs *argov1.ArgoNamespace) error {
+	desired, err := desiredSourceObjects(argoNs.Spec)
+	if err != nil {
+		return err
+	}
+	namespace := argoNs.Namespace
+	recorded, exists, err := recordedSourceObjects(client, namespace)
+	if err != nil {
+		return err
+	}
+	var stale []sourceObject
+	for _, o := range recorded {
+		if !slices.Contains(desired, o) {
+			stale = append(stale, o)
+		}
+	}
+
+	if desired != nil {
+		// record the objects before touching them, so a failed reconcile can still revert them
+		if err := applySourceNamespaceRBAC(client, argoNs, append(slices.Clone(desired), stale...)); err != nil {
+			return err
+		}
+		for _, o := range desired {
+			if err := updateSourceNamespaces(client, o, namespace, true); err != nil {
+				return err
+			}
+		}
+	}
+	for _, o := range stale {
+		if err := updateSourceNamespaces(client, o, namespace, false); err != nil {
+			return err
+		}
+	}
+	switch {
+	case desired != nil && len(stale) > 0:
+		return applySourceNamespaceRBAC(client, argoNs, desired)
+	case desired == nil && exists:
+		return deleteSourceNamespaceRBAC(client, namespace)
+	}
+	return nil
+}
+
+// revertSourceNamespace removes the namespace from every Argo CD object it was added to. The RBAC is
+// owned by the CR and left to the garbage collector.
+func revertSourceNamespace(client dynamic.Interface, namespace string) error {
+	recorded, _, err := recordedSourceObjects(client, namespace)
+	if err != nil {
+		return err
+	}
+	for _, o := range recorded {
+		if err := updateSourceNamespaces(client, o, namespace, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceNamespaceSubjects are the service accounts of the Argo CD controllers that reconcile
+// Applications, the operator prefixes them with the name of the ArgoCD.
+func sourceNamespaceSubjects(spec *argov1.ArgoNamespaceSpec) []interface{} {
+	prefix := "argocd"
+	if spec.SourceNamespace.Mode == argov1.SourceNamespaceModeArgoCD {
+		prefix = argoCDName(spec.SourceNamespace) + "-argocd"
+	}
+	var subjects []interface{}
+	for _, component := range []string{"application-controller", "server"} {
+		subjects = append(subjects, map[string]interface{}{
+			"kind":      "ServiceAccount",
+			"name":      fmt.Sprintf("%s-%s", prefix, component),
+			"namespace": spec.ArgoNamespace,
+		})
+	}
+	return subjects
+}
+
+// applySourceNamespaceRBAC lets the Argo CD controllers manage Applications in the namespace of
+// argoNs. The Role records objects, the Argo CD objects the namespace is added to.
+func applySourceNamespaceRBAC(client dynamic.Interface, argoNs *argov1.ArgoNamespace, objects []sourceObject) error {
+	namespace := argoNs.Namespace
+	owner := controllerRef(argoNs.TypeMeta, argoNs.ObjectMeta)
+	for _, gvr := range []schema.GroupVersionResource{roleGVR, rbGVR} {
+		if err := checkAdoption(client, gvr, namespace, sourceNamespaceRole, owner); err != nil {
+			return err
+		}
+	}
+	role := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "Role",
+		"metadata": map[string]interface{}{
+			"name":        sourceNamespaceRole,
+			"namespace":   namespace,
+			"annotations": map[string]interface{}{sourceObjectsAnnotation: formatSourceObjects(objects)},
+		},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"apiGroups": []interface{}{"argoproj.io"},
+				"resources": []interface{}{"applications"},
+				"verbs":     []interface{}{"create", "get", "list", "watch", "update", "patch", "delete"},
+			},
+			map[string]interface{}{
+				"apiGroups": []interface{}{""},
+				"resources": []interface{}{"events"},
+				"verbs":     []interface{}{"create", "list"},
+			},
+		},
+	}}
+	role.SetOwnerReferences([]metav1.OwnerReference{owner})
+	if _, err := client.Resource(roleGVR).Namespace(namespace).Apply(context.TODO(), sourceNamespaceRole, role, metav1.ApplyOptions{FieldManager: "argo-attach-controller"}); err != nil {
+		return fmt.Errorf("unable to create or update role %s: %w", sourceNamespaceRole, err)
+	}
+
+	rb := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "RoleBinding",
+		"metadata": map[string]interface{}{
+			"name":      sourceNamespaceRole,
+			"namespace": namespace,
+		},
+		"roleRef": map[string]interface{}{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     "Role",
+			"name":     sourceNamespaceRole,
+		},
+		"subjects": sourceNamespaceSubjects(argoNs.Spec),
+	}}
+	rb.SetOwnerReferences([]metav1.OwnerReference{owner})
+	if _, err := client.Resource(rbGVR).Namespace(namespace).Apply(context.TODO(), sourceNamespaceRole, rb, metav1.ApplyOptions{FieldManager: "argo-attach-controller"}); err != nil {
+		return fmt.Errorf("unable to create or update rolebinding %s: %w", sourceNamespaceRole, err)
+	}
+	return nil
+}
+
+func deleteSourceNamespaceRBAC(client dynamic.Interface, namespace string) error {
+	for _, gvr := range []schema.GroupVersionResource{rbGVR, roleGVR} {
+		err := client.Resource(gvr).Namespace(namespace).Delete(context.TODO(), sourceNamespaceRole, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete %s %s: %w", gvr.Resource, sourceNamespaceRole, err)
+		}
+	}
+	log.Printf("removed source namespace %s", namespace)
+	return nil
+}
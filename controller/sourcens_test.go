@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newArgoObject(apiVersion, kind, name string, content map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: content}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace("argocd")
+	u.SetName(name)
+	return u
+}
+
+func TestSourceNamespaceConfigMap(t *testing.T) {
+	spec := map[string]interface{}{
+		"argoNamespace":   "argocd",
+		"project":         "team",
+		"sourceNamespace": map[string]interface{}{},
+	}
+	objects := []runtime.Object{
+		newArgoNamespace("ns", spec),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": "c2EtdG9rZW4="}),
+		newArgoObject("v1", "ConfigMap", cmdParamsConfigMap, map[string]interface{}{
+			"data": map[string]interface{}{"application.namespaces": "legacy"},
+		}),
+		// the project already allowed the namespace, it has to keep it after the revert
+		newArgoObject("argoproj.io/v1alpha1", "AppProject", "team", map[string]interface{}{
+			"spec": map[string]interface{}{"sourceNamespaces": []interface{}{"default"}},
+		}),
+	}
+	client := newFakeClient(objects...)
+
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cm := getObject(t, client, configMapGVR, "argocd", cmdParamsConfigMap)
+	if got, _, _ := unstructured.NestedString(cm.Object, "data", "application.namespaces"); got != "legacy,default" {
+		t.Errorf("application.namespaces = %q, want legacy,default", got)
+	}
+	if got := cm.GetAnnotations()[sourceNamespacesAnnotation]; got != "default" {
+		t.Errorf("tracked namespaces = %q, want default", got)
+	}
+	if _, ok := getObject(t, client, appProjectGVR, "argocd", "team").GetAnnotations()[sourceNamespacesAnnotation]; ok {
+		t.Errorf("a namespace the project already had was tracked")
+	}
+	rb := getObject(t, client, rbGVR, "default", sourceNamespaceRole)
+	if rb == nil || getObject(t, client, roleGVR, "default", sourceNamespaceRole) == nil {
+		t.Fatalf("role and rolebinding for the argo controllers were not created")
+	}
+	subjects, _, _ := unstructured.NestedSlice(rb.Object, "subjects")
+	if len(subjects) != 2 || subjects[0].(map[string]interface{})["name"] != "argocd-application-controller" {
+		t.Errorf("unexpected subjects %v", subjects)
+	}
+
+	// removing the opt-in reverts only what was added
+	cr := getObject(t, client, argoNamespaceGVR, "default", "ns")
+	unstructured.RemoveNestedField(cr.Object, "spec", "sourceNamespace")
+	if _, err := client.Resource(argoNamespaceGVR).Namespace("default").Update(context.TODO(), cr, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cm = getObject(t, client, configMapGVR, "argocd", cmdParamsConfigMap)
+	if got, _, _ := unstructured.NestedString(cm.Object, "data", "application.namespaces"); got != "legacy" {
+		t.Errorf("application.namespaces = %q after the revert, want legacy", got)
+	}
+	if _, ok := cm.GetAnnotations()[sourceNamespacesAnnotation]; ok {
+		t.Errorf("tracking annotation was left behind")
+	}
+	project := getObject(t, client, appProjectGVR, "argocd", "team")
+	if got, _, _ := unstructured.NestedStringSlice(project.Object, "spec", "sourceNamespaces"); len(got) != 1 || got[0] != "default" {
+		t.Errorf("project source namespaces = %v, want the namespace it already had", got)
+	}
+	if getObject(t, client, roleGVR, "default", sourceNamespaceRole) != nil {
+		t.Errorf("role was not deleted after the opt-in was removed")
+	}
+}
+
+func TestSourceNamespaceArgoCD(t *testing.T) {
+	spec := map[string]interface{}{
+		"argoNamespace":   "argocd",
+		"project":         "team",
+		"sourceNamespace": map[string]interface{}{"mode": "ArgoCD", "argoCD": "gitops"},
+	}
+	objects := []runtime.Object{
+		newArgoNamespace("ns", spec, argoNamespaceFinalizer),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": "c2EtdG9rZW4="}),
+		newArgoObject("argoproj.io/v1beta1", "ArgoCD", "gitops", map[string]interface{}{}),
+	}
+	client := newFakeClient(objects...)
+
+	err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil)
+	if kind, reason := classifyError(err); kind != errorWaiting || reason != ReasonArgoConfigNotFound {
+		t.Fatalf("error = %v, want waiting for the missing project", err)
+	}
+	if err := client.Tracker().Add(newArgoObject("argoproj.io/v1alpha1", "AppProject", "team", map[string]interface{}{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	argoObjects := []sourceObject{{argoCDGVR, "argocd", "gitops"}, {appProjectGVR, "argocd", "team"}}
+	for _, o := range argoObjects {
+		if got := o.sourceNamespaces(getObject(t, client, o.gvr, o.namespace, o.name)); len(got) != 1 || got[0] != "default" {
+			t.Errorf("%s source namespaces = %v, want [default]", o.gvr.Resource, got)
+		}
+	}
+	rb := getObject(t, client, rbGVR, "default", sourceNamespaceRole)
+	if subjects, _, _ := unstructured.NestedSlice(rb.Object, "subjects"); subjects[1].(map[string]interface{})["name"] != "gitops-argocd-server" {
+		t.Errorf("unexpected subjects %v", subjects)
+	}
+
+	if err := deleteNamespaceCleanup(client, markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns")), nil); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	for _, o := range argoObjects {
+		if got := o.sourceNamespaces(getObject(t, client, o.gvr, o.namespace, o.name)); len(got) != 0 {
+			t.Errorf("%s still has source namespaces %v after the delete", o.gvr.Resource, got)
+		}
+	}
+}
+
+func TestSourceNamespaceDetachPolicy(t *testing.T) {
+	spec := map[string]interface{}{
+		"argoNamespace":   "argocd",
+		"project":         "team",
+		"sourceNamespace": map[string]interface{}{},
+		"detachPolicy":    "Block",
+	}
+	objects := []runtime.Object{
+		newArgoNamespace("ns", spec, argoNamespaceFinalizer),
+		newSecret("default", "argo-attach-sa-token", map[string]interface{}{"token": "c2EtdG9rZW4="}),
+		newArgoObject("v1", "ConfigMap", cmdParamsConfigMap, map[string]interface{}{}),
+		newArgoObject("argoproj.io/v1alpha1", "AppProject", "team", map[string]interface{}{}),
+	}
+	client := newFakeClient(objects...)
+	if err := applyArgoNamespace(client, getObject(t, client, argoNamespaceGVR, "default", "ns"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// an application the tenant created in its own namespace
+	secret := getObject(t, client, secretGVR, "argocd", "supervisor-ns-default-argo-cluster")
+	server, _, _ := unstructured.NestedString(secret.Object, "stringData", "server")
+	app := newApplication("Application", "web", map[string]interface{}{"server": server, "namespace": "default"})
+	app.SetNamespace("default")
+	_ = unstructured.SetNestedField(app.Object, "team", "spec", "project")
+	if err := client.Tracker().Add(app); err != nil {
+		t.Fatal(err)
+	}
+
+	err := deleteNamespaceCleanup(client, markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns")), nil)
+	if kind, reason := classifyError(err); kind != errorWaiting || reason != ReasonDetachBlocked || !strings.Contains(err.Error(), "application default/web") {
+		t.Fatalf("error = %v, want the detach blocked by default/web", err)
+	}
+	cm := getObject(t, client, configMapGVR, "argocd", cmdParamsConfigMap)
+	if got, _, _ := unstructured.NestedString(cm.Object, "data", "application.namespaces"); got != "default" {
+		t.Errorf("application.namespaces = %q while the detach is blocked, want default", got)
+	}
+
+	if err := client.Tracker().Delete(applicationGVR, "default", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteNamespaceCleanup(client, markDeleted(getObject(t, client, argoNamespaceGVR, "default", "ns")), nil); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	cm = getObject(t, client, configMapGVR, "argocd", cmdParamsConfigMap)
+	if got, _, _ := unstructured.NestedString(cm.Object, "data", "application.namespaces"); got != "" {
+		t.Errorf("application.namespaces = %q after the detach, want it reverted", got)
+	}
+}
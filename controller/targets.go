@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"reflect"
+	"strconv"
+	"strings"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	argov2 "github.com/warroyo/argocd-attach-service/api/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// TargetStatusFieldManager owns status.targets so the generic status apply leaves it alone.
+const TargetStatusFieldManager = "target-status-controller"
+
+// workloadSANamespace is where service accounts for the ServiceAccount credential mode are created.
+const workloadSANamespace = "kube-system"
+
+var crbGVR = schema.GroupVersionResource{
+	Group:    "rbac.authorization.k8s.io",
+	Version:  "v1",
+	Resource: "clusterrolebindings",
+}
+
+// clusterTargets resolves the targets of an ArgoCluster. A spec without targets is a single target
+// named default, otherwise every target inherits the spec fields it leaves empty.
+func clusterTargets(spec *argov1.ArgoClusterSpec) ([]argov1.ArgoTarget, error) {
+	base := argov1.ArgoTarget{
+		Name:               "default",
+		ArgoNamespace:      spec.ArgoNamespace,
+		ArgoInstance:       spec.ArgoInstance,
+		Project:            spec.Project,
+		ClusterLabels:      spec.ClusterLabels,
+		ClusterAnnotations: spec.ClusterAnnotations,
+		ClusterResources:   spec.ClusterResources,
+		Namespaces:         spec.Namespaces,
+		CredentialMode:     spec.CredentialMode,
+	}
+	targets := []argov1.ArgoTarget{base}
+	if len(spec.Targets) > 0 {
+		targets = nil
+		for _, t := range spec.Targets {
+			target := *t.DeepCopy()
+			if target.ArgoNamespace == "" {
+				target.ArgoNamespace = base.ArgoNamespace
+			}
+			if target.ArgoInstance == nil {
+				target.ArgoInstance = base.ArgoInstance
+			}
+			if target.Project == "" {
+				target.Project = base.Project
+			}
+			if target.ClusterResources == nil {
+				target.ClusterResources = base.ClusterResources
+			}
+			if len(target.Namespaces) == 0 {
+				target.Namespaces = base.Namespaces
+			}
+			if target.CredentialMode == "" {
+				target.CredentialMode = base.CredentialMode
+			}
+			target.ClusterLabels = mergeMaps(base.ClusterLabels, t.ClusterLabels)
+			target.ClusterAnnotations = mergeMaps(base.ClusterAnnotations, t.ClusterAnnotations)
+			targets = append(targets, target)
+		}
+	}
+
+	for i, target := range targets {
+		if target.CredentialMode == "" {
+			targets[i].CredentialMode = argov1.CredentialModeClientCertificate
+		}
+		if err := validateTarget(target, targets[:i]); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// clusterDefaults fills in the spec fields left empty from the config defaults.
+func clusterDefaults(spec *argov1.ArgoClusterSpec, cfg *ControllerConfig) {
+	if cfg == nil {
+		return
+	}
+	if spec.ArgoNamespace == "" {
+		spec.ArgoNamespace = cfg.Defaults.ArgoNamespace
+	}
+	if spec.Project == "" {
+		spec.Project = cfg.Defaults.Project
+	}
+	if spec.DeletionPolicy == "" {
+		spec.DeletionPolicy = cfg.Defaults.DeletionPolicy
+	}
+	if spec.NameTemplate == "" {
+		spec.NameTemplate = cfg.Defaults.NameTemplate
+	}
+	if spec.SecretNameTemplate == "" {
+		spec.SecretNameTemplate = cfg.Defaults.SecretNameTemplate
+	}
+	spec.ClusterLabels = mergeMaps(cfg.Defaults.ClusterLabels, spec.ClusterLabels)
+}
+
+func namespaceDefaults(spec *argov1.ArgoNamespaceSpec, cfg *ControllerConfig) {
+	if cfg == nil {
+		return
+	}
+	if spec.ArgoNamespace == "" {
+		spec.ArgoNamespace = cfg.Defaults.ArgoNamespace
+	}
+	if spec.Project == "" {
+		spec.Project = cfg.Defaults.Project
+	}
+	if spec.DeletionPolicy == "" {
+		spec.DeletionPolicy = cfg.Defaults.DeletionPolicy
+	}
+	if spec.NameTemplate == "" {
+		spec.NameTemplate = cfg.Defaults.NameTemplate
+	}
+	if spec.SecretNameTemplate == "" {
+		spec.SecretNameTemplate = cfg.Defaults.SecretNameTemplate
+	}
+	spec.ClusterLabels = mergeMaps(cfg.Defaults.ClusterLabels, spec.ClusterLabels)
+}
+
+// checkInstanceFeatures returns a terminal error if the argo instance needs a disabled feature.
+func checkInstanceFeatures(ref *argov1.ArgoInstanceReference, cfg *ControllerConfig) error {
+	switch {
+	case ref == nil:
+	case ref.API != nil && !cfg.featureEnabled(FeatureArgoAPI):
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("the %s feature is disabled", FeatureArgoAPI))
+	case ref.API == nil && !cfg.featureEnabled(FeatureRemoteInstances):
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("the %s feature is disabled", FeatureRemoteInstances))
+	}
+	return nil
+}
+
+func validateTarget(target argov1.ArgoTarget, previous []argov1.ArgoTarget) error {
+	if target.Name == "" {
+		return fmt.Errorf("every target needs a name")
+	}
+	usesAPI := target.ArgoInstance != nil && target.ArgoInstance.API != nil
+	if target.ArgoNamespace == "" && !usesAPI {
+		return fmt.Errorf("target %s has no argoNamespace", target.Name)
+	}
+	if target.Project == "" {
+		return fmt.Errorf("target %s has no project", target.Name)
+	}
+	if target.ArgoInstance != nil && target.ArgoInstance.KubeconfigSecret == "" && target.ArgoInstance.API == nil {
+		return fmt.Errorf("target %s has an argoInstance without kubeconfigSecret or api", target.Name)
+	}
+	switch target.CredentialMode {
+	case "", argov1.CredentialModeClientCertificate, argov1.CredentialModeServiceAccount:
+	default:
+		return fmt.Errorf("target %s has unknown credentialMode %s", target.Name, target.CredentialMode)
+	}
+	for _, p := range previous {
+		if p.Name == target.Name {
+			return fmt.Errorf("target name %s is used more than once", target.Name)
+		}
+		// both would write the same cluster secret
+		if !usesAPI && p.ArgoNamespace == target.ArgoNamespace && reflect.DeepEqual(p.ArgoInstance, target.ArgoInstance) {
+			return fmt.Errorf("targets %s and %s register into the same argo instance and namespace", p.Name, target.Name)
+		}
+	}
+	return nil
+}
+
+func mergeMaps(base, override map[string]string) map[string]string {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	out := maps.Clone(base)
+	if out == nil {
+		out = map[string]string{}
+	}
+	maps.Copy(out, override)
+	return out
+}
+
+// targetView is the ArgoCluster as seen by a single target, the registration funcs only look at the spec.
+func targetView(argoCluster *argov1.ArgoCluster, target argov1.ArgoTarget) *argov1.ArgoCluster {
+	view := argoCluster.DeepCopy()
+	view.Spec.ArgoNamespace = target.ArgoNamespace
+	view.Spec.ArgoInstance = target.ArgoInstance
+	view.Spec.Project = target.Project
+	view.Spec.ClusterLabels = maps.Clone(target.ClusterLabels)
+	view.Spec.ClusterAnnotations = target.ClusterAnnotations
+	view.Spec.ClusterResources = target.ClusterResources
+	view.Spec.Namespaces = target.Namespaces
+	view.Spec.CredentialMode = target.CredentialMode
+	view.Spec.Targets = nil
+	return view
+}
+
+func targetFromStatus(s argov1.ArgoTargetStatus) argov1.ArgoTarget {
+	return argov1.ArgoTarget{
+		Name:           s.Name,
+		ArgoNamespace:  s.ArgoNamespace,
+		ArgoInstance:   s.ArgoInstance,
+		CredentialMode: s.CredentialMode,
+	}
+}
+
+func targetStatus(target argov1.ArgoTarget, err error) argov1.ArgoTargetStatus {
+	now := metav1.Now()
+	status := argov1.ArgoTargetStatus{
+		Name:           target.Name,
+		ArgoNamespace:  target.ArgoNamespace,
+		ArgoInstance:   target.ArgoInstance,
+		CredentialMode: target.CredentialMode,
+		State:          "Ready",
+		Message:        "Cluster registered successfully.",
+		Ready:          true,
+		LastUpdated:    &now,
+	}
+	if err != nil {
+		status.State = "Failed"
+		status.Message = err.Error()
+		status.Ready = false
+	}
+	return status
+}
+
+// clusterKubeconfigRef returns the secret and key holding the kubeconfig of a workload cluster, the
+// kubeconfigRef set through v2 or the CAPI <clusterName>-kubeconfig secret.
+func clusterKubeconfigRef(argoCluster *argov1.ArgoCluster) (string, string, error) {
+	ref, err := argov2.KubeconfigRefFrom(argoCluster.ObjectMeta)
+	if err != nil {
+		return "", "", err
+	}
+	name, key := fmt.Sprintf("%s-kubeconfig", argoCluster.Spec.ClusterName), "value"
+	if ref != nil {
+		name = ref.Name
+		if ref.Key != "" {
+			key = ref.Key
+		}
+	}
+	return name, key, nil
+}
+
+// loadClusterKubeconfig reads the kubeconfig secret of a workload cluster.
+func loadClusterKubeconfig(client dynamic.Interface, argoCluster *argov1.ArgoCluster) (*clientcmdapi.Config, error) {
+	clusterName := argoCluster.Spec.ClusterName
+	secretName, key, err := clusterKubeconfigRef(argoCluster)
+	if err != nil {
+		return nil, terminalError(ReasonInvalidSpec, fmt.Errorf("invalid kubeconfigRef: %w", err))
+	}
+	kubeconfigUns, err := cachedGet(client, secretGVR, argoCluster.Namespace, secretName)
+	if err != nil {
+		log.Printf("unable to retrieve kubeconfig secret: %v", err)
+		if apierrors.IsNotFound(err) {
+			return nil, waitingError(ReasonKubeconfigNotFound, fmt.Errorf("unable to retrieve kubeconfig secret: %w", err))
+		}
+		return nil, fmt.Errorf("unable to retrieve kubeconfig secret: %w", err)
+	}
+
+	kubeconfig, found, err := unstructured.NestedStringMap(kubeconfigUns.Object, "data")
+	if err != nil || !found {
+		log.Printf("cannot get secret data: %v", err)
+		return nil, terminalError(ReasonInvalidKubeconfig, fmt.Errorf("cannot get secret data: %v", err))
+	}
+
+	encodedSecret, ok := kubeconfig[key]
+	if !ok {
+		log.Printf("%s does not exist in kubeconfig secret %s", key, secretName)
+		return nil, terminalError(ReasonInvalidKubeconfig, fmt.Errorf("%s does not exist in kubeconfig secret %s", key, secretName))
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encodedSecret)
+	if err != nil {
+		log.Printf("failed to decode value: %v", err)
+		return nil, terminalError(ReasonInvalidKubeconfig, fmt.Errorf("failed to decode value: %v", err))
+	}
+
+	config, err := clientcmd.Load(decoded)
+	if err != nil {
+		log.Printf("failed to read kubconfig data: %v", err)
+		return nil, terminalError(ReasonInvalidKubeconfig, fmt.Errorf("failed to read kubconfig data: %v", err))
+	}
+	if config.Clusters[clusterName] == nil {
+		return nil, terminalError(ReasonInvalidKubeconfig, fmt.Errorf("cluster %s does not exist in kubeconfig", clusterName))
+	}
+	return config, nil
+}
+
+// workloadClient connects to the workload cluster with its admin kubeconfig.
+func workloadClient(client dynamic.Interface, config *clientcmdapi.Config) (dynamic.Interface, error) {
+	if standIn, ok := client.(remoteStandIn); ok {
+		return standIn, nil
+	}
+	restConfig, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, terminalError(ReasonInvalidKubeconfig, fmt.Errorf("unable to build workload cluster client: %v", err))
+	}
+	return newRemoteClient(restConfig)
+}
+
+func workloadSAName(target argov1.ArgoTarget) string {
+	return fmt.Sprintf("argo-attach-%s", target.Name)
+}
+
+// workloadSAToken creates a cluster-admin service account for the target in the workload cluster and returns its token.
+func workloadSAToken(workload dynamic.Interface, target argov1.ArgoTarget) (string, error) {
+	saName := workloadSAName(target)
+	sa := newServiceAccountObject(workloadSANamespace, saName)
+	if _, err := workload.Resource(saGVR).Namespace(workloadSANamespace).Apply(context.TODO(), saName, sa, metav1.ApplyOptions{FieldManager: "argo-attach-controller"}); err != nil {
+		return "", fmt.Errorf("unable to create workload service account %s: %w", saName, err)
+	}
+
+	crbYaml := fmt.Sprintf(`
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: %s
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cluster-admin
+subjects:
+- kind: ServiceAccount
+  name: %s
+  namespace: %s
+`, saName, saName, workloadSANamespace)
+	crb := &unstructured.Unstructured{}
+	_ = yaml.Unmarshal([]byte(crbYaml), crb)
+	if _, err := workload.Resource(crbGVR).Apply(context.TODO(), saName, crb, metav1.ApplyOptions{FieldManager: "argo-attach-controller"}); err != nil {
+		return "", fmt.Errorf("unable to create workload cluster role binding %s: %w", saName, err)
+	}
+	return getSAToken(workload, workloadSANamespace, saName, nil)
+}
+
+func newServiceAccountObject(namespace, name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ServiceAccount",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+	}}
+}
+
+// deleteWorkloadSA removes what workloadSAToken created.
+func deleteWorkloadSA(workload dynamic.Interface, target argov1.ArgoTarget) error {
+	saName := workloadSAName(target)
+	deletes := []struct {
+		gvr       schema.GroupVersionResource
+		namespace string
+		name      string
+	}{
+		{secretGVR, workloadSANamespace, saName + "-token"},
+		{crbGVR, "", saName},
+		{saGVR, workloadSANamespace, saName},
+	}
+	for _, d := range deletes {
+		err := workload.Resource(d.gvr).Namespace(d.namespace).Delete(context.TODO(), d.name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete workload %s %s: %w", d.gvr.Resource, d.name, err)
+		}
+	}
+	log.Printf("succesfully deleted workload service account %s", saName)
+	return nil
+}
+
+// applyClusterTarget registers the workload cluster into a single target.
+func applyClusterTarget(client dynamic.Interface, argoCluster *argov1.ArgoCluster, target argov1.ArgoTarget, names clusterNames, config *clientcmdapi.Config, cfg *ControllerConfig) error {
+	clusterName := argoCluster.Spec.ClusterName
+	// targets using the argo api may leave argoNamespace empty
+	if target.ArgoNamespace != "" {
+		if err := cfg.checkArgoNamespace(target.ArgoNamespace); err != nil {
+			return err
+		}
+	}
+	if err := checkInstanceFeatures(target.ArgoInstance, cfg); err != nil {
+		return err
+	}
+	if err := checkDetachPolicy(argoCluster.Spec.DetachPolicy, target.ArgoInstance); err != nil {
+		return err
+	}
+	if target.CredentialMode == argov1.CredentialModeServiceAccount && !cfg.featureEnabled(FeatureServiceAccountCredentials) {
+		return terminalError(ReasonInvalidSpec, fmt.Errorf("the %s feature is disabled", FeatureServiceAccountCredentials))
+	}
+
+	cluster := config.Clusters[clusterName]
+	argoConfig := &ArgoConfig{
+		TLSClientConfig: &TLSClientConfig{
+			CAData:     base64.StdEncoding.EncodeToString(cluster.CertificateAuthorityData),
+			ServerName: argoCluster.Spec.ServerName,
+		},
+		ProxyUrl:           argoCluster.Spec.ProxyUrl,
+		DisableCompression: argoCluster.Spec.DisableCompression,
+	}
+	switch target.CredentialMode {
+	case argov1.CredentialModeServiceAccount:
+		workload, err := workloadClient(client, config)
+		if err != nil {
+			return err
+		}
+		token, err := workloadSAToken(workload, target)
+		if err != nil {
+			return err
+		}
+		argoConfig.BearerToken = token
+	default:
+		authInfo := config.AuthInfos[fmt.Sprintf("%s-admin", clusterName)]
+		if authInfo == nil {
+			return terminalError(ReasonInvalidKubeconfig, fmt.Errorf("user %s-admin does not exist in kubeconfig", clusterName))
+		}
+		argoConfig.TLSClientConfig.KeyData = base64.StdEncoding.EncodeToString(authInfo.ClientKeyData)
+		argoConfig.TLSClientConfig.CertData = base64.StdEncoding.EncodeToString(authInfo.ClientCertificateData)
+	}
+
+	jsonConfig, err := json.Marshal(argoConfig)
+	if err != nil {
+		log.Printf("unable to encoded argo config: %v", err)
+	}
+	// cluster scoped resources are managed by default unless explicitly disabled
+	clusterResources := true
+	if target.ClusterResources != nil {
+		clusterResources = *target.ClusterResources
+	}
+	secretData := map[string]string{
+		"name":             names.name,
+		"server":           cluster.Server,
+		"clusterResources": strconv.FormatBool(clusterResources),
+		"project":          target.Project,
+		"config":           string(jsonConfig),
+	}
+	if len(target.Namespaces) > 0 {
+		secretData["namespaces"] = strings.Join(target.Namespaces, ",")
+	}
+
+	if err := registerCluster(client, argoCluster.Namespace, targetView(argoCluster, target), names.secret, secretData); err != nil {
+		log.Printf("unable to create or update argo cluster secret %v", err)
+		return fmt.Errorf("unable to create or update argo cluster secret: %w", err)
+	}
+	log.Printf("succesfully registered cluster %s into target %s", clusterName, target.Name)
+	return nil
+}
+
+// cleanupClusterTarget unregisters the cluster from a single target and removes its workload credentials
+// unless they are orphaned.
+func cleanupClusterTarget(client dynamic.Interface, argoCluster *argov1.ArgoCluster, target argov1.ArgoTarget, names clusterNames, orphanCredentials bool) error {
+	if err := unregisterCluster(client, argoCluster.Namespace, targetView(argoCluster, target), names); err != nil {
+		return err
+	}
+	if target.CredentialMode != argov1.CredentialModeServiceAccount {
+		return nil
+	}
+	if orphanCredentials {
+		log.Printf("leaving the workload service account of target %s in place", target.Name)
+		return nil
+	}
+	config, err := loadClusterKubeconfig(client, argoCluster)
+	if apierrors.IsNotFound(err) {
+		log.Printf("workload kubeconfig is gone, not cleaning up service account for target %s", target.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	workload, err := workloadClient(client, config)
+	if err != nil {
+		return err
+	}
+	return deleteWorkloadSA(workload, target)
+}
+
+// patchTargetStatus records the result of every target under its own field manager.
+func patchTargetStatus(client dynamic.Interface, obj *unstructured.Unstructured, statuses []argov1.ArgoTargetStatus) error {
+	targets := []interface{}{}
+	for _, s := range statuses {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&s)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, u)
+	}
+	return applyStatus(context.TODO(), client, argoClusterGVR, obj, map[string]interface{}{"targets": targets}, TargetStatusFieldManager)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	argov2 "github.com/warroyo/argocd-attach-service/api/v2"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestClusterTargets(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name    string
+		spec    argov1.ArgoClusterSpec
+		want    []argov1.ArgoTarget
+		wantErr string
+	}{
+		{
+			name: "spec without targets is the default target",
+			spec: argov1.ArgoClusterSpec{ClusterName: "wl", ArgoNamespace: "argocd", Project: "p", ClusterLabels: map[string]string{"a": "1"}},
+			want: []argov1.ArgoTarget{{Name: "default", ArgoNamespace: "argocd", Project: "p", ClusterLabels: map[string]string{"a": "1"}, CredentialMode: argov1.CredentialModeClientCertificate}},
+		},
+		{
+			name: "targets inherit the spec",
+			spec: argov1.ArgoClusterSpec{
+				ClusterName: "wl", ArgoNamespace: "argocd", Project: "p", ClusterLabels: map[string]string{"a": "1", "b": "1"},
+				Targets: []argov1.ArgoTarget{
+					{Name: "platform"},
+					{Name: "tenant", ArgoNamespace: "tenant-argo", Project: "t", ClusterLabels: map[string]string{"b": "2"}, ClusterResources: &disabled, CredentialMode: argov1.CredentialModeServiceAccount},
+				},
+			},
+			want: []argov1.ArgoTarget{
+				{Name: "platform", ArgoNamespace: "argocd", Project: "p", ClusterLabels: map[string]string{"a": "1", "b": "1"}, CredentialMode: argov1.CredentialModeClientCertificate},
+				{Name: "tenant", ArgoNamespace: "tenant-argo", Project: "t", ClusterLabels: map[string]string{"a": "1", "b": "2"}, ClusterResources: &disabled, CredentialMode: argov1.CredentialModeServiceAccount},
+			},
+		},
+		{
+			name: "duplicate names",
+			spec: argov1.ArgoClusterSpec{
+				ArgoNamespace: "argocd", Project: "p",
+				Targets: []argov1.ArgoTarget{{Name: "a"}, {Name: "a", ArgoNamespace: "other"}},
+			},
+			wantErr: "more than once",
+		},
+		{
+			name: "same namespace twice",
+			spec: argov1.ArgoClusterSpec{
+				ArgoNamespace: "argocd", Project: "p",
+				Targets: []argov1.ArgoTarget{{Name: "a"}, {Name: "b", Project: "other"}},
+			},
+			wantErr: "same argo instance and namespace",
+		},
+		{
+			name:    "missing project",
+			spec:    argov1.ArgoClusterSpec{Targets: []argov1.ArgoTarget{{Name: "a", ArgoNamespace: "argocd"}}},
+			wantErr: "no project",
+		},
+		{
+			name:    "unknown credential mode",
+			spec:    argov1.ArgoClusterSpec{ArgoNamespace: "argocd", Project: "p", CredentialMode: "Password"},
+			wantErr: "unknown credentialMode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := clusterTargets(&tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d targets, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !equality.Semantic.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("target %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func targetStatuses(t *testing.T, cr *unstructured.Unstructured) map[string]string {
+	t.Helper()
+	targets, _, _ := unstructured.NestedSlice(cr.Object, "status", "targets")
+	states := map[string]string{}
+	for _, target := range targets {
+		m := target.(map[string]interface{})
+		states[m["name"].(string)] = m["state"].(string)
+	}
+	return states
+}
+
+func TestReconcileMultipleTargets(t *testing.T) {
+	spec := map[string]interface{}{
+		"clusterName":   "wl",
+		"argoNamespace": "argocd",
+		"project":       "platform",
+		"targets": []interface{}{
+			map[string]interface{}{"name": "platform"},
+			map[string]interface{}{"name": "tenant", "argoNamespace": "tenant-argo", "project": "tenant", "clusterLabels": map[string]interface{}{"team": "a"}},
+			map[string]interface{}{"name": "blocked", "argoNamespace": "kube-system"},
+		},
+	}
+	client := newFakeClient(
+		newArgoCluster("wl", spec, argoClusterFinalizer),
+		newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"),
+	)
+	c := newTestClusterController(client, []string{"kube-system"})
+
+	_, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl"))
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 targets failed") {
+		t.Fatalf("expected one failed target, got %v", err)
+	}
+
+	if getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") == nil {
+		t.Error("platform secret was not created")
+	}
+	tenant := getObject(t, client, secretGVR, "tenant-argo", "wl-argo-cluster")
+	if tenant == nil {
+		t.Fatal("tenant secret was not created")
+	}
+	if project, _, _ := unstructured.NestedString(tenant.Object, "stringData", "project"); project != "tenant" || tenant.GetLabels()["team"] != "a" {
+		t.Errorf("unexpected tenant secret project %q labels %v", project, tenant.GetLabels())
+	}
+
+	cr := getObject(t, client, argoClusterGVR, "default", "wl")
+	states := targetStatuses(t, cr)
+	if states["platform"] != "Ready" || states["tenant"] != "Ready" || states["blocked"] != "Failed" {
+		t.Errorf("unexpected target states %v", states)
+	}
+	if state, _, _ := unstructured.NestedString(cr.Object, "status", "state"); state != "Failed" {
+		t.Errorf("status.state = %q, want Failed", state)
+	}
+
+	// dropping targets unregisters them
+	_ = unstructured.SetNestedSlice(cr.Object, []interface{}{map[string]interface{}{"name": "platform"}}, "spec", "targets")
+	if err := client.Tracker().Update(argoClusterGVR, cr, "default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, client, secretGVR, "tenant-argo", "wl-argo-cluster") != nil {
+		t.Error("removed tenant target was not cleaned up")
+	}
+	cr = getObject(t, client, argoClusterGVR, "default", "wl")
+	if states := targetStatuses(t, cr); len(states) != 1 || states["platform"] != "Ready" {
+		t.Errorf("unexpected target states after removal %v", states)
+	}
+
+	// deletion cleans up every target
+	if err := client.Tracker().Update(argoClusterGVR, markDeleted(cr), "default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, client, secretGVR, "argocd", "wl-argo-cluster") != nil {
+		t.Error("platform secret was not removed")
+	}
+}
+
+func TestServiceAccountCredentialMode(t *testing.T) {
+	spec := map[string]interface{}{
+		"clusterName":    "wl",
+		"argoNamespace":  "argocd",
+		"project":        "default",
+		"credentialMode": argov1.CredentialModeServiceAccount,
+	}
+	client := newFakeClient(
+		newArgoCluster("wl", spec),
+		newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"),
+	)
+	// the token controller of the workload cluster has already populated the token
+	workload := newFakeClient(newSecret(workloadSANamespace, "argo-attach-default-token", map[string]interface{}{"token": "d2wtdG9rZW4="}))
+	origClient := newRemoteClient
+	newRemoteClient = func(config *rest.Config) (dynamic.Interface, error) {
+		if config.Host != "https://10.0.0.1:6443" {
+			t.Errorf("workload client built for %s", config.Host)
+		}
+		return workload, nil
+	}
+	t.Cleanup(func() { newRemoteClient = origClient })
+
+	if err := applyArgoCluster(client, getObject(t, client, argoClusterGVR, "default", "wl"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, workload, saGVR, workloadSANamespace, "argo-attach-default") == nil || getObject(t, workload, crbGVR, "", "argo-attach-default") == nil {
+		t.Error("workload service account and binding were not created")
+	}
+	secret := getObject(t, client, secretGVR, "argocd", "wl-argo-cluster")
+	if secret == nil {
+		t.Fatal("argo secret was not created")
+	}
+	config, _, _ := unstructured.NestedString(secret.Object, "stringData", "config")
+	if !strings.Contains(config, `"bearerToken":"wl-token"`) || strings.Contains(config, "keyData") {
+		t.Errorf("unexpected config %s", config)
+	}
+
+	if err := deleteClusterCleanup(client, getObject(t, client, argoClusterGVR, "default", "wl"), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if getObject(t, workload, saGVR, workloadSANamespace, "argo-attach-default") != nil || getObject(t, workload, crbGVR, "", "argo-attach-default") != nil {
+		t.Error("workload service account and binding were not removed")
+	}
+}
+
+func TestLoadClusterKubeconfig(t *testing.T) {
+	// the same kubeconfig under the name and key a v2 kubeconfigRef points at
+	custom := newKubeconfigSecret(t, "default", "wl", "https://10.0.0.2:6443")
+	custom.SetName("custom")
+	value, _, _ := unstructured.NestedString(custom.Object, "data", "value")
+	_ = unstructured.SetNestedStringMap(custom.Object, map[string]string{"config": value}, "data")
+
+	tests := []struct {
+		name       string
+		annotation string
+		wantServer string
+		wantReason string
+	}{
+		{name: "capi secret by default", wantServer: "https://10.0.0.1:6443"},
+		{name: "kubeconfigRef", annotation: `{"kubeconfigRef":{"name":"custom","key":"config"}}`, wantServer: "https://10.0.0.2:6443"},
+		{name: "kubeconfigRef key defaults to value", annotation: `{"kubeconfigRef":{"name":"custom"}}`, wantReason: ReasonInvalidKubeconfig},
+		{name: "missing secret", annotation: `{"kubeconfigRef":{"name":"missing"}}`, wantReason: ReasonKubeconfigNotFound},
+		{name: "invalid annotation", annotation: `{`, wantReason: ReasonInvalidSpec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(newKubeconfigSecret(t, "default", "wl", "https://10.0.0.1:6443"), custom)
+			argoCluster := &argov1.ArgoCluster{Spec: &argov1.ArgoClusterSpec{ClusterName: "wl"}}
+			argoCluster.Namespace = "default"
+			if tt.annotation != "" {
+				argoCluster.Annotations = map[string]string{argov2.ConversionDataAnnotation: tt.annotation}
+			}
+			config, err := loadClusterKubeconfig(client, argoCluster)
+			if tt.wantReason != "" {
+				if _, reason := classifyError(err); reason != tt.wantReason {
+					t.Fatalf("error = %v, want reason %s", err, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := config.Clusters["wl"].Server; got != tt.wantServer {
+				t.Errorf("server = %s, want %s", got, tt.wantServer)
+			}
+		})
+	}
+}
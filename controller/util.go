@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -17,8 +18,8 @@ import (
 
 const StatusFieldManager = "status-controller"
 
-func convertObj(obj any) (ArgoCluster, error) {
-	argoCluster := ArgoCluster{}
+func convertObj(obj any) (argov1.ArgoCluster, error) {
+	argoCluster := argov1.ArgoCluster{}
 	unstructuredObj, ok := obj.(*unstructured.Unstructured)
 	if !ok {
 		return argoCluster, fmt.Errorf("unable to convert to unstuctured object")
@@ -30,8 +31,8 @@ func convertObj(obj any) (ArgoCluster, error) {
 	return argoCluster, nil
 }
 
-func convertNs(obj any) (ArgoNamespace, error) {
-	argoNs := ArgoNamespace{}
+func convertNs(obj any) (argov1.ArgoNamespace, error) {
+	argoNs := argov1.ArgoNamespace{}
 	unstructuredObj, ok := obj.(*unstructured.Unstructured)
 	if !ok {
 		return argoNs, fmt.Errorf("unable to convert to unstuctured object")
@@ -51,6 +52,11 @@ func toUnstructured(obj interface{}) (*unstructured.Unstructured, error) {
 }
 
 func patchStatus(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, obj *unstructured.Unstructured, statusData map[string]interface{}) error {
+	return applyStatus(ctx, client, gvr, obj, statusData, StatusFieldManager)
+}
+
+// applyStatus server side applies statusData as fieldManager, retrying on conflicts.
+func applyStatus(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, obj *unstructured.Unstructured, statusData map[string]interface{}, fieldManager string) error {
 
 	statusObj := &unstructured.Unstructured{
 		Object: map[string]interface{}{
@@ -73,7 +79,7 @@ func patchStatus(ctx context.Context, client dynamic.Interface, gvr schema.Group
 			ctx,
 			statusObj.GetName(),
 			statusObj,
-			metav1.ApplyOptions{FieldManager: StatusFieldManager, Force: true},
+			metav1.ApplyOptions{FieldManager: fieldManager, Force: true},
 		)
 
 		if err == nil {
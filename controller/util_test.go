@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestPatchStatusRetries(t *testing.T) {
+	conflict := apierrors.NewConflict(schema.GroupResource{Group: "field.vmware.com", Resource: "argoclusters"}, "wl", nil)
+
+	tests := []struct {
+		name      string
+		failures  int
+		failErr   error
+		exists    bool
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "succeeds first time", exists: true, wantCalls: 1},
+		{name: "retries on conflict", failures: 2, failErr: conflict, exists: true, wantCalls: 3},
+		{name: "gives up after max retries", failures: 10, failErr: conflict, exists: true, wantErr: true, wantCalls: 5},
+		{name: "skips deleted resource", wantCalls: 1},
+		{name: "does not retry other errors", failures: 10, failErr: apierrors.NewForbidden(schema.GroupResource{}, "wl", nil), exists: true, wantErr: true, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newArgoCluster("wl", map[string]interface{}{})
+			var objs []runtime.Object
+			if tt.exists {
+				objs = append(objs, cr.DeepCopy())
+			}
+			client := newFakeClient(objs...)
+
+			calls := 0
+			client.PrependReactor("patch", "argoclusters", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				calls++
+				if calls <= tt.failures {
+					return true, nil, tt.failErr
+				}
+				return false, nil, nil
+			})
+
+			err := patchStatus(context.TODO(), client, argoClusterGVR, cr, map[string]interface{}{"state": "Ready"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("apply calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.exists && !tt.wantErr {
+				u := getObject(t, client, argoClusterGVR, "default", "wl")
+				state, _, _ := unstructured.NestedString(u.Object, "status", "state")
+				if state != "Ready" {
+					t.Errorf("status.state = %q, want Ready", state)
+				}
+			}
+		})
+	}
+}
+
+func TestPatchFinalizer(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   []string
+		finalizers []string
+	}{
+		{name: "add", finalizers: []string{argoClusterFinalizer}},
+		{name: "remove", existing: []string{"other", argoClusterFinalizer}, finalizers: []string{"other"}},
+		{name: "remove last", existing: []string{argoClusterFinalizer}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newArgoCluster("wl", map[string]interface{}{}, tt.existing...)
+			client := newFakeClient(cr)
+
+			if err := patchFinalizer(context.TODO(), client, argoClusterGVR, cr, argoClusterFinalizer, tt.finalizers); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := getObject(t, client, argoClusterGVR, "default", "wl").GetFinalizers()
+			if len(got) != len(tt.finalizers) {
+				t.Fatalf("finalizers = %v, want %v", got, tt.finalizers)
+			}
+			for i := range got {
+				if got[i] != tt.finalizers[i] {
+					t.Errorf("finalizers = %v, want %v", got, tt.finalizers)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	argov1 "github.com/warroyo/argocd-attach-service/api/v1"
+	argov2 "github.com/warroyo/argocd-attach-service/api/v2"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var crdGVR = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+var crdNames = []string{"argoclusters.field.vmware.com", "argonamespaces.field.vmware.com"}
+
+type webhookOptions struct {
+	port      int
+	certDir   string
+	service   string
+	namespace string
+}
+
+// convertObject converts an ArgoCluster or ArgoNamespace between v1 and v2.
+func convertObject(obj *unstructured.Unstructured, desiredAPIVersion string) (*unstructured.Unstructured, error) {
+	fromVersion := obj.GetAPIVersion()
+	if fromVersion == desiredAPIVersion {
+		return obj, nil
+	}
+
+	v1Version := argov1.SchemeGroupVersion.String()
+	v2Version := argov2.SchemeGroupVersion.String()
+	var converted runtime.Object
+
+	switch {
+	case obj.GetKind() == "ArgoCluster" && fromVersion == v1Version && desiredAPIVersion == v2Version:
+		src := &argov1.ArgoCluster{}
+		dst := &argov2.ArgoCluster{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, src); err != nil {
+			return nil, err
+		}
+		if err := dst.ConvertFrom(src); err != nil {
+			return nil, err
+		}
+		converted = dst
+	case obj.GetKind() == "ArgoCluster" && fromVersion == v2Version && desiredAPIVersion == v1Version:
+		src := &argov2.ArgoCluster{}
+		dst := &argov1.ArgoCluster{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, src); err != nil {
+			return nil, err
+		}
+		if err := src.ConvertTo(dst); err != nil {
+			return nil, err
+		}
+		converted = dst
+	case obj.GetKind() == "ArgoNamespace" && fromVersion == v1Version && desiredAPIVersion == v2Version:
+		src := &argov1.ArgoNamespace{}
+		dst := &argov2.ArgoNamespace{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, src); err != nil {
+			return nil, err
+		}
+		if err := dst.ConvertFrom(src); err != nil {
+			return nil, err
+		}
+		converted = dst
+	case obj.GetKind() == "ArgoNamespace" && fromVersion == v2Version && desiredAPIVersion == v1Version:
+		src := &argov2.ArgoNamespace{}
+		dst := &argov1.ArgoNamespace{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, src); err != nil {
+			return nil, err
+		}
+		if err := src.ConvertTo(dst); err != nil {
+			return nil, err
+		}
+		converted = dst
+	default:
+		return nil, fmt.Errorf("unsupported conversion of %s %s to %s", fromVersion, obj.GetKind(), desiredAPIVersion)
+	}
+
+	out, err := runtime.DefaultUnstructuredConverter.ToUnstructured(converted)
+	if err != nil {
+		return nil, err
+	}
+	// status is a struct so it is always emitted, drop it when there is nothing in it
+	if status, ok := out["status"].(map[string]interface{}); ok && len(status) == 0 {
+		delete(out, "status")
+	}
+	return &unstructured.Unstructured{Object: out}, nil
+}
+
+// handleConvert serves ConversionReview requests from the API server.
+func handleConvert(w http.ResponseWriter, r *http.Request) {
+	review := &apiextensionsv1.ConversionReview{}
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil || review.Request == nil {
+		http.Error(w, "invalid ConversionReview", http.StatusBadRequest)
+		return
+	}
+
+	response := &apiextensionsv1.ConversionResponse{
+		UID:    review.Request.UID,
+		Result: metav1.Status{Status: metav1.StatusSuccess},
+	}
+	for _, raw := range review.Request.Objects {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+			response.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+			break
+		}
+		converted, err := convertObject(obj, review.Request.DesiredAPIVersion)
+		if err != nil {
+			log.Printf("conversion of %s/%s failed: %v", obj.GetNamespace(), obj.GetName(), err)
+			response.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+			break
+		}
+		data, err := converted.MarshalJSON()
+		if err != nil {
+			response.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+			break
+		}
+		response.ConvertedObjects = append(response.ConvertedObjects, runtime.RawExtension{Raw: data})
+	}
+	if response.Result.Status != metav1.StatusSuccess {
+		response.ConvertedObjects = nil
+	}
+
+	review.Request = nil
+	review.Response = response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		log.Printf("unable to write conversion response: %v", err)
+	}
+}
+
+// generateCerts creates a self signed CA and a serving certificate for dnsNames signed by it.
+func generateCerts(dnsNames []string) ([]byte, tls.Certificate, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "argo-attach-webhook-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+
+	cert, err := tls.X509KeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), cert, nil
+}
+
+// injectCABundle points the conversion webhook of our CRDs at the CA that signed the serving cert.
+func injectCABundle(ctx context.Context, client dynamic.Interface, caPEM []byte) error {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"conversion": map[string]interface{}{
+				"webhook": map[string]interface{}{
+					"clientConfig": map[string]interface{}{
+						"caBundle": base64.StdEncoding.EncodeToString(caPEM),
+					},
+				},
+			},
+		},
+	}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	for _, name := range crdNames {
+		if _, err := client.Resource(crdGVR).Patch(ctx, name, types.MergePatchType, patchData, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("unable to inject ca bundle into crd %s: %w", name, err)
+		}
+		log.Printf("injected conversion webhook ca bundle into crd %s", name)
+	}
+	return nil
+}
+
+// startWebhook serves the conversion webhook until ctx is cancelled. Without a cert dir a
+// self signed certificate is generated and its CA is injected into the CRDs.
+func startWebhook(ctx context.Context, client dynamic.Interface, opts webhookOptions) error {
+	var cert tls.Certificate
+	var err error
+	if opts.certDir != "" {
+		cert, err = tls.LoadX509KeyPair(filepath.Join(opts.certDir, "tls.crt"), filepath.Join(opts.certDir, "tls.key"))
+		if err != nil {
+			return fmt.Errorf("unable to load webhook certificate: %w", err)
+		}
+	} else {
+		dnsNames := []string{
+			fmt.Sprintf("%s.%s.svc", opts.service, opts.namespace),
+			fmt.Sprintf("%s.%s.svc.cluster.local", opts.service, opts.namespace),
+			fmt.Sprintf("%s.%s", opts.service, opts.namespace),
+			opts.service,
+		}
+		var caPEM []byte
+		caPEM, cert, err = generateCerts(dnsNames)
+		if err != nil {
+			return fmt.Errorf("unable to generate webhook certificate: %w", err)
+		}
+		if err := injectCABundle(ctx, client, caPEM); err != nil {
+			return err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", handleConvert)
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", opts.port),
+		Handler:   mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+	}
+
+	go func() {
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			log.Printf("conversion webhook server failed: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+	log.Printf("conversion webhook listening on :%d", opts.port)
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	argov2 "github.com/warroyo/argocd-attach-service/api/v2"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func conversionRequest(t *testing.T, desired string, objs ...*unstructured.Unstructured) *apiextensionsv1.ConversionReview {
+	t.Helper()
+	review := &apiextensionsv1.ConversionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "ConversionReview"},
+		Request:  &apiextensionsv1.ConversionRequest{UID: "1234", DesiredAPIVersion: desired},
+	}
+	for _, obj := range objs {
+		raw, err := obj.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		review.Request.Objects = append(review.Request.Objects, runtime.RawExtension{Raw: raw})
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleConvert(rec, httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	out := &apiextensionsv1.ConversionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Response == nil || out.Response.UID != "1234" {
+		t.Fatalf("unexpected response %+v", out.Response)
+	}
+	return out
+}
+
+func TestHandleConvert(t *testing.T) {
+	cluster := newArgoCluster("wl", map[string]interface{}{"clusterName": "wl", "argoNamespace": "argocd", "project": "default"})
+	cluster.Object["status"] = map[string]interface{}{"state": "Ready", "ready": true, "message": "attached"}
+	ns := newArgoNamespace("ns", map[string]interface{}{"argoNamespace": "argocd", "project": "default"})
+
+	out := conversionRequest(t, argov2.SchemeGroupVersion.String(), cluster, ns)
+	if out.Response.Result.Status != metav1.StatusSuccess {
+		t.Fatalf("conversion failed: %s", out.Response.Result.Message)
+	}
+	if len(out.Response.ConvertedObjects) != 2 {
+		t.Fatalf("got %d converted objects", len(out.Response.ConvertedObjects))
+	}
+
+	converted := &unstructured.Unstructured{}
+	if err := converted.UnmarshalJSON(out.Response.ConvertedObjects[0].Raw); err != nil {
+		t.Fatal(err)
+	}
+	if converted.GetAPIVersion() != "field.vmware.com/v2" {
+		t.Errorf("apiVersion = %s", converted.GetAPIVersion())
+	}
+	conditions, _, _ := unstructured.NestedSlice(converted.Object, "status", "conditions")
+	if len(conditions) != 1 || conditions[0].(map[string]interface{})["reason"] != "Ready" {
+		t.Errorf("unexpected conditions %v", conditions)
+	}
+
+	// and back again
+	back := conversionRequest(t, "field.vmware.com/v1", converted)
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(back.Response.ConvertedObjects[0].Raw); err != nil {
+		t.Fatal(err)
+	}
+	if state, _, _ := unstructured.NestedString(obj.Object, "status", "state"); state != "Ready" {
+		t.Errorf("state = %q, want Ready", state)
+	}
+	if len(obj.GetAnnotations()) != 0 {
+		t.Errorf("unexpected annotations %v", obj.GetAnnotations())
+	}
+}
+
+func TestHandleConvertUnsupported(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "field.vmware.com/v1",
+		"kind":       "Unknown",
+		"metadata":   map[string]interface{}{"name": "x"},
+	}}
+	out := conversionRequest(t, argov2.SchemeGroupVersion.String(), obj)
+	if out.Response.Result.Status != metav1.StatusFailure {
+		t.Errorf("expected failure, got %+v", out.Response.Result)
+	}
+	if len(out.Response.ConvertedObjects) != 0 {
+		t.Errorf("expected no converted objects")
+	}
+}
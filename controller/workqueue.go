@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -31,49 +30,86 @@ func (c *Controller) processNextWorkItem() bool {
 
 	// Run the core reconcile logic.
 	// We use the key (namespace/name) to re-fetch the resource in Reconcile.
-	if err := c.reconcileByKey(key); err != nil {
-		// If an error occurred during processing, use the ratelimiter to requeue the item.
-		if c.Queue.NumRequeues(key) < 10 { // Limit retries to prevent runaway loops
+	result, err := c.reconcileByKey(key)
+	if err != nil {
+		kind, reason := classifyError(err)
+		switch kind {
+		case errorTerminal:
+			// Retrying can't fix it, wait for the spec to change.
+			c.Queue.Forget(obj)
+			fmt.Printf("Failed to reconcile %s: %v. Not retrying until the spec changes (%s).\n", key, err, reason)
+		case errorWaiting:
+			// A dependency doesn't exist yet, check again after a fixed delay instead of backing off.
+			delay := requeueDelay(err)
+			c.Queue.Forget(obj)
+			fmt.Printf("Failed to reconcile %s: %v. Waiting %s (%s).\n", key, err, delay, reason)
+			c.Queue.AddAfter(key, delay)
+		default:
+			// The rate limiter caps the backoff, transient errors are retried until they succeed.
 			fmt.Printf("Failed to reconcile %s: %v. Retrying...\n", key, err)
 			c.Queue.AddRateLimited(key)
-			return true
 		}
-
-		// Max retries reached, log the final error and forget the item.
-		c.Queue.Forget(obj)
-		fmt.Printf("Max retries exceeded for %s: %v. Forgetting item.\n", key, err)
 		return true
 	}
 
-	// If successful, forget the item's rate limit history.
-	c.Queue.Forget(obj)
+	switch {
+	case result.RequeueAfter > 0:
+		// The reconciler wants to look at the object again at a specific time.
+		c.Queue.Forget(obj)
+		fmt.Printf("Reconciled %s, requeuing after %s.\n", key, result.RequeueAfter)
+		c.Queue.AddAfter(key, result.RequeueAfter)
+	case result.Requeue:
+		fmt.Printf("Reconciled %s, requeuing.\n", key)
+		c.Queue.AddRateLimited(key)
+	default:
+		// If successful, forget the item's rate limit history.
+		c.Queue.Forget(obj)
+	}
 	return true
 }
 
 // reconcileByKey is a wrapper that fetches the object before calling the main Reconcile logic.
-func (c *Controller) reconcileByKey(key string) error {
+func (c *Controller) reconcileByKey(key string) (Result, error) {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
-		return fmt.Errorf("invalid resource key: %s", key)
+		return Result{}, fmt.Errorf("invalid resource key: %s", key)
 	}
 
-	// We must get the latest object from the API to start the reconciliation,
-	// instead of passing the potentially stale object from the informer handler.
-	// This ensures Level-Triggering.
-	u, err := c.client.Resource(c.gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	// The informer cache is kept up to date by the watch, so resyncs don't hit the API server.
+	// Objects that aren't cached yet are read from the API server.
+	u, err := cachedGet(c.client, c.gvr, namespace, name)
 
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			// Resource is gone. This is expected. Stop processing.
-			return nil
+			return Result{}, nil
 		}
 		// Transient API error. Return error for retry.
-		return fmt.Errorf("failed to fetch resource %s: %w", key, err)
+		return Result{}, fmt.Errorf("failed to fetch resource %s: %w", key, err)
+	}
+
+	if generation, ok := c.terminal.Load(key); ok && u.GetDeletionTimestamp().IsZero() {
+		if generation == u.GetGeneration() {
+			fmt.Printf("Skipping %s, generation %d failed with a terminal error.\n", key, generation)
+			return Result{}, nil
+		}
+		c.terminal.Delete(key)
 	}
 
 	return c.Reconcile(u)
 }
 
+// retryTerminal forgets the terminal errors and requeues their objects. Errors like a blocked
+// namespace come from the config, a new config may have fixed them without a spec change.
+func (c *Controller) retryTerminal() {
+	c.terminal.Range(func(key, _ any) bool {
+		c.terminal.Delete(key)
+		fmt.Printf("Config changed, retrying %s after its terminal error.\n", key)
+		c.Queue.Add(key)
+		return true
+	})
+}
+
 // runWorker is a single goroutine that continually processes items from the workqueue.
 func (c *Controller) runWorker(ctx context.Context) {
 	// Loop forever until the context is cancelled.
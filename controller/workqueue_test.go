@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestProcessNextWorkItem(t *testing.T) {
+	waitingRequeueDelay = 10 * time.Millisecond
+	defer func() { waitingRequeueDelay = 15 * time.Second }()
+
+	tests := []struct {
+		name         string
+		err          error
+		wantState    string
+		wantReason   string
+		wantRetries  float64
+		wantRequeues int
+		wantQueued   bool
+	}{
+		{
+			name:         "transient error backs off",
+			err:          fmt.Errorf("connection refused"),
+			wantState:    "Failed",
+			wantReason:   ReasonReconcileError,
+			wantRetries:  1,
+			wantRequeues: 1,
+		},
+		{
+			name:       "waiting error is checked again after a delay",
+			err:        waitingError(ReasonKubeconfigNotFound, fmt.Errorf("kubeconfig not found")),
+			wantState:  "Waiting",
+			wantReason: ReasonKubeconfigNotFound,
+			wantQueued: true,
+		},
+		{
+			name:       "terminal error is not retried",
+			err:        terminalError(ReasonInvalidSpec, fmt.Errorf("bad spec")),
+			wantState:  "Failed",
+			wantReason: ReasonInvalidSpec,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(newArgoCluster("wl", map[string]interface{}{"clusterName": "wl"}, argoClusterFinalizer))
+			c := newTestClusterController(client, nil)
+			c.Queue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Hour, time.Hour))
+			defer c.Queue.ShutDown()
+			c.reconciler = ReconcilerFuncs{ProvisionFunc: func(dynamic.Interface, *unstructured.Unstructured, *ControllerConfig) (Result, error) {
+				return Result{}, tt.err
+			}}
+
+			c.Queue.Add("default/wl")
+			c.processNextWorkItem()
+
+			if got := c.Queue.NumRequeues("default/wl"); got != tt.wantRequeues {
+				t.Errorf("requeues = %d, want %d", got, tt.wantRequeues)
+			}
+			time.Sleep(50 * time.Millisecond)
+			if got := c.Queue.Len() == 1; got != tt.wantQueued {
+				t.Errorf("queued = %v, want %v", got, tt.wantQueued)
+			}
+
+			cr := getObject(t, client, argoClusterGVR, "default", "wl")
+			state, _, _ := unstructured.NestedString(cr.Object, "status", "state")
+			reason, _, _ := unstructured.NestedString(cr.Object, "status", "reason")
+			// the fake apply round trips through json, so numbers come back as float64
+			retries, _, _ := unstructured.NestedFloat64(cr.Object, "status", "retries")
+			if state != tt.wantState || reason != tt.wantReason || retries != tt.wantRetries {
+				t.Errorf("status = %s/%s/%v, want %s/%s/%v", state, reason, retries, tt.wantState, tt.wantReason, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestTerminalErrorWaitsForSpecChange(t *testing.T) {
+	client := newFakeClient(newArgoCluster("wl", map[string]interface{}{"clusterName": "wl"}, argoClusterFinalizer))
+	c := newTestClusterController(client, nil)
+	c.Queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer c.Queue.ShutDown()
+	calls := 0
+	c.reconciler = ReconcilerFuncs{ProvisionFunc: func(dynamic.Interface, *unstructured.Unstructured, *ControllerConfig) (Result, error) {
+		calls++
+		return Result{}, terminalError(ReasonInvalidSpec, fmt.Errorf("bad spec"))
+	}}
+
+	for i := 0; i < 2; i++ {
+		c.Queue.Add("default/wl")
+		c.processNextWorkItem()
+	}
+	if calls != 1 {
+		t.Fatalf("provision ran %d times for the same generation, want 1", calls)
+	}
+
+	cr := getObject(t, client, argoClusterGVR, "default", "wl")
+	cr.SetGeneration(cr.GetGeneration() + 1)
+	if err := client.Tracker().Update(argoClusterGVR, cr, "default"); err != nil {
+		t.Fatal(err)
+	}
+	c.Queue.Add("default/wl")
+	c.processNextWorkItem()
+	if calls != 2 {
+		t.Errorf("provision ran %d times after a spec change, want 2", calls)
+	}
+}
+
+func TestTerminalErrorRetriedAfterConfigChange(t *testing.T) {
+	client := newFakeClient(newArgoCluster("wl", map[string]interface{}{"clusterName": "wl"}, argoClusterFinalizer))
+	c := newTestClusterController(client, nil)
+	c.Queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer c.Queue.ShutDown()
+	store, err := newConfigStore(defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.onReload(c.retryTerminal)
+	calls := 0
+	c.reconciler = ReconcilerFuncs{ProvisionFunc: func(dynamic.Interface, *unstructured.Unstructured, *ControllerConfig) (Result, error) {
+		calls++
+		return Result{}, terminalError(ReasonBlockedNamespace, fmt.Errorf("blocked"))
+	}}
+
+	c.Queue.Add("default/wl")
+	c.processNextWorkItem()
+	// the same config again is no change
+	if err := store.load([]byte(""), "configmap argo-attach/argo-attach-config"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Queue.Len() != 0 {
+		t.Fatalf("requeued without a config change")
+	}
+
+	if err := store.load([]byte("blockedNamespaces: []"), "configmap argo-attach/argo-attach-config"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Queue.Len() != 1 {
+		t.Fatalf("queue length %d after the config change, want 1", c.Queue.Len())
+	}
+	c.processNextWorkItem()
+	if calls != 2 {
+		t.Errorf("provision ran %d times, want a retry after the config change", calls)
+	}
+}
+
+func TestReconcileResult(t *testing.T) {
+	client := newFakeClient(newArgoCluster("wl", map[string]interface{}{"clusterName": "wl"}, argoClusterFinalizer))
+	c := newTestClusterController(client, nil)
+	c.Queue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Hour, time.Hour))
+	defer c.Queue.ShutDown()
+	c.reconciler = ReconcilerFuncs{
+		ProvisionFunc: func(dynamic.Interface, *unstructured.Unstructured, *ControllerConfig) (Result, error) {
+			return Result{RequeueAfter: 10 * time.Millisecond, Status: map[string]interface{}{"message": "certificate expires soon"}}, nil
+		},
+		CleanupFunc: func(dynamic.Interface, *unstructured.Unstructured, *ControllerConfig) (Result, error) {
+			return Result{RequeueAfter: time.Minute}, nil
+		},
+	}
+
+	c.Queue.Add("default/wl")
+	c.processNextWorkItem()
+	if c.Queue.Len() != 0 {
+		t.Fatalf("requeued before RequeueAfter")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if c.Queue.Len() != 1 || c.Queue.NumRequeues("default/wl") != 0 {
+		t.Errorf("queue length %d with %d requeues, want the key queued once without backoff", c.Queue.Len(), c.Queue.NumRequeues("default/wl"))
+	}
+	cr := getObject(t, client, argoClusterGVR, "default", "wl")
+	state, _, _ := unstructured.NestedString(cr.Object, "status", "state")
+	message, _, _ := unstructured.NestedString(cr.Object, "status", "message")
+	if state != "Ready" || message != "certificate expires soon" {
+		t.Errorf("status = %s/%q, want the reconciler message on a Ready status", state, message)
+	}
+
+	// a cleanup that asks to be requeued keeps the finalizer
+	if err := client.Tracker().Update(argoClusterGVR, markDeleted(cr), "default"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Reconcile(getObject(t, client, argoClusterGVR, "default", "wl"))
+	if err != nil || result.RequeueAfter != time.Minute {
+		t.Fatalf("result = %+v, %v", result, err)
+	}
+	if !containsFinalizer(getObject(t, client, argoClusterGVR, "default", "wl"), argoClusterFinalizer) {
+		t.Errorf("finalizer was removed while the cleanup is still running")
+	}
+}
+
+func TestCombineErrors(t *testing.T) {
+	if combineErrors("failed", nil) != nil {
+		t.Errorf("expected nil for no errors")
+	}
+
+	terminal := terminalError(ReasonBlockedNamespace, fmt.Errorf("blocked"))
+	waiting := fmt.Errorf("b: %w", waitingError(ReasonSecretNotFound, fmt.Errorf("no secret")))
+	err := combineErrors("2 of 2 targets failed", []error{terminal, waiting})
+	if kind, reason := classifyError(err); kind != errorWaiting || reason != ReasonSecretNotFound {
+		t.Errorf("classified as %v %s, want waiting %s", kind, reason, ReasonSecretNotFound)
+	}
+	if !strings.Contains(err.Error(), "blocked; b: no secret") {
+		t.Errorf("unexpected message %q", err)
+	}
+	if delay := requeueDelay(err); delay != waitingRequeueDelay {
+		t.Errorf("requeue delay = %s, want %s", delay, waitingRequeueDelay)
+	}
+	pending := waitingErrorAfter(ReasonTokenPending, time.Second, fmt.Errorf("no token"))
+	if delay := requeueDelay(combineErrors("2 of 2 targets failed", []error{waiting, pending})); delay != time.Second {
+		t.Errorf("requeue delay = %s, want the shorter delay of the token", delay)
+	}
+
+	err = combineErrors("2 of 2 targets failed", []error{terminal, fmt.Errorf("timeout")})
+	if kind, _ := classifyError(err); kind != errorTransient {
+		t.Errorf("classified as %v, want transient", kind)
+	}
+}